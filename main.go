@@ -1,13 +1,28 @@
 package main
 
 import (
+	"fmt"
 	"os"
-	"sync"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"k8s.io/component-base/cli"
+	logsapi "k8s.io/component-base/logs/api/v1"
+	_ "k8s.io/component-base/logs/json/register"
+	hscli "k8s.io/csi-hyperstack/pkg/cli"
+	"k8s.io/csi-hyperstack/pkg/config"
 	"k8s.io/csi-hyperstack/pkg/driver"
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+	"k8s.io/csi-hyperstack/pkg/policy"
+	"k8s.io/csi-hyperstack/pkg/tracing"
+	util "k8s.io/csi-hyperstack/pkg/utils"
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
 	"k8s.io/klog/v2"
 
 	"context"
@@ -18,8 +33,13 @@ var (
 	version string
 )
 
+// httpShutdownTimeout bounds the wait for in-flight metrics and health requests on shutdown
+const httpShutdownTimeout = 5 * time.Second
+
 func main() {
 	viper.SetEnvPrefix("HYPERSTACK")
+	// Every setting can be given as HYPERSTACK_<FLAG>, e.g. HYPERSTACK_KUBELET_DIR
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
 	_ = viper.BindEnv("hyperstack-api-key", "HYPERSTACK_API_KEY")
@@ -28,6 +48,14 @@ func main() {
 	viper.SetDefault("endpoint", "unix://var/run/csi.sock")
 	viper.SetDefault("metrics-enabled", true)
 	viper.SetDefault("http-endpoint", ":8080")
+	viper.SetDefault("kubelet-dir", "/var/lib/kubelet")
+	viper.SetDefault("node-reconcile-interval", 5*time.Minute)
+	viper.SetDefault("orphan-gc-interval", time.Hour)
+	viper.SetDefault("orphan-gc-grace-period", 24*time.Hour)
+	viper.SetDefault("metadata-search-order", metadata.DefaultSearchOrder)
+	viper.SetDefault("node-max-volumes", 10)
+
+	loggingConfig := logsapi.NewLoggingConfiguration()
 
 	rootCmd := &cobra.Command{
 		Use:   name,
@@ -36,7 +64,28 @@ func main() {
 			return cmd.Help()
 		},
 		Version: version,
+		// Flags are bound for the command being executed only, so that subcommands
+		// sharing a flag name do not shadow each other in viper
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := logsapi.ValidateAndApply(loggingConfig, nil); err != nil {
+				return err
+			}
+			if err := viper.BindPFlags(cmd.Flags()); err != nil {
+				return err
+			}
+			if path := viper.GetString("config"); path != "" {
+				cfg, err := config.Load(path)
+				if err != nil {
+					return err
+				}
+				return cfg.Apply(viper.GetViper())
+			}
+			return nil
+		},
 	}
+	// Adds --logging-format, "json" writes structured logs with the CSI call ID of every entry
+	logsapi.AddFlags(loggingConfig, rootCmd.PersistentFlags())
+	rootCmd.PersistentFlags().String("config", "", "YAML configuration file, overridden by env vars and flags (env: HYPERSTACK_CONFIG)")
 
 	startCmd := &cobra.Command{
 		Use:   "start",
@@ -56,29 +105,115 @@ func main() {
 	flags.String("endpoint", viper.GetString("endpoint"), "CSI gRPC endpoint")
 	flags.Bool("metrics-enabled", viper.GetBool("metrics-enabled"), "Enables metrics endpoint")
 	flags.String("http-endpoint", viper.GetString("http-endpoint"), "HTTP endpoint")
+	flags.String("grpc-tls-cert-file", "", "Certificate served on a tcp:// endpoint, enables TLS")
+	flags.String("grpc-tls-key-file", "", "Key of --grpc-tls-cert-file")
+	flags.String("grpc-tls-client-ca-file", "", "CA bundle that client certificates must be signed by, enables mutual TLS")
+	flags.Bool("grpc-disable-reflection", false, "Disables the gRPC reflection service")
+	flags.Duration("grpc-keepalive-time", 0, "Idle time after which the gRPC server pings a client (0 keeps the gRPC default of 2h)")
+	flags.Duration("grpc-keepalive-timeout", 0, "How long the gRPC server waits for a keepalive ping ack (0 keeps the gRPC default of 20s)")
+	flags.Uint32("grpc-max-concurrent-streams", 0, "Maximum number of concurrent CSI calls per connection (0 means no limit)")
 	// flags.String("hyperstack-cluster-id", "", "Hyperstack cluster identifier")
 	// flags.String("hyperstack-node-id", "", "Hyperstack node identifier")
-	flags.String("hyperstack-api-key", viper.GetString("hyperstack-api-key"), "Hyperstack API key (env: HYPERSTACK_API_KEY)")
-	flags.String("hyperstack-api-address", viper.GetString("hyperstack-api-address"), "Hyperstack API server address (env: HYPERSTACK_API_ADDRESS)")
+	addHyperstackFlags(flags)
 	// flags.String("hyperstack-environment", viper.GetString("hyperstack-environment"), "Hyperstack environment name")
 	flags.Bool("service-controller-enabled", false, "Enables CSI controller service")
 	flags.Bool("service-node-enabled", false, "Enables CSI node service")
+	flags.String("kubeconfig", "", "Kubeconfig file used instead of the in-cluster config, e.g. to run the driver locally")
+	flags.String("kubelet-dir", viper.GetString("kubelet-dir"), "Kubelet root directory scanned for stale mounts")
+	flags.Duration("node-reconcile-interval", viper.GetDuration("node-reconcile-interval"), "Interval between stale mount cleanups on the node (0 runs it only at startup)")
+	flags.Int64("node-max-volumes", viper.GetInt64("node-max-volumes"), "Maximum number of volumes the scheduler may place on a node")
+	flags.String("metadata-search-order", viper.GetString("metadata-search-order"), "Comma separated instance metadata sources, configDrive and metadataService")
+	flags.Duration("metadata-request-timeout", 10*time.Second, "Timeout of an instance metadata service request (0 disables it)")
+	flags.Bool("dry-run", false, "Log mutating Hyperstack calls, mkfs and mounts as intents instead of running them")
+	flags.String("policy-file", "", "YAML file with volume size limits, allowed types and environments and namespace quotas")
+	flags.String("extra-tags", "", "Comma separated key=value tags stored on every created volume, e.g. for cost allocation")
+	flags.Duration("orphan-gc-interval", viper.GetDuration("orphan-gc-interval"), "Interval between orphaned volume checks on the controller (0 disables it)")
+	addOrphanGCFlags(flags)
+	flags.Duration("health-cache-ttl", 30*time.Second, "How long readiness check results of Probe and /readyz are reused")
+	flags.Duration("drain-timeout", 25*time.Second, "How long in-flight CSI calls may run on SIGTERM before they are cancelled, keep it below the pod terminationGracePeriodSeconds")
+	flags.Bool("tracing", false, "Exports OpenTelemetry traces of CSI calls, Hyperstack API requests, mkfs and mounts over OTLP gRPC")
+	flags.String("tracing-endpoint", "", "OTLP gRPC collector host:port, by default OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317")
+	flags.Bool("tracing-insecure", false, "Connects to the OTLP collector without TLS")
 
 	// _ = startCmd.MarkFlagRequired("hyperstack-cluster-id")
 	// _ = startCmd.MarkFlagRequired("hyperstack-node-id")
-	_ = startCmd.MarkFlagRequired("hyperstack-api-key")
-	_ = startCmd.MarkFlagRequired("hyperstack-api-address")
+	// The API address and key may also come from the config file or env vars, see requireHyperstackSettings
+	startCmd.MarkFlagsMutuallyExclusive("hyperstack-api-key", "hyperstack-api-key-file")
 	// _ = startCmd.MarkFlagRequired("hyperstack-environment")
 
 	rootCmd.AddCommand(startCmd)
 
-	cobra.OnInitialize(func() {
-		err := viper.BindPFlags(flags)
-		if err != nil {
-			klog.Errorf("%v", err)
-		}
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Report and optionally delete orphaned Hyperstack volumes of this cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return driverGC(cmd.Context())
+		},
+	}
+
+	gcFlags := gcCmd.Flags()
+	gcFlags.SortFlags = false
+	addHyperstackFlags(gcFlags)
+	gcFlags.String("kubeconfig", "", "Kubeconfig file used instead of the in-cluster config")
+	addOrphanGCFlags(gcFlags)
+	gcFlags.String("cluster-id", "", "Hyperstack cluster whose volumes are collected, by default the one in the cluster-id label of the node in NODE_NAME")
+
+	gcCmd.MarkFlagsMutuallyExclusive("hyperstack-api-key", "hyperstack-api-key-file")
+
+	rootCmd.AddCommand(gcCmd)
+
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the environment of the controller or node service and report what is misconfigured",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return driverDoctor(cmd)
+		},
+	}
+
+	doctorFlags := doctorCmd.Flags()
+	doctorFlags.SortFlags = false
+	doctorFlags.String("role", "", "Checks to run: controller or node")
+	doctorFlags.String("endpoint", viper.GetString("endpoint"), "CSI gRPC endpoint")
+	addHyperstackFlags(doctorFlags)
+	doctorFlags.String("kubelet-dir", viper.GetString("kubelet-dir"), "Kubelet root directory, checked for shared mount propagation")
+	doctorFlags.String("metadata-search-order", viper.GetString("metadata-search-order"), "Comma separated instance metadata sources, configDrive and metadataService")
+	doctorFlags.Duration("metadata-request-timeout", 10*time.Second, "Timeout of an instance metadata service request (0 disables it)")
+
+	_ = doctorCmd.MarkFlagRequired("role")
+	doctorCmd.MarkFlagsMutuallyExclusive("hyperstack-api-key", "hyperstack-api-key-file")
+
+	rootCmd.AddCommand(doctorCmd)
+
+	// Operator commands for inspecting and fixing volumes, with the same credential flags as start
+	for _, cmd := range []*cobra.Command{
+		hscli.NewVolumesCommand(newHyperstackClient),
+		hscli.NewAttachmentsCommand(newHyperstackClient),
+		hscli.NewClusterCommand(newHyperstackClient),
+	} {
+		addHyperstackFlags(cmd.PersistentFlags())
+		cmd.MarkFlagsMutuallyExclusive("hyperstack-api-key", "hyperstack-api-key-file")
+		rootCmd.AddCommand(cmd)
+	}
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration file",
+	}
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "validate [file]",
+		Short: "Validate a configuration file, by default the one given with --config",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := viper.GetString("config")
+			if len(args) == 1 {
+				path = args[0]
+			}
+			return configValidate(cmd, path)
+		},
 	})
 
+	rootCmd.AddCommand(configCmd)
+
 	rootCmd.SetHelpTemplate(helpTemplate())
 
 	if len(os.Args) < 2 {
@@ -90,6 +225,71 @@ func main() {
 	os.Exit(code)
 }
 
+func addHyperstackFlags(flags *pflag.FlagSet) {
+	flags.String("hyperstack-api-key", viper.GetString("hyperstack-api-key"), "Hyperstack API key (env: HYPERSTACK_API_KEY)")
+	flags.String("hyperstack-api-key-file", "", "File holding the Hyperstack API key, e.g. a mounted Secret, reloaded on change")
+	flags.String("hyperstack-api-address", viper.GetString("hyperstack-api-address"), "Hyperstack API server address (env: HYPERSTACK_API_ADDRESS)")
+	defaults := hyperstack.DefaultClientOpts()
+	flags.Duration("hyperstack-api-timeout", defaults.Timeout, "Timeout of a Hyperstack API request including retries")
+	flags.Int("hyperstack-api-max-retries", defaults.MaxRetries, "Maximum number of retries of a failed Hyperstack API request")
+	flags.Float64("hyperstack-api-qps", defaults.QPS, "Maximum Hyperstack API requests per second (0 disables the limit)")
+	flags.Int("hyperstack-api-burst", defaults.Burst, "Maximum burst of Hyperstack API requests above the QPS limit")
+	flags.String("hyperstack-ca-file", "", "PEM CA bundle trusted for the Hyperstack API in addition to the system roots, reloaded on change")
+	flags.String("hyperstack-proxy", "", "Proxy URL for the Hyperstack API (default from HTTPS_PROXY/NO_PROXY)")
+	flags.String("hyperstack-client-cert", "", "Client certificate for mTLS to the Hyperstack API, reloaded on change")
+	flags.String("hyperstack-client-key", "", "Client key for mTLS to the Hyperstack API, reloaded on change")
+	flags.Bool("hyperstack-insecure-skip-verify", false, "INSECURE: skip verification of the Hyperstack API certificate")
+}
+
+func hyperstackClientOpts() hyperstack.ClientOpts {
+	return hyperstack.ClientOpts{
+		Timeout:    viper.GetDuration("hyperstack-api-timeout"),
+		MaxRetries: viper.GetInt("hyperstack-api-max-retries"),
+		QPS:        viper.GetFloat64("hyperstack-api-qps"),
+		Burst:      viper.GetInt("hyperstack-api-burst"),
+
+		CAFile:             viper.GetString("hyperstack-ca-file"),
+		Proxy:              viper.GetString("hyperstack-proxy"),
+		ClientCertFile:     viper.GetString("hyperstack-client-cert"),
+		ClientKeyFile:      viper.GetString("hyperstack-client-key"),
+		InsecureSkipVerify: viper.GetBool("hyperstack-insecure-skip-verify"),
+
+		APIKeyFile: viper.GetString("hyperstack-api-key-file"),
+	}
+}
+
+// newHyperstackClient returns a client for the Hyperstack API settings of the command
+func newHyperstackClient() (hyperstack.IHyperstack, error) {
+	if err := requireHyperstackSettings(); err != nil {
+		return nil, err
+	}
+	client, err := hyperstack.NewHyperstackClientWithOpts(
+		viper.GetString("hyperstack-api-key"),
+		viper.GetString("hyperstack-api-address"),
+		hyperstackClientOpts(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &hyperstack.Hyperstack{Client: client}, nil
+}
+
+// requireHyperstackSettings checks that the Hyperstack API address and key are set by a flag, env var or the config file
+func requireHyperstackSettings() error {
+	if viper.GetString("hyperstack-api-address") == "" {
+		return fmt.Errorf("the Hyperstack API address must be set with --hyperstack-api-address, HYPERSTACK_API_ADDRESS or hyperstack.apiAddress in the config file")
+	}
+	if viper.GetString("hyperstack-api-key") == "" && viper.GetString("hyperstack-api-key-file") == "" {
+		return fmt.Errorf("the Hyperstack API key must be set with --hyperstack-api-key, HYPERSTACK_API_KEY, --hyperstack-api-key-file or hyperstack.apiKeyFile in the config file")
+	}
+	return nil
+}
+
+func addOrphanGCFlags(flags *pflag.FlagSet) {
+	flags.Bool("orphan-gc-delete", false, "Delete orphaned volumes tagged with this cluster in status available that are older than the grace period")
+	flags.Duration("orphan-gc-grace-period", viper.GetDuration("orphan-gc-grace-period"), "Minimum age of an orphaned volume before it may be deleted")
+}
+
 func helpTemplate() string {
 	return `{{with (or .Long .Short)}}{{.}}{{end}}
 
@@ -115,20 +315,83 @@ Global Flags:
 Environment variables:
   HYPERSTACK_API_KEY                 Hyperstack API key
   HYPERSTACK_API_ADDRESS             Hyperstack API server address
+  HYPERSTACK_CONFIG                  YAML configuration file
+  HYPERSTACK_<FLAG>                  Any other flag, e.g. HYPERSTACK_KUBELET_DIR for --kubelet-dir
 
 Use "{{.CommandPath}} [command] --help" for more information about a command.
 `
 }
 
 func driverStart(ctx context.Context) (err error) {
-	drv := driver.NewDriver(&driver.DriverOpts{
+	if err := requireHyperstackSettings(); err != nil {
+		return err
+	}
+	extraTags, err := util.ParseTags(viper.GetString("extra-tags"))
+	if err != nil {
+		return fmt.Errorf("invalid --extra-tags: %w", err)
+	}
+
+	var volumePolicy *policy.Policy
+	if path := viper.GetString("policy-file"); path != "" {
+		volumePolicy, err = policy.Load(path)
+		if err != nil {
+			return err
+		}
+		klog.Infof("Loaded volume policy from %s", path)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Opts{
+		Enabled:     viper.GetBool("tracing"),
+		Endpoint:    viper.GetString("tracing-endpoint"),
+		Insecure:    viper.GetBool("tracing-insecure"),
+		ServiceName: "csi-hyperstack",
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			klog.Errorf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	drv, err := driver.NewDriver(&driver.DriverOpts{
 		Endpoint: viper.GetString("endpoint"),
 		// HyperstackClusterId:  viper.GetString("hyperstack-cluster-id"),
 		// HyperstackNodeId:     viper.GetString("hyperstack-node-id"),
-		HyperstackApiKey:     viper.GetString("hyperstack-api-key"),
+		HyperstackApiKey:     util.Secret(viper.GetString("hyperstack-api-key")),
 		HyperstackApiAddress: viper.GetString("hyperstack-api-address"),
+		HyperstackApiClient:  hyperstackClientOpts(),
+		ExtraTags:            extraTags,
+		Policy:               volumePolicy,
+		DryRun:               viper.GetBool("dry-run"),
+		Kubeconfig:           viper.GetString("kubeconfig"),
 		// Environment:          viper.GetString("hyperstack-environment"),
+		KubeletDir:            viper.GetString("kubelet-dir"),
+		NodeReconcileInterval: viper.GetDuration("node-reconcile-interval"),
+		MaxVolumesPerNode:     viper.GetInt64("node-max-volumes"),
+		Metadata: metadata.Opts{
+			SearchOrder:    viper.GetString("metadata-search-order"),
+			RequestTimeout: util.MyDuration{Duration: viper.GetDuration("metadata-request-timeout")},
+		},
+		OrphanGCInterval:    viper.GetDuration("orphan-gc-interval"),
+		OrphanGCDelete:      viper.GetBool("orphan-gc-delete"),
+		OrphanGCGracePeriod: viper.GetDuration("orphan-gc-grace-period"),
+		GRPC: driver.GRPCServerOpts{
+			TLSCertFile:          viper.GetString("grpc-tls-cert-file"),
+			TLSKeyFile:           viper.GetString("grpc-tls-key-file"),
+			TLSClientCAFile:      viper.GetString("grpc-tls-client-ca-file"),
+			DisableReflection:    viper.GetBool("grpc-disable-reflection"),
+			KeepaliveTime:        viper.GetDuration("grpc-keepalive-time"),
+			KeepaliveTimeout:     viper.GetDuration("grpc-keepalive-timeout"),
+			MaxConcurrentStreams: viper.GetUint32("grpc-max-concurrent-streams"),
+		},
+		HealthCacheTTL: viper.GetDuration("health-cache-ttl"),
+		DrainTimeout:   viper.GetDuration("drain-timeout"),
 	})
+	if err != nil {
+		return err
+	}
 
 	drv.SetupIdentityService()
 
@@ -140,33 +403,144 @@ func driverStart(ctx context.Context) (err error) {
 		drv.SetupNodeService()
 	}
 
-	wg := &sync.WaitGroup{}
-
-	// Waiting for anything to stop
-	wg.Add(1)
+	// SIGTERM from the kubelet or SIGINT stops the driver, as does a server failing
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	errs := make(chan error, 2)
 
 	srvHttp := driver.RunHttpServer(
 		ctx,
-		wg,
+		errs,
 		viper.GetString("http-endpoint"),
 		viper.GetBool("metrics-enabled"),
+		drv,
 	)
 
-	srvGRPC, err := drv.Run(ctx, wg)
+	srvGRPC, err := drv.Run(ctx, errs)
 	if err != nil {
 		return err
 	}
 
-	wg.Wait()
+	select {
+	case <-ctx.Done():
+		klog.Info("Received shutdown signal")
+	case err = <-errs:
+		klog.Errorf("Shutting down: %v", err)
+	}
+	stop()
 
 	klog.Info("Stopping gRPC server")
-	srvGRPC.Stop()
+	drv.Shutdown(srvGRPC)
 
 	klog.Info("Stopping HTTP server")
-	err = srvHttp.Shutdown(ctx)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if shutdownErr := srvHttp.Shutdown(shutdownCtx); shutdownErr != nil {
+		klog.Errorf("Failed to stop HTTP server: %v", shutdownErr)
+	}
+
+	return err
+}
+
+func driverDoctor(cmd *cobra.Command) error {
+	if err := requireHyperstackSettings(); err != nil {
+		return err
+	}
+	drv, err := driver.NewDriver(&driver.DriverOpts{
+		Endpoint:             viper.GetString("endpoint"),
+		HyperstackApiKey:     util.Secret(viper.GetString("hyperstack-api-key")),
+		HyperstackApiAddress: viper.GetString("hyperstack-api-address"),
+		HyperstackApiClient:  hyperstackClientOpts(),
+		KubeletDir:           viper.GetString("kubelet-dir"),
+		Metadata: metadata.Opts{
+			SearchOrder:    viper.GetString("metadata-search-order"),
+			RequestTimeout: util.MyDuration{Duration: viper.GetDuration("metadata-request-timeout")},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	checks, err := drv.Doctor(cmd.Context(), viper.GetString("role"))
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	for _, c := range checks {
+		result, detail := "PASS", c.Detail
+		if c.Err != nil {
+			result, detail = "FAIL", c.Err.Error()
+			if c.Optional {
+				result = "WARN"
+			} else {
+				failed++
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", result, c.Name, detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func driverGC(ctx context.Context) error {
+	if err := requireHyperstackSettings(); err != nil {
+		return err
+	}
+	drv, err := driver.NewDriver(&driver.DriverOpts{
+		HyperstackApiKey:     util.Secret(viper.GetString("hyperstack-api-key")),
+		HyperstackApiAddress: viper.GetString("hyperstack-api-address"),
+		HyperstackApiClient:  hyperstackClientOpts(),
+		Kubeconfig:           viper.GetString("kubeconfig"),
+	})
+	if err != nil {
+		return err
+	}
+
+	orphans, err := drv.CollectOrphanedVolumes(ctx, driver.OrphanCollectorOpts{
+		Delete:      viper.GetBool("orphan-gc-delete"),
+		GracePeriod: viper.GetDuration("orphan-gc-grace-period"),
+		ClusterID:   viper.GetString("cluster-id"),
+	})
 	if err != nil {
 		return err
 	}
 
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tNAME\tSTATUS\tAGE\tUNTAGGED\tDELETED\tERROR")
+	for _, o := range orphans {
+		age := "unknown"
+		if o.Age >= 0 {
+			age = o.Age.Round(time.Second).String()
+		}
+		errMsg := ""
+		if o.Err != nil {
+			errMsg = o.Err.Error()
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\t%t\t%s\n", o.ID, o.Name, o.Status, age, o.Untagged, o.Deleted, errMsg)
+	}
+	return w.Flush()
+}
+
+func configValidate(cmd *cobra.Command, path string) error {
+	if path == "" {
+		return fmt.Errorf("no configuration file given, pass it as an argument or with --config")
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	if cfg.PolicyFile != nil {
+		if _, err := policy.Load(*cfg.PolicyFile); err != nil {
+			return err
+		}
+	}
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", path)
 	return nil
 }
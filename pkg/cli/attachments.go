@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume_attachment"
+	"github.com/spf13/cobra"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+)
+
+// NewAttachmentsCommand returns the `attachments attach|detach|unprotect` command
+func NewAttachmentsCommand(client ClientFunc) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attachments",
+		Short: "Attach, detach and unprotect volume attachments, e.g. to release a stuck volume",
+	}
+	addOutputFlag(cmd)
+
+	attach := &cobra.Command{
+		Use:   "attach VOLUME_ID --vm-id VM_ID",
+		Short: "Attach a volume to a virtual machine",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			volumeID, err := parseID(args[0], "volume")
+			if err != nil {
+				return err
+			}
+			vmID, _ := cmd.Flags().GetInt("vm-id")
+			hs, err := client()
+			if err != nil {
+				return err
+			}
+			a, err := hs.AttachVolumeToNode(cmd.Context(), vmID, volumeID)
+			if err != nil {
+				return err
+			}
+			t := &table{header: []string{"ID", "VOLUME", "INSTANCE", "DEVICE", "STATUS", "PROTECTED"}}
+			t.add(num(a.Id), num(a.VolumeId), num(a.InstanceId), str(a.Device), str(a.Status), boolean(a.Protected))
+			return printObject(cmd, a, t)
+		},
+	}
+	attach.Flags().Int("vm-id", 0, "ID of the virtual machine to attach the volume to")
+	_ = attach.MarkFlagRequired("vm-id")
+
+	detach := &cobra.Command{
+		Use:   "detach VOLUME_ID",
+		Short: "Unprotect the attachment of a volume and detach it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			volumeID, err := parseID(args[0], "volume")
+			if err != nil {
+				return err
+			}
+			hs, err := client()
+			if err != nil {
+				return err
+			}
+			vmID, _ := cmd.Flags().GetInt("vm-id")
+			if vmID == 0 {
+				if vmID, err = attachedInstance(cmd.Context(), hs, volumeID); err != nil {
+					return err
+				}
+			}
+			result, err := hs.DetachVolumeFromNode(cmd.Context(), vmID, volumeID)
+			if err != nil {
+				return err
+			}
+			return printObject(cmd, result, detachTable(result))
+		},
+	}
+	detach.Flags().Int("vm-id", 0, "ID of the virtual machine to detach the volume from, by default the one it is attached to")
+
+	unprotect := &cobra.Command{
+		Use:   "unprotect VOLUME_ID",
+		Short: "Clear the protection of the attachment of a volume, so that it can be detached",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			volumeID, err := parseID(args[0], "volume")
+			if err != nil {
+				return err
+			}
+			hs, err := client()
+			if err != nil {
+				return err
+			}
+			if err := hs.UnprotectVolumeAttachment(cmd.Context(), volumeID); err != nil {
+				return err
+			}
+			vol, err := hs.GetVolume(cmd.Context(), volumeID)
+			if err != nil {
+				return err
+			}
+			return printObject(cmd, vol, volumeTable(*vol))
+		},
+	}
+
+	cmd.AddCommand(attach, detach, unprotect)
+	return cmd
+}
+
+// attachedInstance returns the ID of the virtual machine a volume is attached to
+func attachedInstance(ctx context.Context, hs hyperstack.IHyperstack, volumeID int) (int, error) {
+	vol, err := hs.GetVolume(ctx, volumeID)
+	if err != nil {
+		return 0, err
+	}
+	if vol.Attachments == nil || len(*vol.Attachments) == 0 || (*vol.Attachments)[0].InstanceId == nil {
+		return 0, fmt.Errorf("volume %d is not attached", volumeID)
+	}
+	return *(*vol.Attachments)[0].InstanceId, nil
+}
+
+func detachTable(result *volume_attachment.DetachVolumes) *table {
+	t := &table{header: []string{"ID", "VOLUME", "INSTANCE", "STATUS"}}
+	if result.VolumeAttachments != nil {
+		for _, a := range *result.VolumeAttachments {
+			t.add(num(a.Id), num(a.VolumeId), num(a.InstanceId), str(a.Status))
+		}
+	}
+	return t
+}
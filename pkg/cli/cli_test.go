@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+)
+
+func newTestCommand(hs *fake.Hyperstack) *cobra.Command {
+	client := func() (hyperstack.IHyperstack, error) { return hs, nil }
+	root := &cobra.Command{Use: "csi-hyperstack", SilenceUsage: true, SilenceErrors: true}
+	root.AddCommand(NewVolumesCommand(client), NewAttachmentsCommand(client), NewClusterCommand(client))
+	return root
+}
+
+func run(t *testing.T, hs *fake.Hyperstack, args ...string) (string, error) {
+	cmd := newTestCommand(hs)
+	out := &bytes.Buffer{}
+	cmd.SetOut(out)
+	cmd.SetArgs(args)
+	err := cmd.ExecuteContext(context.Background())
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	hs := fake.NewHyperstack()
+	hs.AddCluster(1168, "prod", "CANADA-1")
+	available := hs.AddVolume(fake.Volume{Name: "pvc-available", Size: 10, VolumeType: "Cloud-SSD", Environment: "CANADA-1"})
+	attached := hs.AddVolume(fake.Volume{Name: "pvc-attached", Size: 20, VolumeType: "Cloud-SSD", Environment: "CANADA-1"})
+	if available != 1001 || attached != 1002 {
+		t.Fatalf("volume IDs = %d, %d, expected 1001, 1002", available, attached)
+	}
+	if _, err := hs.AttachVolumeToNode(context.Background(), 268040, attached); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		args     []string
+		wantErr  bool
+		contains []string
+	}{
+		{"list", []string{"volumes", "list"}, false, []string{"pvc-available", "pvc-attached", "268040"}},
+		{"list_by_name", []string{"volumes", "list", "--name", "pvc-attached"}, false, []string{"pvc-attached"}},
+		{"get_yaml", []string{"volumes", "get", "1001", "-o", "yaml"}, false, []string{"name: pvc-available"}},
+		{"get_invalid_id", []string{"volumes", "get", "abc"}, true, nil},
+		{"unknown_output", []string{"volumes", "list", "-o", "xml"}, true, nil},
+		{"delete_attached", []string{"volumes", "delete", "1002"}, true, nil},
+		{"cluster_get", []string{"cluster", "get", "1168"}, false, []string{"prod", "CANADA-1"}},
+		{"unprotect", []string{"attachments", "unprotect", "1002"}, false, []string{"pvc-attached", "false"}},
+		{"detach", []string{"attachments", "detach", "1002"}, false, []string{"268040"}},
+		{"attach_without_vm", []string{"attachments", "attach", "1001"}, true, nil},
+		{"attach", []string{"attachments", "attach", "1001", "--vm-id", "268041"}, false, []string{"/dev/vdb", "268041"}},
+		{"delete_detached", []string{"volumes", "delete", "1002"}, false, []string{"volume 1002 deleted"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := run(t, hs, tc.args...)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("%v error = %v, wantErr %v", tc.args, err, tc.wantErr)
+			}
+			for _, s := range tc.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("%v output does not contain %q:\n%s", tc.args, s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	hs := fake.NewHyperstack()
+	hs.AddVolume(fake.Volume{Name: "pvc-1", Size: 10})
+
+	out, err := run(t, hs, "volumes", "list", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var volumes []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &volumes); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out)
+	}
+	if len(volumes) != 1 || volumes[0]["name"] != "pvc-1" {
+		t.Errorf("volumes = %v, expected pvc-1", volumes)
+	}
+}
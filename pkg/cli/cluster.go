@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// NewClusterCommand returns the `cluster get` command
+func NewClusterCommand(client ClientFunc) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Inspect Hyperstack Kubernetes clusters",
+	}
+	addOutputFlag(cmd)
+
+	get := &cobra.Command{
+		Use:   "get CLUSTER_ID",
+		Short: "Show a cluster, e.g. to check the environment volumes are created in",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clusterID, err := parseID(args[0], "cluster")
+			if err != nil {
+				return err
+			}
+			hs, err := client()
+			if err != nil {
+				return err
+			}
+			c, err := hs.GetClusterDetail(cmd.Context(), clusterID)
+			if err != nil {
+				return err
+			}
+			t := &table{header: []string{"ID", "NAME", "STATUS", "ENVIRONMENT", "KUBERNETES", "AGE"}}
+			t.add(num(c.Id), str(c.Name), str(c.Status), str(c.EnvironmentName), str(c.KubernetesVersion), age(c.CreatedAt))
+			return printObject(cmd, c, t)
+		},
+	}
+
+	cmd.AddCommand(get)
+	return cmd
+}
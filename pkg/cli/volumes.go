@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+	"github.com/spf13/cobra"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+)
+
+// ClientFunc returns the Hyperstack client the commands run against, configured by the flags of the command
+type ClientFunc func() (hyperstack.IHyperstack, error)
+
+// NewVolumesCommand returns the `volumes list|get|delete` command
+func NewVolumesCommand(client ClientFunc) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "volumes",
+		Short: "List, inspect and delete Hyperstack volumes",
+	}
+	addOutputFlag(cmd)
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List volumes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hs, err := client()
+			if err != nil {
+				return err
+			}
+			name, _ := cmd.Flags().GetString("name")
+			var volumes []volume.VolumeFields
+			if name != "" {
+				volumes, err = hs.GetVolumesByName(cmd.Context(), name)
+			} else {
+				volumes, err = hs.ListVolumes(cmd.Context())
+			}
+			if err != nil {
+				return err
+			}
+			return printObject(cmd, volumes, volumeTable(volumes...))
+		},
+	}
+	list.Flags().String("name", "", "Only list volumes with this name, e.g. the name of a PV")
+
+	get := &cobra.Command{
+		Use:   "get VOLUME_ID",
+		Short: "Show a volume and its attachments",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			volumeID, err := parseID(args[0], "volume")
+			if err != nil {
+				return err
+			}
+			hs, err := client()
+			if err != nil {
+				return err
+			}
+			vol, err := hs.GetVolume(cmd.Context(), volumeID)
+			if err != nil {
+				return err
+			}
+			return printObject(cmd, vol, volumeTable(*vol))
+		},
+	}
+
+	del := &cobra.Command{
+		Use:   "delete VOLUME_ID",
+		Short: "Delete a volume that is not attached",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			volumeID, err := parseID(args[0], "volume")
+			if err != nil {
+				return err
+			}
+			hs, err := client()
+			if err != nil {
+				return err
+			}
+			vol, err := hs.GetVolume(cmd.Context(), volumeID)
+			if err != nil {
+				return err
+			}
+			if vol.Attachments != nil && len(*vol.Attachments) > 0 {
+				return fmt.Errorf("volume %d is attached, detach it first with `attachments detach %d`", volumeID, volumeID)
+			}
+			if err := hs.DeleteVolume(cmd.Context(), volumeID); err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "volume %d deleted\n", volumeID)
+			return err
+		},
+	}
+
+	cmd.AddCommand(list, get, del)
+	return cmd
+}
+
+func volumeTable(volumes ...volume.VolumeFields) *table {
+	t := &table{header: []string{"ID", "NAME", "STATUS", "SIZE", "TYPE", "ENVIRONMENT", "INSTANCE", "PROTECTED", "AGE"}}
+	for _, v := range volumes {
+		environment := ""
+		if v.Environment != nil {
+			environment = str(v.Environment.Name)
+		}
+		instance, protected := "", ""
+		if v.Attachments != nil && len(*v.Attachments) > 0 {
+			a := (*v.Attachments)[0]
+			instance, protected = num(a.InstanceId), boolean(a.Protected)
+		}
+		t.add(num(v.Id), str(v.Name), str(v.Status), num(v.Size), str(v.VolumeType), environment, instance, protected, age(v.CreatedAt))
+	}
+	return t
+}
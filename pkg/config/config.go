@@ -0,0 +1,281 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+)
+
+// Config is the driver configuration file. Every setting is also available as a flag of the same
+// meaning, and unset settings keep the flag default. The precedence is defaults < file < env < flags.
+//
+// The API key is not part of the file, use hyperstack.apiKeyFile or the HYPERSTACK_API_KEY env var.
+type Config struct {
+	// Endpoint is the CSI gRPC endpoint, unix:// or tcp://
+	Endpoint *string `json:"endpoint,omitempty"`
+	// HTTPEndpoint serves metrics and health checks
+	HTTPEndpoint *string `json:"httpEndpoint,omitempty"`
+	// PolicyFile holds volume size limits, allowed types and environments and namespace quotas
+	PolicyFile *string `json:"policyFile,omitempty"`
+	// ExtraTags are stored on every created volume
+	ExtraTags map[string]string `json:"extraTags,omitempty"`
+	// HealthCacheTTL is how long readiness check results are reused
+	HealthCacheTTL *metav1.Duration `json:"healthCacheTTL,omitempty"`
+	// DrainTimeout is how long in-flight calls may run on shutdown before they are cancelled
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+
+	Features   Features   `json:"features,omitempty"`
+	GRPC       GRPC       `json:"grpc,omitempty"`
+	Hyperstack Hyperstack `json:"hyperstack,omitempty"`
+	Metadata   Metadata   `json:"metadata,omitempty"`
+	Node       Node       `json:"node,omitempty"`
+	OrphanGC   OrphanGC   `json:"orphanGC,omitempty"`
+	Tracing    Tracing    `json:"tracing,omitempty"`
+}
+
+// Features toggles the services and modes of the driver
+type Features struct {
+	Controller *bool `json:"controller,omitempty"`
+	Node       *bool `json:"node,omitempty"`
+	Metrics    *bool `json:"metrics,omitempty"`
+	DryRun     *bool `json:"dryRun,omitempty"`
+}
+
+// GRPC configures the CSI gRPC server
+type GRPC struct {
+	// TLSCertFile and TLSKeyFile enable TLS on a tcp:// endpoint
+	TLSCertFile *string `json:"tlsCertFile,omitempty"`
+	TLSKeyFile  *string `json:"tlsKeyFile,omitempty"`
+	// TLSClientCAFile enables mutual TLS
+	TLSClientCAFile      *string          `json:"tlsClientCAFile,omitempty"`
+	DisableReflection    *bool            `json:"disableReflection,omitempty"`
+	KeepaliveTime        *metav1.Duration `json:"keepaliveTime,omitempty"`
+	KeepaliveTimeout     *metav1.Duration `json:"keepaliveTimeout,omitempty"`
+	MaxConcurrentStreams *uint32          `json:"maxConcurrentStreams,omitempty"`
+}
+
+// Hyperstack configures the connection to the Hyperstack API
+type Hyperstack struct {
+	APIAddress         *string          `json:"apiAddress,omitempty"`
+	APIKeyFile         *string          `json:"apiKeyFile,omitempty"`
+	Timeout            *metav1.Duration `json:"timeout,omitempty"`
+	MaxRetries         *int             `json:"maxRetries,omitempty"`
+	QPS                *float64         `json:"qps,omitempty"`
+	Burst              *int             `json:"burst,omitempty"`
+	CAFile             *string          `json:"caFile,omitempty"`
+	Proxy              *string          `json:"proxy,omitempty"`
+	ClientCert         *string          `json:"clientCert,omitempty"`
+	ClientKey          *string          `json:"clientKey,omitempty"`
+	InsecureSkipVerify *bool            `json:"insecureSkipVerify,omitempty"`
+}
+
+// Metadata configures how the instance metadata is read on the node
+type Metadata struct {
+	// SearchOrder is a comma separated list of configDrive and metadataService
+	SearchOrder    *string          `json:"searchOrder,omitempty"`
+	RequestTimeout *metav1.Duration `json:"requestTimeout,omitempty"`
+}
+
+// Node configures the node service
+type Node struct {
+	KubeletDir        *string          `json:"kubeletDir,omitempty"`
+	ReconcileInterval *metav1.Duration `json:"reconcileInterval,omitempty"`
+	// MaxVolumes is the number of volumes the scheduler may place on a node
+	MaxVolumes *int64 `json:"maxVolumes,omitempty"`
+}
+
+// OrphanGC configures the orphaned volume collector of the controller
+type OrphanGC struct {
+	Interval    *metav1.Duration `json:"interval,omitempty"`
+	Delete      *bool            `json:"delete,omitempty"`
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// Tracing configures the OpenTelemetry exporter
+type Tracing struct {
+	Enabled  *bool   `json:"enabled,omitempty"`
+	Endpoint *string `json:"endpoint,omitempty"`
+	Insecure *bool   `json:"insecure,omitempty"`
+}
+
+// Load reads a configuration from a YAML or JSON file. Unknown keys are an error.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks the values of the configuration
+func (c *Config) Validate() error {
+	if c.Endpoint != nil && !strings.HasPrefix(*c.Endpoint, "unix://") && !strings.HasPrefix(*c.Endpoint, "tcp://") {
+		return fmt.Errorf("endpoint: %q must start with unix:// or tcp://", *c.Endpoint)
+	}
+	for key, value := range c.ExtraTags {
+		if key == "" || strings.ContainsAny(key, ",=") || strings.Contains(value, ",") {
+			return fmt.Errorf("extraTags: invalid tag %q=%q", key, value)
+		}
+	}
+
+	g := c.GRPC
+	if (g.TLSCertFile == nil) != (g.TLSKeyFile == nil) {
+		return fmt.Errorf("grpc.tlsCertFile and grpc.tlsKeyFile must be set together")
+	}
+	if g.TLSClientCAFile != nil && g.TLSCertFile == nil {
+		return fmt.Errorf("grpc.tlsClientCAFile requires grpc.tlsCertFile and grpc.tlsKeyFile")
+	}
+	if c.Endpoint != nil && g.TLSCertFile != nil && !strings.HasPrefix(*c.Endpoint, "tcp://") {
+		return fmt.Errorf("grpc.tlsCertFile requires a tcp:// endpoint")
+	}
+
+	h := c.Hyperstack
+	if h.APIAddress != nil {
+		if u, err := url.Parse(*h.APIAddress); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("hyperstack.apiAddress: %q is not a URL", *h.APIAddress)
+		}
+	}
+	if h.Proxy != nil {
+		if u, err := url.Parse(*h.Proxy); err != nil || u.Host == "" {
+			return fmt.Errorf("hyperstack.proxy: %q is not a URL", *h.Proxy)
+		}
+	}
+	if (h.ClientCert == nil) != (h.ClientKey == nil) {
+		return fmt.Errorf("hyperstack.clientCert and hyperstack.clientKey must be set together")
+	}
+	if h.MaxRetries != nil && *h.MaxRetries < 0 {
+		return fmt.Errorf("hyperstack.maxRetries must not be negative")
+	}
+	if h.QPS != nil && *h.QPS < 0 {
+		return fmt.Errorf("hyperstack.qps must not be negative")
+	}
+	if h.Burst != nil && *h.Burst < 0 {
+		return fmt.Errorf("hyperstack.burst must not be negative")
+	}
+
+	if c.Metadata.SearchOrder != nil {
+		if err := metadata.CheckMetadataSearchOrder(*c.Metadata.SearchOrder); err != nil {
+			return fmt.Errorf("metadata.searchOrder: %w", err)
+		}
+	}
+	if c.Node.MaxVolumes != nil && *c.Node.MaxVolumes < 1 {
+		return fmt.Errorf("node.maxVolumes must be at least 1")
+	}
+
+	durations := map[string]*metav1.Duration{
+		"healthCacheTTL":          c.HealthCacheTTL,
+		"drainTimeout":            c.DrainTimeout,
+		"grpc.keepaliveTime":      g.KeepaliveTime,
+		"grpc.keepaliveTimeout":   g.KeepaliveTimeout,
+		"hyperstack.timeout":      h.Timeout,
+		"metadata.requestTimeout": c.Metadata.RequestTimeout,
+		"node.reconcileInterval":  c.Node.ReconcileInterval,
+		"orphanGC.interval":       c.OrphanGC.Interval,
+		"orphanGC.gracePeriod":    c.OrphanGC.GracePeriod,
+	}
+	for name, d := range durations {
+		if d != nil && d.Duration < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// Settings returns the values set in the configuration keyed by the name of the matching flag
+func (c *Config) Settings() map[string]interface{} {
+	s := map[string]interface{}{}
+	set(s, "endpoint", c.Endpoint)
+	set(s, "http-endpoint", c.HTTPEndpoint)
+	set(s, "policy-file", c.PolicyFile)
+	if len(c.ExtraTags) > 0 {
+		s["extra-tags"] = formatTags(c.ExtraTags)
+	}
+	setDuration(s, "health-cache-ttl", c.HealthCacheTTL)
+	setDuration(s, "drain-timeout", c.DrainTimeout)
+
+	set(s, "service-controller-enabled", c.Features.Controller)
+	set(s, "service-node-enabled", c.Features.Node)
+	set(s, "metrics-enabled", c.Features.Metrics)
+	set(s, "dry-run", c.Features.DryRun)
+
+	g := c.GRPC
+	set(s, "grpc-tls-cert-file", g.TLSCertFile)
+	set(s, "grpc-tls-key-file", g.TLSKeyFile)
+	set(s, "grpc-tls-client-ca-file", g.TLSClientCAFile)
+	set(s, "grpc-disable-reflection", g.DisableReflection)
+	setDuration(s, "grpc-keepalive-time", g.KeepaliveTime)
+	setDuration(s, "grpc-keepalive-timeout", g.KeepaliveTimeout)
+	set(s, "grpc-max-concurrent-streams", g.MaxConcurrentStreams)
+
+	h := c.Hyperstack
+	set(s, "hyperstack-api-address", h.APIAddress)
+	set(s, "hyperstack-api-key-file", h.APIKeyFile)
+	setDuration(s, "hyperstack-api-timeout", h.Timeout)
+	set(s, "hyperstack-api-max-retries", h.MaxRetries)
+	set(s, "hyperstack-api-qps", h.QPS)
+	set(s, "hyperstack-api-burst", h.Burst)
+	set(s, "hyperstack-ca-file", h.CAFile)
+	set(s, "hyperstack-proxy", h.Proxy)
+	set(s, "hyperstack-client-cert", h.ClientCert)
+	set(s, "hyperstack-client-key", h.ClientKey)
+	set(s, "hyperstack-insecure-skip-verify", h.InsecureSkipVerify)
+
+	set(s, "metadata-search-order", c.Metadata.SearchOrder)
+	setDuration(s, "metadata-request-timeout", c.Metadata.RequestTimeout)
+
+	set(s, "kubelet-dir", c.Node.KubeletDir)
+	setDuration(s, "node-reconcile-interval", c.Node.ReconcileInterval)
+	set(s, "node-max-volumes", c.Node.MaxVolumes)
+
+	setDuration(s, "orphan-gc-interval", c.OrphanGC.Interval)
+	set(s, "orphan-gc-delete", c.OrphanGC.Delete)
+	setDuration(s, "orphan-gc-grace-period", c.OrphanGC.GracePeriod)
+
+	set(s, "tracing", c.Tracing.Enabled)
+	set(s, "tracing-endpoint", c.Tracing.Endpoint)
+	set(s, "tracing-insecure", c.Tracing.Insecure)
+	return s
+}
+
+// Apply makes the values set in the configuration the config layer of v, which takes precedence over
+// defaults but not over environment variables and flags
+func (c *Config) Apply(v *viper.Viper) error {
+	return v.MergeConfigMap(c.Settings())
+}
+
+func set[T any](s map[string]interface{}, key string, value *T) {
+	if value != nil {
+		s[key] = *value
+	}
+}
+
+func setDuration(s map[string]interface{}, key string, value *metav1.Duration) {
+	if value != nil {
+		s[key] = value.Duration
+	}
+}
+
+// formatTags returns the tags in the key=value,... format of the --extra-tags flag
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
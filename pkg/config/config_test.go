@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const testConfig = `
+endpoint: unix:///csi/csi.sock
+extraTags:
+  team: data
+  cost-center: "42"
+features:
+  controller: true
+  metrics: false
+hyperstack:
+  apiAddress: https://infrahub-api.nexgencloud.com/v1
+  apiKeyFile: /etc/hyperstack/apiKey
+  timeout: 1m
+  qps: 5
+metadata:
+  searchOrder: configDrive
+  requestTimeout: 5s
+node:
+  maxVolumes: 16
+orphanGC:
+  interval: 30m
+`
+
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid_config", testConfig, false},
+		{"empty_config", "", false},
+		{"unknown_field", "endpoints: unix:///csi/csi.sock", true},
+		{"unknown_nested_field", "hyperstack: {apiKey: secret}", true},
+		{"bad_endpoint", "endpoint: /csi/csi.sock", true},
+		{"bad_duration", "hyperstack: {timeout: soon}", true},
+		{"negative_duration", "orphanGC: {gracePeriod: -1h}", true},
+		{"bad_search_order", "metadata: {searchOrder: dns}", true},
+		{"cert_without_key", "hyperstack: {clientCert: /tls/tls.crt}", true},
+		{"no_max_volumes", "node: {maxVolumes: 0}", true},
+		{"bad_tag", "extraTags: {team: 'a,b'}", true},
+		{"grpc_tls", "endpoint: tcp://0.0.0.0:10000\ngrpc: {tlsCertFile: /tls/tls.crt, tlsKeyFile: /tls/tls.key, tlsClientCAFile: /tls/ca.crt}", false},
+		{"grpc_tls_on_unix_socket", "endpoint: unix:///csi/csi.sock\ngrpc: {tlsCertFile: /tls/tls.crt, tlsKeyFile: /tls/tls.key}", true},
+		{"grpc_client_ca_without_cert", "grpc: {tlsClientCAFile: /tls/ca.crt}", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tc.content)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSettings(t *testing.T) {
+	c, err := loadTestConfig(t, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Settings()
+
+	expected := map[string]interface{}{
+		"endpoint":                   "unix:///csi/csi.sock",
+		"extra-tags":                 "cost-center=42,team=data",
+		"service-controller-enabled": true,
+		"metrics-enabled":            false,
+		"hyperstack-api-address":     "https://infrahub-api.nexgencloud.com/v1",
+		"hyperstack-api-key-file":    "/etc/hyperstack/apiKey",
+		"hyperstack-api-timeout":     time.Minute,
+		"hyperstack-api-qps":         5.0,
+		"metadata-search-order":      "configDrive",
+		"metadata-request-timeout":   5 * time.Second,
+		"node-max-volumes":           int64(16),
+		"orphan-gc-interval":         30 * time.Minute,
+	}
+	if len(s) != len(expected) {
+		t.Errorf("Settings() = %v, expected %v", s, expected)
+	}
+	for key, value := range expected {
+		if s[key] != value {
+			t.Errorf("Settings()[%q] = %v (%T), expected %v (%T)", key, s[key], s[key], value, value)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	c, err := loadTestConfig(t, `
+endpoint: unix:///file.sock
+hyperstack:
+  apiAddress: https://file
+  timeout: 1m
+node:
+  kubeletDir: /file/kubelet
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := viper.New()
+	v.SetEnvPrefix("HYPERSTACK")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+	t.Setenv("HYPERSTACK_HYPERSTACK_API_TIMEOUT", "2m")
+	t.Setenv("HYPERSTACK_KUBELET_DIR", "/env/kubelet")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("endpoint", "unix:///default.sock", "")
+	flags.String("http-endpoint", ":8080", "")
+	flags.String("hyperstack-api-address", "", "")
+	flags.Duration("hyperstack-api-timeout", 30*time.Second, "")
+	flags.String("kubelet-dir", "/var/lib/kubelet", "")
+	if err := flags.Parse([]string{"--kubelet-dir=/flag/kubelet"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.BindPFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Apply(v); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each key is set up to the layer that is expected to win
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"http-endpoint", ":8080"},
+		{"endpoint", "unix:///file.sock"},
+		{"hyperstack-api-address", "https://file"},
+		{"hyperstack-api-timeout", "2m"},
+		{"kubelet-dir", "/flag/kubelet"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			if got := v.GetString(tc.key); got != tc.expected {
+				t.Errorf("%s = %q, expected %q", tc.key, got, tc.expected)
+			}
+		})
+	}
+}
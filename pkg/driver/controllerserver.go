@@ -1,23 +1,29 @@
 package driver
 
 import (
+	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+	"k8s.io/csi-hyperstack/pkg/policy"
 	util "k8s.io/csi-hyperstack/pkg/utils"
-	kubernetes "k8s.io/csi-hyperstack/pkg/utils/kubernetes"
-	"k8s.io/csi-hyperstack/pkg/utils/metadata"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/keymutex"
 )
 
 type controllerServer struct {
 	driver *Driver
+	// namespaceLocks serializes CreateVolume calls of namespaces with a quota
+	namespaceLocks keymutex.KeyMutex
 	csi.UnimplementedControllerServer
 }
 
@@ -25,18 +31,27 @@ const (
 	hyperstackCSIClusterIDKey    = "hyperstack.csi.nexgencloud.com/cluster"
 	hyperstackEnvironmentNameKey = "hyperstack.csi.nexgencloud.com/environment"
 	hyperstackClusterIdLabelKey  = "hyperstack.cloud/cluster-id"
+
+	// volumeTagsParameterKey is the StorageClass parameter with extra key=value tags stored on the volume
+	volumeTagsParameterKey = "tags"
+)
+
+var (
+	// attachTimeout is how long ControllerPublishVolume waits for the device of an attachment, below
+	// the 15s default timeout of the csi-attacher so that the call can report why it failed
+	attachTimeout      = 10 * time.Second
+	attachPollInterval = 2 * time.Second
 )
 
 func (cs *controllerServer) CreateVolume(
 	ctx context.Context,
 	req *csi.CreateVolumeRequest,
 ) (*csi.CreateVolumeResponse, error) {
-	klog.Infof("==============Create volume called ==============")
-	klog.Infof("CreateVolume: Driver opts: %#v\n", cs.driver.opts)
+	logger := klog.FromContext(ctx)
 	if err := cs.driver.ValidateControllerServiceRequest(
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 	); err != nil {
-		klog.Errorf("invalid create volume req: %v", req)
+		logger.Error(err, "Invalid CreateVolume request")
 		return nil, err
 	}
 	volName := req.GetName()
@@ -56,10 +71,34 @@ func (cs *controllerServer) CreateVolume(
 	}
 	volSizeGB := int(util.RoundUpSize(volSizeBytes, 1024*1024*1024))
 	volType := req.GetParameters()["type"]
+	volContext := map[string]string{}
+	if encrypted, ok := req.GetParameters()[volumeContextEncryptedKey]; ok {
+		if _, err := strconv.ParseBool(encrypted); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: invalid value %q for parameter %q", encrypted, volumeContextEncryptedKey)
+		}
+		volContext[volumeContextEncryptedKey] = encrypted
+	}
+	// The PVC is kept in the volume context so that later calls can record Events on it
+	for _, key := range []string{pvcNameKey, pvcNamespaceKey} {
+		if v := req.GetParameters()[key]; v != "" {
+			volContext[key] = v
+		}
+	}
+	volTags, err := util.ParseTags(req.GetParameters()[volumeTagsParameterKey])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: invalid parameter %q: %v", volumeTagsParameterKey, err)
+	}
+	if err := cs.driver.opts.Policy.CheckVolume(volType, volSizeGB); err != nil {
+		logger.Info("Rejecting volume", "name", volName, "err", err)
+		if status.Code(err) == codes.InvalidArgument {
+			cs.driver.recordWarning(req.GetParameters(), eventReasonVolumeTypeInvalid, "Volume %s was not created: %v", volName, err)
+		}
+		return nil, err
+	}
 	cloud := cs.driver.hyperstackClient
 	volumes, err := cloud.GetVolumesByName(ctx, volName)
 	if err != nil {
-		klog.Errorf("CreateVolume: Failed to query for existing Volume during CreateVolume: %v", err)
+		logger.Error(err, "Failed to query for existing volumes", "name", volName)
 		return nil, status.Errorf(codes.Internal, "Failed to get volumes: %v", err)
 	}
 
@@ -67,100 +106,145 @@ func (cs *controllerServer) CreateVolume(
 		if volSizeGB != *volumes[0].Size {
 			return nil, status.Error(codes.AlreadyExists, "CreateVolume: Volume Already exists with same name and different capacity")
 		}
-		klog.Infof("CreateVolume: Volume %d already exists in Environment %s: size %d GiB", *volumes[0].Id, *volumes[0].Environment.Name, *volumes[0].Size)
-		return getCreateVolumeResponse(&volumes[0], req.GetAccessibilityRequirements()), nil
+		logger.Info("Volume already exists", "volumeID", *volumes[0].Id, "environment", *volumes[0].Environment.Name, "sizeGiB", *volumes[0].Size)
+		return getCreateVolumeResponse(&volumes[0], volContext, req.GetAccessibilityRequirements()), nil
 	} else if len(volumes) > 1 {
-		klog.Infof("CreateVolume: found multiple existing volumes with selected name (%s) during create", volName)
+		logger.Info("Found multiple existing volumes with the same name", "name", volName)
 		return nil, status.Error(codes.Internal, "CreateVolume: Multiple volumes reported by Cinder with same name")
 	}
-	clusterId, err := kubernetes.GetNodeLabel(hyperstackClusterIdLabelKey)
+	clusterId, err := cs.driver.getNodeLabel(ctx, hyperstackClusterIdLabelKey)
 	if err != nil {
-		klog.Errorf("failed to get node label: %v", err)
+		logger.Error(err, "Failed to get node label", "label", hyperstackClusterIdLabelKey)
 	}
-	klog.Infof("CreateVolume: Cluster label -\n%s:%s", hyperstackClusterIdLabelKey, clusterId)
+	logger.V(4).Info("Got cluster label", "label", hyperstackClusterIdLabelKey, "clusterID", clusterId)
 
-	properties := map[string]string{
-		hyperstackCSIClusterIDKey: clusterId,
+	// Provenance keys are set last so that tags from the StorageClass or --extra-tags cannot override them
+	properties := map[string]string{}
+	for k, v := range cs.driver.opts.ExtraTags {
+		properties[k] = v
 	}
-	for _, mKey := range []string{"csi.storage.k8s.io/pvc/name", "csi.storage.k8s.io/pvc/namespace", "csi.storage.k8s.io/pv/name"} {
+	for k, v := range volTags {
+		properties[k] = v
+	}
+	properties[hyperstackCSIClusterIDKey] = clusterId
+	for _, mKey := range []string{pvcNameKey, pvcNamespaceKey, "csi.storage.k8s.io/pv/name"} {
 		if v, ok := req.Parameters[mKey]; ok {
 			properties[mKey] = v
 		}
 	}
+	if _, err := hyperstack.VolumeDescription(properties); err != nil {
+		logger.Info("Rejecting volume", "name", volName, "err", err)
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
+	}
 
 	clusterIdInt, err := strconv.Atoi(clusterId)
 	if err != nil {
-		klog.Errorf("CreateVolume: Failed to convert cluster ID to int: %v", err)
+		logger.Error(err, "Failed to convert cluster ID to int", "clusterID", clusterId)
 		return nil, status.Errorf(codes.Internal, "CreateVolume failed with error %v", err)
 	}
 	clusterDetail, err := cloud.GetClusterDetail(ctx, clusterIdInt)
 	if err != nil {
-		klog.Errorf("CreateVolume: Failed to GetClusterDetail: %v", err)
+		logger.Error(err, "Failed to get cluster detail", "clusterID", clusterIdInt)
 		return nil, status.Errorf(codes.Internal, "CreateVolume failed with error %v", err)
 	}
 	volEnvironment := *clusterDetail.EnvironmentName
-	klog.Infof("CreateVolume: Creating volume %s with size %d GiB in Environment: %s", volName, volSizeGB, volEnvironment)
+	if err := cs.driver.opts.Policy.CheckEnvironment(volEnvironment); err != nil {
+		logger.Info("Rejecting volume", "name", volName, "err", err)
+		return nil, err
+	}
+	unlockNamespace := cs.lockNamespaceQuota(req.GetParameters()[pvcNamespaceKey])
+	if err := cs.checkNamespaceQuota(ctx, clusterId, req.GetParameters()[pvcNamespaceKey], 0, 1, volSizeGB); err != nil {
+		unlockNamespace()
+		var violation *policy.Violation
+		if errors.As(err, &violation) {
+			logger.Info("Rejecting volume", "name", volName, "err", err)
+			cs.driver.recordWarning(req.GetParameters(), eventReasonQuotaExceeded, "Volume %s was not created: %v", volName, err)
+			return nil, err
+		}
+		logger.Error(err, "Failed to check namespace quota")
+		return nil, status.Errorf(codes.Internal, "CreateVolume failed with error %v", err)
+	}
+	logger.Info("Creating volume", "name", volName, "sizeGiB", volSizeGB, "environment", volEnvironment)
 	vol, err := cloud.CreateVolume(ctx, volName, volSizeGB, volType, volEnvironment, properties)
+	unlockNamespace()
 	if err != nil {
-		klog.Errorf("CreateVolume: Failed to CreateVolume: %v", err)
+		logger.Error(err, "Failed to create volume", "name", volName)
+		if reason, code := createVolumeErrorReason(err); reason != "" {
+			cs.driver.recordWarning(req.GetParameters(), reason, "Hyperstack rejected volume %s of type %q: %v", volName, volType, err)
+			return nil, status.Errorf(code, "CreateVolume failed with error %v", err)
+		}
 		return nil, status.Errorf(codes.Internal, "CreateVolume failed with error %v", err)
 	}
 	maxAttempts := 15
-	klog.Infof("CreateVolume: Polling for volume to be available with max attempts: %d", maxAttempts)
+	logger.V(4).Info("Polling for volume to be available", "volumeID", *vol.Id, "maxAttempts", maxAttempts)
+	pollCtx, pollSpan := tracer.Start(ctx, "CreateVolume wait for available", trace.WithAttributes(attribute.Int("volume.id", *vol.Id)))
 	for i := 0; i < maxAttempts; i++ {
-		klog.Infof("CreateVolume: Polling for volume to be available %d/%d", i+1, maxAttempts)
-		v, err := cloud.GetVolume(ctx, *vol.Id)
+		v, err := cloud.GetVolume(pollCtx, *vol.Id)
 		if err != nil {
-			klog.Errorf("CreateVolume: Failed to GetVolume while polling volume availability: %v", err)
+			endSpan(pollSpan, err)
+			logger.Error(err, "Failed to get volume while polling for it to be available", "volumeID", *vol.Id)
 			return nil, status.Errorf(codes.Internal, "CreateVolume failed with error %v", err)
 		}
 		if v == nil {
-			klog.Warningf("CreateVolume: GetVolume attempt %d returned nil volume", i+1)
-			time.Sleep(2 * time.Second)
+			logger.Info("Got no volume while polling for it to be available", "volumeID", *vol.Id, "attempt", i+1)
+			if err := sleepCtx(pollCtx, 2*time.Second); err != nil {
+				endSpan(pollSpan, err)
+				return nil, err
+			}
 			continue
 		} else {
-			klog.Infof("CreateVolume: GetVolume attempt %d returned volume: %+v", i+1, protosanitizer.StripSecrets(v))
+			logger.V(4).Info("Polled volume", "volumeID", *v.Id, "status", *v.Status, "attempt", i+1, "maxAttempts", maxAttempts)
 			if *v.Status == "available" {
-				klog.Infof("CreateVolume: Volume is now available-\nID: %v\nStatus: %v\nVolume Name: %v", *v.Id, *v.Status, *v.Name)
 				vol = v
 				break
 			}
 		}
-		time.Sleep(2 * time.Second)
+		if err := sleepCtx(pollCtx, 2*time.Second); err != nil {
+			endSpan(pollSpan, err)
+			return nil, err
+		}
 	}
+	pollSpan.End()
 
-	klog.Infof("CreateVolume: Volume Successfully created-Volume Name: %s\nEnvironment: %s\nSize: %d GiB\nStatus: %s", *vol.Name, *vol.Environment.Name, *vol.Size, *vol.Status)
-	return getCreateVolumeResponse(vol, req.GetAccessibilityRequirements()), nil
+	logger.Info("Created volume", "volumeID", *vol.Id, "name", *vol.Name, "environment", *vol.Environment.Name, "sizeGiB", *vol.Size, "status", *vol.Status)
+	return getCreateVolumeResponse(vol, volContext, req.GetAccessibilityRequirements()), nil
 }
 
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.Infof("\n==============DeleteVolume: called================\n")
-	klog.Infof("DeleteVolume: called with args %+v", protosanitizer.StripSecrets(*req))
+	logger := klog.FromContext(ctx)
 	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteVolume: Volume ID must be provided")
+	}
 	cloud := cs.driver.hyperstackClient
+	// Deleting a volume that does not exist succeeds, so that retried calls are idempotent
 	volumeIDInt, err := strconv.Atoi(volumeID)
 	if err != nil {
-		klog.Errorf("DeleteVolume: Failed to convert volume ID to int: %v", err)
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: Failed to convert volume ID to int: %v", err)
+		logger.Info("Volume ID is not a Hyperstack volume ID, assuming it is already deleted", "volumeID", volumeID)
+		return &csi.DeleteVolumeResponse{}, nil
 	}
 	getVolume, err := cloud.GetVolume(ctx, volumeIDInt)
+	if errors.Is(err, util.ErrNotFound) {
+		logger.Info("Volume not found, assuming it is already deleted", "volumeID", volumeIDInt)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
 	if err != nil {
-		klog.Errorf("DeleteVolume: Failed to GetVolume from hyperstack: %v", err)
-		return nil, status.Errorf(codes.NotFound, "DeleteVolume: Failed to GetVolume from hyperstack: %v", err)
+		logger.Error(err, "Failed to get volume", "volumeID", volumeIDInt)
+		return nil, status.Errorf(codes.Internal, "DeleteVolume: Failed to GetVolume from hyperstack: %v", err)
 	}
 	if getVolume == nil {
-		klog.Errorf("DeleteVolume: GetVolume returned nil volume")
+		logger.Error(nil, "Got no volume", "volumeID", volumeIDInt)
 		return nil, status.Errorf(codes.NotFound, "DeleteVolume: GetVolume returned nil volume")
 	}
 	if *getVolume.Status == "in-use" {
-		klog.Errorf("DeleteVolume: Volume %s is in use", *getVolume.Name)
+		logger.Info("Volume is in use", "volumeID", volumeIDInt, "name", *getVolume.Name)
 		return nil, status.Errorf(codes.FailedPrecondition, "DeleteVolume: Volume %s is in use", *getVolume.Name)
 	}
 	if *getVolume.Status == "available" {
-		klog.Infof("DeleteVolume: Volume %s is available", *getVolume.Name)
+		logger.Info("Deleting volume", "volumeID", volumeIDInt, "name", *getVolume.Name)
 		err = cloud.DeleteVolume(ctx, volumeIDInt)
 		if err != nil {
-			klog.Errorf("DeleteVolume: Failed to DeleteVolume from hyperstack: %v", err)
+			logger.Error(err, "Failed to delete volume", "volumeID", volumeIDInt)
 			return nil, status.Errorf(codes.Internal, "DeleteVolume: Failed to DeleteVolume from hyperstack: %v", err)
 		}
 	}
@@ -168,41 +252,44 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 }
 
 func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	klog.Infof("\n==============ControllerPublishVolume: called================\n")
-	klog.Infof("ControllerPublishVolume: called with args %+v", protosanitizer.StripSecrets(*req))
+	logger := klog.FromContext(ctx)
 
-	searchOrder := "metadataService,configDrive"
-	metadataProvider := metadata.GetMetadataProvider(searchOrder)
-	metadataProvider.GetHyperstackVMId()
-	virtualMachineId := req.NodeId
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Volume ID must be provided")
+	}
+	virtualMachineId := req.GetNodeId()
+	if len(virtualMachineId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Node ID must be provided")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Volume capability must be provided")
+	}
 	vmId, err := strconv.Atoi(virtualMachineId)
 	if err != nil {
-		klog.Errorf("ControllerPublishVolume: Failed to convert virtual machine ID to int: %v", err)
-		return nil, status.Errorf(codes.Internal, "Failed to convert virtual machine ID to int: %v", err)
+		logger.Error(err, "Failed to convert virtual machine ID to int", "nodeID", virtualMachineId)
+		return nil, status.Errorf(codes.NotFound, "Failed to convert virtual machine ID to int: %v", err)
 	}
-	volumeID := req.GetVolumeId()
 	volumeIDInt, err := strconv.Atoi(volumeID)
 	if err != nil {
-		klog.Errorf("ControllerPublishVolume: Failed to convert volume ID to int: %v", err)
-		return nil, status.Errorf(codes.Internal, "Failed to convert volume ID to int: %v", err)
+		logger.Error(err, "Failed to convert volume ID to int", "volumeID", volumeID)
+		return nil, status.Errorf(codes.NotFound, "Failed to convert volume ID to int: %v", err)
 	}
-	klog.Infof("ControllerPublishVolume: VM and Volume ID while attaching volume to node: %d, %d", vmId, volumeIDInt)
+	logger = logger.WithValues("volumeID", volumeIDInt, "vmID", vmId)
 	cloud := cs.driver.hyperstackClient
 	getVolume, err := cloud.GetVolume(ctx, volumeIDInt)
-	klog.Infof("ControllerPublishVolume: GetVolume returned volume: %+v", getVolume)
 	if err != nil {
-		klog.Errorf("ControllerPublishVolume: Failed to GetVolume from hyperstack: %v", err)
+		logger.Error(err, "Failed to get volume")
 		return nil, status.Errorf(codes.NotFound, "ControllerPublishVolume: Failed to GetVolume from hyperstack: %v", err)
 	}
 	if getVolume == nil {
-		klog.Errorf("ControllerPublishVolume: GetVolume returned nil volume")
+		logger.Error(nil, "Got no volume")
 		return nil, status.Errorf(codes.NotFound, "ControllerPublishVolume: GetVolume returned nil volume")
 	}
-	klog.Infof("ControllerPublishVolume: GetVolume succeeded -\nStatus: %s\nName: %s\nID: %d\nSize:%d", *getVolume.Status, *getVolume.Name, *getVolume.Id, *getVolume.Size)
+	logger.V(4).Info("Got volume", "name", *getVolume.Name, "status", *getVolume.Status, "sizeGiB", *getVolume.Size)
 	if *getVolume.Status == "in-use" { //Volume is already attached
-		klog.Infof("ControllerPublishVolume: Volume %s is already in use", *getVolume.Name)
 		if len(*getVolume.Attachments) > 0 {
-			klog.Infof("ControllerPublishVolume: Volume %s is already attached to node %s", *getVolume.Name, *(*getVolume.Attachments)[0].InstanceId)
+			logger.Info("Volume is already attached", "attachedVMID", *(*getVolume.Attachments)[0].InstanceId)
 			return &csi.ControllerPublishVolumeResponse{
 				PublishContext: map[string]string{
 					volNameKeyFromControllerPublishVolume: *(*getVolume.Attachments)[0].Device,
@@ -213,72 +300,121 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 	if *getVolume.Status == "available" {
 		attachVolume, err := cloud.AttachVolumeToNode(ctx, vmId, volumeIDInt)
 		if err != nil {
-			klog.Errorf("ControllerPublishVolume: Failed to AttachVolumeToNode: %v", err)
+			logger.Error(err, "Failed to attach volume")
 			return nil, status.Errorf(codes.Internal, "ControllerPublishVolume: Failed to AttachVolumeToNode: %v", err)
 		}
-		klog.Infof("ControllerPublishVolume: AttachVolumeToNode succeeded -\nID: %v\nInstance id ID: %v\nStatus: %v\nVolume ID: %v", *attachVolume.Id, *attachVolume.InstanceId, *attachVolume.Status, *attachVolume.VolumeId)
+		logger.Info("Attached volume", "attachmentID", *attachVolume.Id, "status", *attachVolume.Status)
+		if attachVolume.Device != nil && *attachVolume.Device != "" {
+			return &csi.ControllerPublishVolumeResponse{
+				PublishContext: map[string]string{
+					volNameKeyFromControllerPublishVolume: *attachVolume.Device,
+				},
+			}, nil
+		}
+	}
+
+	// The attachment is still in progress, e.g. the volume is attaching or the API returned no device yet
+	device, err := cs.waitForAttachmentDevice(ctx, volumeIDInt, vmId)
+	if err != nil {
+		logger.Error(err, "Failed to wait for the attachment device")
+		if status.Code(err) == codes.DeadlineExceeded {
+			cs.driver.recordWarning(req.GetVolumeContext(), eventReasonAttachTimeout,
+				"Volume %s was not attached to node %s within %v", volumeID, virtualMachineId, attachTimeout)
+		}
+		return nil, err
+	}
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{
+			volNameKeyFromControllerPublishVolume: device,
+		},
+	}, nil
+}
+
+// waitForAttachmentDevice polls the volume until it has an attachment with a device on the virtual
+// machine. It fails with codes.DeadlineExceeded after attachTimeout.
+func (cs *controllerServer) waitForAttachmentDevice(ctx context.Context, volumeID int, vmID int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, attachTimeout)
+	defer cancel()
+	for {
+		vol, err := cs.driver.hyperstackClient.GetVolume(ctx, volumeID)
+		if err != nil && ctx.Err() == nil {
+			return "", status.Errorf(codes.Internal, "failed to get volume %d while waiting for its attachment: %v", volumeID, err)
+		}
+		if vol != nil && vol.Attachments != nil {
+			for _, a := range *vol.Attachments {
+				if a.InstanceId != nil && *a.InstanceId == vmID && a.Device != nil && *a.Device != "" {
+					return *a.Device, nil
+				}
+			}
+		}
+		if err := sleepCtx(ctx, attachPollInterval); err != nil {
+			return "", status.Errorf(status.Code(err), "volume %d has no device on virtual machine %d: %v", volumeID, vmID, err)
+		}
 	}
-	return &csi.ControllerPublishVolumeResponse{}, nil
 }
 
 func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	klog.Infof("ControllerUnpublishVolume: called with args %+v", protosanitizer.StripSecrets(*req))
+	logger := klog.FromContext(ctx)
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerUnpublishVolume: Volume ID must be provided")
+	}
 	virtualMachineId := req.NodeId
 	vmId, err := strconv.Atoi(virtualMachineId)
 	if err != nil {
-		klog.Errorf("ControllerPublishVolume: Failed to convert virtual machine ID to int: %v", err)
+		logger.Error(err, "Failed to convert virtual machine ID to int", "nodeID", virtualMachineId)
 		return nil, status.Errorf(codes.Internal, "Failed to convert virtual machine ID to int: %v", err)
 	}
 	volumeID := req.GetVolumeId()
 	cloud := cs.driver.hyperstackClient
 	volumeIDInt, err := strconv.Atoi(volumeID)
 	if err != nil {
-		klog.Errorf("ControllerUnpublishVolume: Failed to convert volume ID to int: %v", err)
+		logger.Error(err, "Failed to convert volume ID to int", "volumeID", volumeID)
 		return nil, status.Errorf(codes.Internal, "Failed to convert volume ID to int: %v", err)
 	}
+	logger = logger.WithValues("volumeID", volumeIDInt, "vmID", vmId)
 	getVolume, err := cloud.GetVolume(ctx, volumeIDInt)
-	klog.Infof("ControllerUnpublishVolume: GetVolume returned volume: %+v", getVolume)
+	// A volume that does not exist is not attached to any node either
+	if errors.Is(err, util.ErrNotFound) {
+		logger.Info("Volume not found, assuming it is detached")
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
 	if err != nil {
-		klog.Errorf("ControllerUnpublishVolume: Failed to GetVolume from hyperstack: %v", err)
+		logger.Error(err, "Failed to get volume")
 		return nil, status.Errorf(codes.NotFound, "ControllerUnpublishVolume: Failed to GetVolume from hyperstack: %v", err)
 	}
 	if getVolume == nil {
-		klog.Errorf("ControllerUnpublishVolume: GetVolume returned nil volume")
+		logger.Error(nil, "Got no volume")
 		return nil, status.Errorf(codes.NotFound, "ControllerUnpublishVolume: GetVolume returned nil volume")
 	}
-	klog.Infof("ControllerUnpublishVolume: GetVolume succeeded -\nStatus: %s\nName: %s\nID: %d\nSize:%d", *getVolume.Status, *getVolume.Name, *getVolume.Id, *getVolume.Size)
+	logger.V(4).Info("Got volume", "name", *getVolume.Name, "status", *getVolume.Status, "sizeGiB", *getVolume.Size)
 	if *getVolume.Status == "in-use" {
 		detachVolume, err := cloud.DetachVolumeFromNode(ctx, vmId, volumeIDInt)
 		if err != nil {
-			klog.Errorf("ControllerUnpublishVolume: Failed to DetachVolumeFromNode: %v", err)
+			logger.Error(err, "Failed to detach volume")
 			return nil, status.Errorf(codes.Internal, "ControllerUnpublishVolume: Failed to DetachVolumeFromNode: %v", err)
 		}
-		klog.Infof("ControllerUnpublishVolume: DetachVolumeFromNode succeeded -\nMessage: %v\nStatus: %v\nVolume Attachments: %v", *detachVolume.Message, *detachVolume.Status, *detachVolume.VolumeAttachments)
+		logger.Info("Detached volume", "status", *detachVolume.Status, "message", *detachVolume.Message)
 	}
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
 func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	klog.Infof("ListVolumes: called with %+#v request", req)
-	klog.Warning("ListVolumes: Not implemented yet!")
-	return &csi.ListVolumesResponse{}, nil
+	return nil, status.Error(codes.Unimplemented, "ListVolumes is not yet implemented")
 }
 
 func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	klog.Infof("CreateSnapshot: called with args %+v", protosanitizer.StripSecrets(*req))
-	klog.Warning("CreateSnapshot: Not implemented yet!")
+	klog.FromContext(ctx).Info("CreateSnapshot is not implemented yet")
 	return &csi.CreateSnapshotResponse{}, nil
 }
 
 func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	klog.Infof("DeleteSnapshot: called with args %+v", protosanitizer.StripSecrets(*req))
-	klog.Warning("DeleteSnapshot: Not implemented yet!")
+	klog.FromContext(ctx).Info("DeleteSnapshot is not implemented yet")
 	return &csi.DeleteSnapshotResponse{}, nil
 }
 
 func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	// TODO(joseb)
-	klog.Warning("ListSnapshots: Not implemented yet!")
+	klog.FromContext(ctx).Info("ListSnapshots is not implemented yet")
 	return &csi.ListSnapshotsResponse{}, nil
 }
 
@@ -305,14 +441,14 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 
 	volumeIDInt, err := strconv.Atoi(volumeID)
 	if err != nil {
-		klog.Errorf("ValidateVolumeCapabilities: Failed to convert volume ID to int: %v", err)
-		return nil, status.Errorf(codes.Internal, "Failed to convert volume ID to int: %v", err)
+		klog.FromContext(ctx).Error(err, "Failed to convert volume ID to int", "volumeID", volumeID)
+		return nil, status.Errorf(codes.NotFound, "Failed to convert volume ID to int: %v", err)
 	}
 	_, err = cs.driver.hyperstackClient.GetVolume(ctx, volumeIDInt)
 	if err != nil {
-		// if cpoerrors.IsNotFound(err) {
-		// 	return nil, status.Errorf(codes.NotFound, "ValidateVolumeCapabilities Volume %s not found", volumeID)
-		// }
+		if errors.Is(err, util.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "ValidateVolumeCapabilities Volume %s not found", volumeID)
+		}
 		return nil, status.Errorf(codes.Internal, "ValidateVolumeCapabilities %v", err)
 	}
 
@@ -341,23 +477,71 @@ func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacit
 }
 
 func (cs *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	klog.Infof("ControllerGetVolume: called with args %+v", protosanitizer.StripSecrets(*req))
-	return &csi.ControllerGetVolumeResponse{}, nil
+	return nil, status.Error(codes.Unimplemented, "ControllerGetVolume is not yet implemented")
 }
 
+// ControllerExpandVolume checks the new size against the policy. The Hyperstack API cannot resize
+// volumes, so an expansion that passes the policy fails with Unimplemented.
 func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	klog.Infof("ControllerExpandVolume: called with args %+v", protosanitizer.StripSecrets(*req))
-	klog.Warning("ControllerExpandVolume: Not implemented yet!")
-	return &csi.ControllerExpandVolumeResponse{}, nil
+	logger := klog.FromContext(ctx)
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerExpandVolume: Volume ID must be provided")
+	}
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "ControllerExpandVolume: Capacity range must be provided")
+	}
+	volumeID, err := strconv.Atoi(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "ControllerExpandVolume: invalid volume ID %q", req.GetVolumeId())
+	}
+	vol, err := cs.driver.hyperstackClient.GetVolume(ctx, volumeID)
+	if errors.Is(err, util.ErrNotFound) || (err == nil && vol == nil) {
+		return nil, status.Errorf(codes.NotFound, "ControllerExpandVolume: volume %d not found", volumeID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerExpandVolume: failed to get volume %d: %v", volumeID, err)
+	}
+
+	currentGB := 0
+	if vol.Size != nil {
+		currentGB = *vol.Size
+	}
+	newGB := int(util.RoundUpSize(req.GetCapacityRange().GetRequiredBytes(), 1024*1024*1024))
+	if newGB <= currentGB {
+		return &csi.ControllerExpandVolumeResponse{CapacityBytes: int64(currentGB) * 1024 * 1024 * 1024, NodeExpansionRequired: true}, nil
+	}
+	if err := cs.checkExpandPolicy(ctx, vol, newGB); err != nil {
+		var violation *policy.Violation
+		if errors.As(err, &violation) {
+			logger.Info("Rejecting volume expansion", "volumeID", volumeID, "sizeGiB", newGB, "err", err)
+			return nil, err
+		}
+		logger.Error(err, "Failed to check the expansion against the policy", "volumeID", volumeID)
+		return nil, status.Errorf(codes.Internal, "ControllerExpandVolume failed with error %v", err)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "ControllerExpandVolume: the Hyperstack API cannot resize volume %d to %d GiB", volumeID, newGB)
 }
 
 func (cs *controllerServer) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
-	klog.Infof("ControllerModifyVolume: called with args %+v", protosanitizer.StripSecrets(*req))
-	klog.Warning("ControllerModifyVolume: Not implemented yet!")
+	klog.FromContext(ctx).Info("ControllerModifyVolume is not implemented yet")
 	return &csi.ControllerModifyVolumeResponse{}, nil
 }
 
-func getCreateVolumeResponse(vol *volume.VolumeFields, accessibleTopologyReq *csi.TopologyRequirement) *csi.CreateVolumeResponse {
+// createVolumeErrorReason returns the Event reason and gRPC code of a Hyperstack CreateVolume error
+// caused by the request rather than the API, or an empty reason. The API reports these only in the
+// error message.
+func createVolumeErrorReason(err error) (string, codes.Code) {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "quota"):
+		return eventReasonQuotaExceeded, codes.ResourceExhausted
+	case strings.Contains(msg, "volume type") || strings.Contains(msg, "volume_type"):
+		return eventReasonVolumeTypeInvalid, codes.InvalidArgument
+	}
+	return "", codes.Internal
+}
+
+func getCreateVolumeResponse(vol *volume.VolumeFields, volContext map[string]string, accessibleTopologyReq *csi.TopologyRequirement) *csi.CreateVolumeResponse {
 
 	var volsrc *csi.VolumeContentSource
 	var accessibleTopology []*csi.Topology
@@ -370,6 +554,7 @@ func getCreateVolumeResponse(vol *volume.VolumeFields, accessibleTopologyReq *cs
 		Volume: &csi.Volume{
 			VolumeId:           strconv.Itoa(*vol.Id),
 			CapacityBytes:      int64(*vol.Size * 1024 * 1024 * 1024),
+			VolumeContext:      volContext,
 			AccessibleTopology: accessibleTopology,
 			ContentSource:      volsrc,
 		},
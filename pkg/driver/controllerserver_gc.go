@@ -0,0 +1,212 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+	"k8s.io/csi-hyperstack/pkg/metrics"
+	kubernetes "k8s.io/csi-hyperstack/pkg/utils/kubernetes"
+)
+
+const (
+	eventReasonOrphanedVolume        = "OrphanedVolume"
+	eventReasonOrphanedVolumeDeleted = "OrphanedVolumeDeleted"
+)
+
+// OrphanCollectorOpts controls what the orphaned volume garbage collector is allowed to do
+type OrphanCollectorOpts struct {
+	// Delete enables deletion of orphans, otherwise they are only reported
+	Delete bool
+	// GracePeriod is the minimum age of an orphan before it may be deleted
+	GracePeriod time.Duration
+	// ClusterID is the Hyperstack cluster whose volumes are collected, by default the one in the
+	// hyperstack.cloud/cluster-id label of the node the driver runs on
+	ClusterID string
+}
+
+// OrphanedVolume is a driver-owned volume of this cluster that no PersistentVolume refers to
+type OrphanedVolume struct {
+	ID     int
+	Name   string
+	Status string
+	Age    time.Duration
+	// Untagged is set for volumes without provenance tags that are only matched on the environment.
+	// The environment may be shared with other clusters, so these are reported but never deleted.
+	Untagged bool
+	Deleted  bool
+	Err      error
+}
+
+// runOrphanCollector runs CollectOrphanedVolumes every interval until ctx is done
+func (d *Driver) runOrphanCollector(ctx context.Context, interval time.Duration, opts OrphanCollectorOpts) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.CollectOrphanedVolumes(ctx, opts); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to collect orphaned volumes")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CollectOrphanedVolumes lists the volumes this driver created for the cluster, compares them with
+// the PersistentVolumes in Kubernetes and reports the ones without a PV. When opts.Delete is set,
+// orphans tagged with this cluster that are `available` and older than opts.GracePeriod are deleted.
+func (d *Driver) CollectOrphanedVolumes(ctx context.Context, opts OrphanCollectorOpts) ([]OrphanedVolume, error) {
+	logger := klog.FromContext(ctx)
+	clientset, err := d.getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	clusterId, clusterEnvironment, err := d.getClusterEnvironment(ctx, opts.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	pvs, err := d.listPersistentVolumes(ctx, clientset)
+	if err != nil {
+		return nil, err
+	}
+	volumeHandles := map[string]bool{}
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == d.name {
+			volumeHandles[pv.Spec.CSI.VolumeHandle] = true
+		}
+	}
+
+	volumes, err := d.hyperstackClient.ListVolumes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	recorder := d.getEventRecorder()
+	podRef := kubernetes.GetCurrentPodReference()
+	if recorder == nil {
+		podRef = nil
+	}
+
+	orphans := []OrphanedVolume{}
+	for i := range volumes {
+		vol := &volumes[i]
+		if vol.Id == nil || !hyperstack.IsCreatedByDriver(vol) {
+			continue
+		}
+		untagged := hyperstack.VolumeTags(vol) == nil
+		if untagged && !isInEnvironment(vol, clusterEnvironment) {
+			continue
+		}
+		if !untagged && !isOwnedByCluster(vol, clusterId) {
+			continue
+		}
+		if volumeHandles[strconv.Itoa(*vol.Id)] {
+			continue
+		}
+
+		orphan := OrphanedVolume{
+			ID:       *vol.Id,
+			Age:      -1,
+			Untagged: untagged,
+		}
+		if vol.Name != nil {
+			orphan.Name = *vol.Name
+		}
+		if vol.Status != nil {
+			orphan.Status = *vol.Status
+		}
+		if vol.CreatedAt != nil {
+			orphan.Age = time.Since(*vol.CreatedAt)
+		}
+
+		logger.Info("Volume has no PersistentVolume", "volumeID", orphan.ID, "name", orphan.Name, "status", orphan.Status, "untagged", orphan.Untagged)
+		if podRef != nil {
+			recorder.Eventf(podRef, corev1.EventTypeWarning, eventReasonOrphanedVolume,
+				"Volume %d (%s) in status %q has no PersistentVolume", orphan.ID, orphan.Name, orphan.Status)
+		}
+
+		if opts.Delete && !orphan.Untagged && orphan.Status == "available" && orphan.Age >= opts.GracePeriod {
+			orphan.Err = d.hyperstackClient.DeleteVolume(ctx, orphan.ID)
+			metrics.ObserveOrphanedVolumeDeletion(orphan.Err)
+			if orphan.Err != nil {
+				logger.Error(orphan.Err, "Failed to delete orphaned volume", "volumeID", orphan.ID)
+			} else {
+				orphan.Deleted = true
+				logger.Info("Deleted orphaned volume", "volumeID", orphan.ID, "name", orphan.Name)
+				if podRef != nil {
+					recorder.Eventf(podRef, corev1.EventTypeNormal, eventReasonOrphanedVolumeDeleted,
+						"Deleted orphaned volume %d (%s)", orphan.ID, orphan.Name)
+				}
+			}
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	metrics.SetOrphanedVolumes(len(orphans))
+	logger.Info("Collected orphaned volumes", "count", len(orphans), "environment", clusterEnvironment)
+	return orphans, nil
+}
+
+// isOwnedByCluster reports whether the tags of a driver-created volume name this cluster
+func isOwnedByCluster(vol *volume.VolumeFields, clusterId string) bool {
+	tags := hyperstack.VolumeTags(vol)
+	return tags != nil && tags[hyperstackCSIClusterIDKey] == clusterId
+}
+
+// isInEnvironment reports whether the volume lives in the Hyperstack environment. Several clusters
+// can share an environment, so this alone does not make a volume belong to this cluster.
+func isInEnvironment(vol *volume.VolumeFields, clusterEnvironment string) bool {
+	return vol.Environment != nil && vol.Environment.Name != nil && *vol.Environment.Name == clusterEnvironment
+}
+
+// listPersistentVolumes returns the PersistentVolumes from the cache once it has synced, else from the API server
+func (d *Driver) listPersistentVolumes(ctx context.Context, clientset k8sclient.Interface) ([]*corev1.PersistentVolume, error) {
+	if d.kubeCache != nil && d.kubeCache.HasSynced() {
+		return d.kubeCache.ListPersistentVolumes()
+	}
+	list, err := clientset.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistent volumes: %w", err)
+	}
+	pvs := make([]*corev1.PersistentVolume, len(list.Items))
+	for i := range list.Items {
+		pvs[i] = &list.Items[i]
+	}
+	return pvs, nil
+}
+
+// getClusterEnvironment resolves the Hyperstack environment of a cluster. An empty clusterId is
+// resolved from the node's label first.
+func (d *Driver) getClusterEnvironment(ctx context.Context, clusterId string) (string, string, error) {
+	if clusterId == "" {
+		var err error
+		clusterId, err = d.getNodeLabel(ctx, hyperstackClusterIdLabelKey)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get node label: %w", err)
+		}
+	}
+	clusterIdInt, err := strconv.Atoi(clusterId)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to convert cluster ID to int: %w", err)
+	}
+	clusterDetail, err := d.hyperstackClient.GetClusterDetail(ctx, clusterIdInt)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get cluster detail: %w", err)
+	}
+	if clusterDetail == nil || clusterDetail.EnvironmentName == nil {
+		return "", "", fmt.Errorf("cluster %d has no environment", clusterIdInt)
+	}
+	return clusterId, *clusterDetail.EnvironmentName, nil
+}
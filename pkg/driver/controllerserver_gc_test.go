@@ -0,0 +1,142 @@
+package driver
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+)
+
+// taggedDescription is the volume description the driver writes for a volume of the cluster
+func taggedDescription(clusterID int) string {
+	description, _ := hyperstack.VolumeDescription(map[string]string{hyperstackCSIClusterIDKey: strconv.Itoa(clusterID)})
+	return description
+}
+
+func TestCollectOrphanedVolumes(t *testing.T) {
+	const legacyDescription = "Created by Hyperstack CSI driver"
+	old := time.Now().Add(-48 * time.Hour)
+
+	testCases := []struct {
+		name     string
+		volume   fake.Volume
+		orphan   bool
+		untagged bool
+		deleted  bool
+	}{
+		{
+			name:    "tagged_orphan",
+			volume:  fake.Volume{Environment: "CANADA-1", Description: taggedDescription(testClusterID), CreatedAt: old},
+			orphan:  true,
+			deleted: true,
+		},
+		{
+			name:   "tagged_orphan_in_grace_period",
+			volume: fake.Volume{Environment: "CANADA-1", Description: taggedDescription(testClusterID)},
+			orphan: true,
+		},
+		{
+			name:   "tagged_orphan_in_use",
+			volume: fake.Volume{Environment: "CANADA-1", Description: taggedDescription(testClusterID), Status: fake.StatusInUse, CreatedAt: old},
+			orphan: true,
+		},
+		{
+			name:   "other_cluster_in_same_environment",
+			volume: fake.Volume{Environment: "CANADA-1", Description: taggedDescription(testClusterID + 1), CreatedAt: old},
+		},
+		{
+			name:     "untagged_in_same_environment",
+			volume:   fake.Volume{Environment: "CANADA-1", Description: legacyDescription, CreatedAt: old},
+			orphan:   true,
+			untagged: true,
+		},
+		{
+			name:   "untagged_in_other_environment",
+			volume: fake.Volume{Environment: "NORWAY-1", Description: legacyDescription, CreatedAt: old},
+		},
+		{
+			name:   "created_by_hand",
+			volume: fake.Volume{Environment: "CANADA-1", Description: "scratch space", CreatedAt: old},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, hs := newTestController(t, nil)
+			tc.volume.Name = "pvc-1"
+			tc.volume.Size = 10
+			id := hs.AddVolume(tc.volume)
+
+			orphans, err := cs.driver.CollectOrphanedVolumes(context.Background(), OrphanCollectorOpts{Delete: true, GracePeriod: 24 * time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := 0
+			if tc.orphan {
+				expected = 1
+			}
+			if len(orphans) != expected {
+				t.Fatalf("CollectOrphanedVolumes() = %+v, expected %d orphans", orphans, expected)
+			}
+			if tc.orphan && (orphans[0].ID != id || orphans[0].Untagged != tc.untagged || orphans[0].Deleted != tc.deleted) {
+				t.Errorf("orphan = %+v, expected ID %d, untagged = %v, deleted = %v", orphans[0], id, tc.untagged, tc.deleted)
+			}
+			if _, exists := hs.Volume(id); exists == tc.deleted {
+				t.Errorf("volume exists = %v, expected deleted = %v", exists, tc.deleted)
+			}
+		})
+	}
+}
+
+func TestCollectOrphanedVolumesSkipsPersistentVolumes(t *testing.T) {
+	cs, hs := newTestController(t, nil)
+	id := hs.AddVolume(fake.Volume{Name: "pvc-1", Size: 10, Environment: "CANADA-1", Description: taggedDescription(testClusterID), CreatedAt: time.Now().Add(-48 * time.Hour)})
+	kubeClient, err := cs.driver.getKubeClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+			CSI: &corev1.CSIPersistentVolumeSource{Driver: DriverName, VolumeHandle: strconv.Itoa(id)},
+		}},
+	}
+	if _, err := kubeClient.CoreV1().PersistentVolumes().Create(context.Background(), pv, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := cs.driver.CollectOrphanedVolumes(context.Background(), OrphanCollectorOpts{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("CollectOrphanedVolumes() = %+v, expected no orphans", orphans)
+	}
+	if _, exists := hs.Volume(id); !exists {
+		t.Error("volume with a PersistentVolume was deleted")
+	}
+}
+
+func TestCollectOrphanedVolumesClusterID(t *testing.T) {
+	cs, hs := newTestController(t, nil)
+	// The gc command may run outside the cluster, without NODE_NAME
+	cs.driver.nodeName = ""
+	id := hs.AddVolume(fake.Volume{Name: "pvc-1", Size: 10, Environment: "CANADA-1", Description: taggedDescription(testClusterID)})
+
+	if _, err := cs.driver.CollectOrphanedVolumes(context.Background(), OrphanCollectorOpts{}); err == nil {
+		t.Error("CollectOrphanedVolumes() without a cluster ID succeeded, expected the node lookup to fail")
+	}
+	orphans, err := cs.driver.CollectOrphanedVolumes(context.Background(), OrphanCollectorOpts{ClusterID: strconv.Itoa(testClusterID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != id {
+		t.Errorf("CollectOrphanedVolumes() = %+v, expected volume %d", orphans, id)
+	}
+}
@@ -0,0 +1,76 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+	"k8s.io/csi-hyperstack/pkg/policy"
+)
+
+const pvcNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+
+// namespaceUsage counts the volumes this driver created for the PVCs of a namespace in the cluster.
+// The volume with ID excludeId is skipped so that an expansion is not counted twice.
+func (cs *controllerServer) namespaceUsage(ctx context.Context, clusterId string, namespace string, excludeId int) (policy.Usage, error) {
+	usage := policy.Usage{}
+	volumes, err := cs.driver.hyperstackClient.ListVolumes(ctx)
+	if err != nil {
+		return usage, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for i := range volumes {
+		vol := &volumes[i]
+		if vol.Id == nil || *vol.Id == excludeId || !hyperstack.IsCreatedByDriver(vol) {
+			continue
+		}
+		tags := hyperstack.VolumeTags(vol)
+		if tags[pvcNamespaceKey] != namespace || tags[hyperstackCSIClusterIDKey] != clusterId {
+			continue
+		}
+		usage.Volumes++
+		if vol.Size != nil {
+			usage.TotalGiB += *vol.Size
+		}
+	}
+	return usage, nil
+}
+
+// checkNamespaceQuota checks the quota of the namespace before adding addVolumes volumes of addGiB in total.
+// Callers hold lockNamespaceQuota until the volumes are created, so that concurrent calls see each other.
+func (cs *controllerServer) checkNamespaceQuota(ctx context.Context, clusterId string, namespace string, excludeId int, addVolumes int, addGiB int) error {
+	p := cs.driver.opts.Policy
+	if p.NamespaceQuotaFor(namespace) == nil {
+		return nil
+	}
+	usage, err := cs.namespaceUsage(ctx, clusterId, namespace, excludeId)
+	if err != nil {
+		return err
+	}
+	return p.CheckNamespaceQuota(namespace, usage, addVolumes, addGiB)
+}
+
+// lockNamespaceQuota serializes the quota check and volume creation of a namespace with a quota and
+// returns the function releasing the lock
+func (cs *controllerServer) lockNamespaceQuota(namespace string) func() {
+	if cs.driver.opts.Policy.NamespaceQuotaFor(namespace) == nil {
+		return func() {}
+	}
+	cs.namespaceLocks.LockKey(namespace)
+	return func() { _ = cs.namespaceLocks.UnlockKey(namespace) }
+}
+
+// checkExpandPolicy checks the new size of an expanded volume against the limits of its type and
+// the quota of the namespace its PVC lives in
+func (cs *controllerServer) checkExpandPolicy(ctx context.Context, vol *volume.VolumeFields, newGiB int) error {
+	volType := ""
+	if vol.VolumeType != nil {
+		volType = *vol.VolumeType
+	}
+	if err := cs.driver.opts.Policy.CheckVolume(volType, newGiB); err != nil {
+		return err
+	}
+	tags := hyperstack.VolumeTags(vol)
+	return cs.checkNamespaceQuota(ctx, tags[hyperstackCSIClusterIDKey], tags[pvcNamespaceKey], *vol.Id, 1, newGiB)
+}
@@ -0,0 +1,441 @@
+package driver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack"
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	"k8s.io/csi-hyperstack/pkg/policy"
+)
+
+var _ hyperstack.IHyperstack = fake.NewHyperstack()
+
+const (
+	testNodeName  = "node-1"
+	testClusterID = 1168
+	testVMID      = 268040
+)
+
+// newTestController returns a controller server backed by an in-memory Hyperstack
+// and a fake Kubernetes client with a node labelled with the test cluster
+func newTestController(t *testing.T, p *policy.Policy) (*controllerServer, *fake.Hyperstack) {
+	t.Helper()
+	hs := fake.NewHyperstack()
+	hs.DescribeVolume = hyperstack.VolumeDescription
+	hs.AddCluster(testClusterID, "test-cluster", "CANADA-1")
+	kubeClient := k8sfake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testNodeName,
+			Labels: map[string]string{hyperstackClusterIdLabelKey: strconv.Itoa(testClusterID)},
+		},
+	})
+	d, err := NewDriver(&DriverOpts{
+		HyperstackClient: hs,
+		KubeClient:       kubeClient,
+		NodeName:         testNodeName,
+		Policy:           p,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetupControllerService()
+	return d.serviceController.(*controllerServer), hs
+}
+
+// mountVolumeCapability is the capability of a single node writer filesystem volume
+func mountVolumeCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func createVolumeRequest(name string, sizeGiB int64, params map[string]string) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: sizeGiB * 1024 * 1024 * 1024},
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability()},
+		Parameters:         params,
+	}
+}
+
+func TestControllerCreateVolume(t *testing.T) {
+	testCases := []struct {
+		name     string
+		req      *csi.CreateVolumeRequest
+		policy   *policy.Policy
+		existing *fake.Volume
+		injected string
+		code     codes.Code
+	}{
+		{
+			name: "valid_request",
+			req:  createVolumeRequest("pvc-1", 10, map[string]string{"type": "Cloud-SSD", "tags": "team=storage"}),
+			code: codes.OK,
+		},
+		{
+			name:     "existing_volume_same_size",
+			req:      createVolumeRequest("pvc-1", 10, nil),
+			existing: &fake.Volume{Name: "pvc-1", Size: 10, Environment: "CANADA-1"},
+			code:     codes.OK,
+		},
+		{
+			name:     "existing_volume_other_size",
+			req:      createVolumeRequest("pvc-1", 20, nil),
+			existing: &fake.Volume{Name: "pvc-1", Size: 10, Environment: "CANADA-1"},
+			code:     codes.AlreadyExists,
+		},
+		{
+			name: "missing_name",
+			req:  createVolumeRequest("", 10, nil),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "invalid_tags",
+			req:  createVolumeRequest("pvc-1", 10, map[string]string{"tags": "no-value"}),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "long_user_tags_dropped",
+			req:  createVolumeRequest("pvc-1", 10, map[string]string{"tags": "notes=" + strings.Repeat("x", 300), pvcNamespaceKey: "shop"}),
+			code: codes.OK,
+		},
+		{
+			name: "provenance_over_description_limit",
+			req:  createVolumeRequest("pvc-1", 10, map[string]string{pvcNameKey: strings.Repeat("x", 253), pvcNamespaceKey: "shop"}),
+			code: codes.InvalidArgument,
+		},
+		{
+			name:   "volume_type_not_allowed",
+			req:    createVolumeRequest("pvc-1", 10, map[string]string{"type": "Cloud-HDD"}),
+			policy: &policy.Policy{AllowedVolumeTypes: []string{"Cloud-SSD"}},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "size_above_limit",
+			req:    createVolumeRequest("pvc-1", 100, map[string]string{"type": "Cloud-SSD"}),
+			policy: &policy.Policy{VolumeTypes: map[string]policy.SizeLimits{"Cloud-SSD": {MaxSizeGiB: 50}}},
+			code:   codes.OutOfRange,
+		},
+		{
+			name:     "create_fails",
+			req:      createVolumeRequest("pvc-1", 10, nil),
+			injected: "CreateVolume",
+			code:     codes.Internal,
+		},
+		{
+			name:     "cluster_lookup_fails",
+			req:      createVolumeRequest("pvc-1", 10, nil),
+			injected: "GetClusterDetail",
+			code:     codes.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, hs := newTestController(t, tc.policy)
+			if tc.existing != nil {
+				hs.AddVolume(*tc.existing)
+			}
+			if tc.injected != "" {
+				hs.InjectError(tc.injected, errors.New("injected error"))
+			}
+			resp, err := cs.CreateVolume(context.Background(), tc.req)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("CreateVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			if err != nil {
+				return
+			}
+			id, _ := strconv.Atoi(resp.Volume.VolumeId)
+			vol, ok := hs.Volume(id)
+			if !ok || vol.Status != fake.StatusAvailable {
+				t.Errorf("volume %s is missing or not available: %+v", resp.Volume.VolumeId, vol)
+			}
+		})
+	}
+}
+
+// slowListVolumes delays ListVolumes results, so that concurrent calls list before any of them creates
+type slowListVolumes struct {
+	hyperstack.IHyperstack
+}
+
+func (s *slowListVolumes) ListVolumes(ctx context.Context) ([]volume.VolumeFields, error) {
+	volumes, err := s.IHyperstack.ListVolumes(ctx)
+	time.Sleep(10 * time.Millisecond)
+	return volumes, err
+}
+
+func TestControllerCreateVolumeNamespaceQuotaConcurrent(t *testing.T) {
+	const creates = 8
+	cs, hs := newTestController(t, &policy.Policy{NamespaceQuota: &policy.NamespaceQuota{MaxVolumes: 2}})
+	cs.driver.hyperstackClient = &slowListVolumes{IHyperstack: hs}
+
+	results := make(chan codes.Code, creates)
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := createVolumeRequest("pvc-"+strconv.Itoa(i), 10, map[string]string{pvcNamespaceKey: "shop"})
+			_, err := cs.CreateVolume(context.Background(), req)
+			results <- status.Code(err)
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	created := 0
+	for code := range results {
+		if code == codes.OK {
+			created++
+		}
+	}
+	if created != 2 {
+		t.Errorf("%d of %d concurrent CreateVolume calls succeeded, expected the quota of 2", created, creates)
+	}
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	shop := map[string]string{"type": "Cloud-SSD", pvcNamespaceKey: "shop"}
+
+	testCases := []struct {
+		name     string
+		volumeID string
+		sizeGiB  int64
+		policy   *policy.Policy
+		code     codes.Code
+	}{
+		{
+			name:    "no_policy",
+			sizeGiB: 20,
+			code:    codes.Unimplemented,
+		},
+		{
+			name:    "within_policy",
+			sizeGiB: 20,
+			policy:  &policy.Policy{VolumeTypes: map[string]policy.SizeLimits{"Cloud-SSD": {MaxSizeGiB: 50}}, NamespaceQuota: &policy.NamespaceQuota{MaxVolumes: 2, MaxTotalGiB: 30}},
+			code:    codes.Unimplemented,
+		},
+		{
+			name:    "already_large_enough",
+			sizeGiB: 10,
+			policy:  &policy.Policy{NamespaceQuota: &policy.NamespaceQuota{MaxTotalGiB: 20}},
+			code:    codes.OK,
+		},
+		{
+			name:    "size_above_limit",
+			sizeGiB: 100,
+			policy:  &policy.Policy{VolumeTypes: map[string]policy.SizeLimits{"Cloud-SSD": {MaxSizeGiB: 50}}},
+			code:    codes.OutOfRange,
+		},
+		{
+			name:    "namespace_quota_exceeded",
+			sizeGiB: 25,
+			policy:  &policy.Policy{NamespaceQuota: &policy.NamespaceQuota{MaxTotalGiB: 30}},
+			code:    codes.ResourceExhausted,
+		},
+		{
+			name:     "unknown_volume",
+			volumeID: "999999",
+			sizeGiB:  20,
+			code:     codes.NotFound,
+		},
+		{
+			name:    "missing_capacity",
+			sizeGiB: -1,
+			code:    codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, _ := newTestController(t, tc.policy)
+			var ids []string
+			for _, name := range []string{"pvc-1", "pvc-2"} {
+				resp, err := cs.CreateVolume(context.Background(), createVolumeRequest(name, 10, shop))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, resp.Volume.VolumeId)
+			}
+			req := &csi.ControllerExpandVolumeRequest{VolumeId: ids[0], CapacityRange: &csi.CapacityRange{RequiredBytes: tc.sizeGiB * gib}}
+			if tc.volumeID != "" {
+				req.VolumeId = tc.volumeID
+			}
+			if tc.sizeGiB < 0 {
+				req.CapacityRange = nil
+			}
+
+			resp, err := cs.ControllerExpandVolume(context.Background(), req)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("ControllerExpandVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			if err == nil && resp.CapacityBytes != 10*gib {
+				t.Errorf("ControllerExpandVolume() capacity = %d, expected %d", resp.CapacityBytes, 10*gib)
+			}
+		})
+	}
+}
+
+func TestControllerDeleteVolume(t *testing.T) {
+	testCases := []struct {
+		name     string
+		volume   *fake.Volume
+		volumeID string
+		injected string
+		code     codes.Code
+	}{
+		{"available_volume", &fake.Volume{Name: "pvc-1", Size: 10}, "", "", codes.OK},
+		{"in_use_volume", &fake.Volume{Name: "pvc-1", Size: 10, Status: fake.StatusInUse, Attachment: &fake.Attachment{InstanceID: testVMID}}, "", "", codes.FailedPrecondition},
+		{"unknown_volume", nil, "99999", "", codes.OK},
+		{"invalid_volume_id", nil, "pvc-1", "", codes.OK},
+		{"delete_fails", &fake.Volume{Name: "pvc-1", Size: 10}, "", "DeleteVolume", codes.Internal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, hs := newTestController(t, nil)
+			volumeID := tc.volumeID
+			if tc.volume != nil {
+				volumeID = strconv.Itoa(hs.AddVolume(*tc.volume))
+			}
+			if tc.injected != "" {
+				hs.InjectError(tc.injected, errors.New("injected error"))
+			}
+			_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID})
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("DeleteVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			if err == nil {
+				id, _ := strconv.Atoi(volumeID)
+				if _, ok := hs.Volume(id); ok {
+					t.Errorf("volume %d was not deleted", id)
+				}
+			}
+		})
+	}
+}
+
+func TestControllerDeleteVolumeDryRunTwice(t *testing.T) {
+	cs, hs := newTestController(t, nil)
+	id := hs.AddVolume(fake.Volume{Name: "pvc-1", Size: 10, Environment: "CANADA-1"})
+	cs.driver.hyperstackClient = hyperstack.NewDryRun(hs)
+
+	// The external-provisioner retries DeleteVolume until it succeeds, in dry-run mode as well
+	for i := 0; i < 2; i++ {
+		if _, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: strconv.Itoa(id)}); err != nil {
+			t.Fatalf("DeleteVolume() call %d error = %v", i+1, err)
+		}
+	}
+	if _, exists := hs.Volume(id); !exists {
+		t.Error("volume deleted in dry-run mode")
+	}
+}
+
+func TestControllerPublishVolume(t *testing.T) {
+	testCases := []struct {
+		name     string
+		volume   *fake.Volume
+		volumeID string
+		nodeID   string
+		injected string
+		code     codes.Code
+		device   string
+	}{
+		{"available_volume", &fake.Volume{Name: "pvc-1", Size: 10}, "", strconv.Itoa(testVMID), "", codes.OK, "/dev/vdb"},
+		{"already_attached", &fake.Volume{Name: "pvc-1", Size: 10, Status: fake.StatusInUse, Attachment: &fake.Attachment{InstanceID: testVMID, Device: "/dev/vdc"}}, "", strconv.Itoa(testVMID), "", codes.OK, "/dev/vdc"},
+		{"unknown_volume", nil, "99999", strconv.Itoa(testVMID), "", codes.NotFound, ""},
+		{"invalid_node_id", &fake.Volume{Name: "pvc-1", Size: 10}, "", "node-1", "", codes.NotFound, ""},
+		{"attach_fails", &fake.Volume{Name: "pvc-1", Size: 10}, "", strconv.Itoa(testVMID), "AttachVolumeToNode", codes.Internal, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, hs := newTestController(t, nil)
+			volumeID := tc.volumeID
+			if tc.volume != nil {
+				volumeID = strconv.Itoa(hs.AddVolume(*tc.volume))
+			}
+			if tc.injected != "" {
+				hs.InjectError(tc.injected, errors.New("injected error"))
+			}
+			resp, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId:         volumeID,
+				NodeId:           tc.nodeID,
+				VolumeCapability: mountVolumeCapability(),
+			})
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("ControllerPublishVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			if err != nil {
+				return
+			}
+			if device := resp.PublishContext[volNameKeyFromControllerPublishVolume]; device != tc.device {
+				t.Errorf("publish context device = %q, expected %q", device, tc.device)
+			}
+			id, _ := strconv.Atoi(volumeID)
+			if vol, _ := hs.Volume(id); vol.Status != fake.StatusInUse || vol.Attachment == nil || vol.Attachment.InstanceID != testVMID {
+				t.Errorf("volume is not attached to %d: %+v", testVMID, vol)
+			}
+		})
+	}
+}
+
+func TestControllerUnpublishVolume(t *testing.T) {
+	testCases := []struct {
+		name     string
+		volume   *fake.Volume
+		volumeID string
+		injected string
+		code     codes.Code
+	}{
+		{"attached_volume", &fake.Volume{Name: "pvc-1", Size: 10, Status: fake.StatusInUse, Attachment: &fake.Attachment{InstanceID: testVMID}}, "", "", codes.OK},
+		{"detached_volume", &fake.Volume{Name: "pvc-1", Size: 10}, "", "", codes.OK},
+		{"unknown_volume", nil, "99999", "", codes.OK},
+		{"detach_fails", &fake.Volume{Name: "pvc-1", Size: 10, Status: fake.StatusInUse, Attachment: &fake.Attachment{InstanceID: testVMID}}, "", "DetachVolumeFromNode", codes.Internal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, hs := newTestController(t, nil)
+			volumeID := tc.volumeID
+			if tc.volume != nil {
+				volumeID = strconv.Itoa(hs.AddVolume(*tc.volume))
+			}
+			if tc.injected != "" {
+				hs.InjectError(tc.injected, errors.New("injected error"))
+			}
+			_, err := cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: volumeID,
+				NodeId:   strconv.Itoa(testVMID),
+			})
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("ControllerUnpublishVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			if err != nil {
+				return
+			}
+			id, _ := strconv.Atoi(volumeID)
+			if vol, exists := hs.Volume(id); exists && (vol.Status != fake.StatusAvailable || vol.Attachment != nil) {
+				t.Errorf("volume is still attached: %+v", vol)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+	"k8s.io/csi-hyperstack/pkg/utils/mount"
+)
+
+const (
+	DoctorRoleController = "controller"
+	DoctorRoleNode       = "node"
+
+	doctorCheckTimeout = 30 * time.Second
+)
+
+// mountInfoPath is read to check the mount propagation of the kubelet directory
+var mountInfoPath = "/proc/self/mountinfo"
+
+// nodeBinaries are run by the node service to format, mount, inspect and resize volumes. Volumes are
+// always formatted with ext4, so the image only ships e2fsprogs.
+var nodeBinaries = []string{"mount", "umount", "blkid", "mkfs.ext4", "resize2fs"}
+
+// DoctorCheck is the result of a preflight check
+type DoctorCheck struct {
+	Name string
+	// Detail describes what was found, e.g. the instance ID read from the metadata service
+	Detail string
+	Err    error
+	// Optional checks cover features that are not always used, their failure is only a warning
+	Optional bool
+}
+
+type doctorCheck struct {
+	name     string
+	optional bool
+	run      func(ctx context.Context) (string, error)
+}
+
+// Doctor runs the preflight checks of a role, so that a misconfiguration is reported up front
+// instead of showing up as a failing CSI call
+func (d *Driver) Doctor(ctx context.Context, role string) ([]DoctorCheck, error) {
+	checks := []doctorCheck{
+		{name: "Hyperstack API credentials", run: d.checkAPI},
+		{name: "CSI socket " + d.opts.Endpoint, run: d.checkSocket},
+	}
+	switch role {
+	case DoctorRoleController:
+		checks = append(checks,
+			doctorCheck{name: "Cluster detail from node label " + hyperstackClusterIdLabelKey, run: d.checkClusterDetail},
+		)
+	case DoctorRoleNode:
+		checks = append(checks,
+			doctorCheck{name: "Node label " + hyperstackInstanceIdLabelKey, run: d.checkInstanceLabel},
+			doctorCheck{name: "Instance metadata", run: d.checkMetadata},
+			doctorCheck{name: "Node binaries", run: d.checkBinaries(nodeBinaries...)},
+			doctorCheck{name: "LUKS binaries", optional: true, run: d.checkBinaries("cryptsetup")},
+			doctorCheck{name: "Mount propagation of " + d.opts.KubeletDir, run: d.checkMountPropagation},
+		)
+	default:
+		return nil, fmt.Errorf("unknown role %q, expected %s or %s", role, DoctorRoleController, DoctorRoleNode)
+	}
+
+	results := make([]DoctorCheck, 0, len(checks))
+	for _, c := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, doctorCheckTimeout)
+		detail, err := c.run(checkCtx)
+		cancel()
+		results = append(results, DoctorCheck{Name: c.name, Detail: detail, Err: err, Optional: c.optional})
+	}
+	return results, nil
+}
+
+func (d *Driver) checkAPI(ctx context.Context) (string, error) {
+	volumes, err := d.hyperstackClient.ListVolumes(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d volumes visible", len(volumes)), nil
+}
+
+// checkSocket checks that the CSI socket can be created, or that an existing one is a socket
+func (d *Driver) checkSocket(ctx context.Context) (string, error) {
+	proto, addr, err := ParseEndpoint(d.opts.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	if proto != "unix" {
+		return "not a unix socket, nothing to check", nil
+	}
+	path := filepath.Clean("/" + addr)
+
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return "", fmt.Errorf("%s exists and is not a socket", path)
+		}
+		return fmt.Sprintf("%s exists with mode %s", path, info.Mode().Perm()), nil
+	}
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create the socket in %s: %w", dir, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return dir + " is writable", nil
+}
+
+func (d *Driver) checkClusterDetail(ctx context.Context) (string, error) {
+	clusterID, environment, err := d.getClusterEnvironment(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cluster %s in environment %s", clusterID, environment), nil
+}
+
+func (d *Driver) checkInstanceLabel(ctx context.Context) (string, error) {
+	instanceID, err := d.getNodeLabel(ctx, hyperstackInstanceIdLabelKey)
+	if err != nil {
+		return "", err
+	}
+	return "instance " + instanceID, nil
+}
+
+func (d *Driver) checkMetadata(ctx context.Context) (string, error) {
+	md := metadata.GetMetadataProviderWithOpts(d.opts.Metadata)
+	instanceID, err := md.GetInstanceID()
+	if err != nil {
+		return "", err
+	}
+	hostname, err := md.GetInstanceHostname()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("instance %s, hostname %s", instanceID, hostname), nil
+}
+
+func (d *Driver) checkBinaries(binaries ...string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		mounter := d.opts.Mounter
+		if mounter == nil {
+			mounter = mount.GetMountProvider()
+		}
+		var missing []string
+		for _, bin := range binaries {
+			if _, err := mounter.Mounter().Exec.LookPath(bin); err != nil {
+				missing = append(missing, bin)
+			}
+		}
+		if len(missing) > 0 {
+			return "", fmt.Errorf("not found in PATH: %s", strings.Join(missing, ", "))
+		}
+		return strings.Join(binaries, ", "), nil
+	}
+}
+
+func (d *Driver) checkMountPropagation(ctx context.Context) (string, error) {
+	mountPoint, shared, err := mount.SharedMountPoint(mountInfoPath, d.opts.KubeletDir)
+	if err != nil {
+		return "", err
+	}
+	if !shared {
+		return "", fmt.Errorf("mount %s is not shared, mount the kubelet directory with mountPropagation: Bidirectional", mountPoint)
+	}
+	return "shared mount " + mountPoint, nil
+}
@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	mountutils "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+	"k8s.io/csi-hyperstack/pkg/utils/mount"
+)
+
+// imageBinaries are the binaries in PATH of the driver image, from busybox, e2fsprogs and cryptsetup
+var imageBinaries = []string{"mount", "umount", "blkid", "mkfs.ext4", "resize2fs", "cryptsetup"}
+
+const testMountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+30 22 8:2 / /var/lib/kubelet rw,relatime %s - ext4 /dev/sda2 rw
+`
+
+func TestDoctor(t *testing.T) {
+	metadata.Set(&metadata.Metadata{UUID: "7d2f5c1e", Name: testNodeName})
+	defer metadata.Clear()
+
+	testCases := []struct {
+		name        string
+		role        string
+		propagation string
+		injected    bool
+		// binaries are found in PATH, all binaries if nil
+		binaries []string
+		failed   []string
+	}{
+		{name: "controller", role: DoctorRoleController, propagation: "shared:2"},
+		{name: "node", role: DoctorRoleNode, propagation: "shared:2"},
+		{name: "node_image_binaries", role: DoctorRoleNode, propagation: "shared:2", binaries: imageBinaries},
+		{name: "missing_mkfs", role: DoctorRoleNode, propagation: "shared:2", binaries: []string{"mount", "umount", "blkid", "cryptsetup"}, failed: []string{"Node binaries"}},
+		{name: "private_kubelet_dir", role: DoctorRoleNode, propagation: "", failed: []string{"Mount propagation of /var/lib/kubelet"}},
+		{name: "bad_api_key", role: DoctorRoleController, propagation: "shared:2", injected: true, failed: []string{"Hyperstack API credentials"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mountInfoPath = filepath.Join(dir, "mountinfo")
+			defer func() { mountInfoPath = "/proc/self/mountinfo" }()
+			if err := os.WriteFile(mountInfoPath, []byte(fmt.Sprintf(testMountInfo, tc.propagation)), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			hs := fake.NewHyperstack()
+			hs.AddCluster(testClusterID, "test-cluster", "CANADA-1")
+			if tc.injected {
+				hs.InjectError("ListVolumes", errors.New("volume listing failed with 401 error"))
+			}
+			kubeClient := k8sfake.NewSimpleClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: testNodeName,
+					Labels: map[string]string{
+						hyperstackClusterIdLabelKey:  strconv.Itoa(testClusterID),
+						hyperstackInstanceIdLabelKey: strconv.Itoa(testVMID),
+					},
+				},
+			})
+			d, err := NewDriver(&DriverOpts{
+				Endpoint:         "unix://" + filepath.Join(dir, "csi.sock"),
+				KubeletDir:       "/var/lib/kubelet",
+				HyperstackClient: hs,
+				KubeClient:       kubeClient,
+				NodeName:         testNodeName,
+				Mounter:          lookPathMount(tc.binaries),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checks, err := d.Doctor(context.Background(), tc.role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failed []string
+			for _, c := range checks {
+				if c.Err != nil {
+					failed = append(failed, c.Name)
+				}
+			}
+			if len(failed) != len(tc.failed) || (len(failed) > 0 && failed[0] != tc.failed[0]) {
+				t.Errorf("failed checks = %v, expected %v", failed, tc.failed)
+			}
+		})
+	}
+
+	d, _ := NewDriver(&DriverOpts{HyperstackClient: fake.NewHyperstack()})
+	if _, err := d.Doctor(context.Background(), "scheduler"); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+}
+
+// lookPathMount returns a fake mount that finds only the binaries in PATH, or all of them if binaries is nil
+func lookPathMount(binaries []string) mount.IMount {
+	if binaries == nil {
+		return mount.NewFakeMount()
+	}
+	return &mount.Mount{BaseMounter: &mountutils.SafeFormatAndMount{
+		Interface: mount.NewFakeMounter(),
+		Exec: &testingexec.FakeExec{
+			DisableScripts: true,
+			LookPathFunc: func(file string) (string, error) {
+				if slices.Contains(binaries, file) {
+					return "/usr/bin/" + file, nil
+				}
+				return "", fmt.Errorf("%s not found", file)
+			},
+		},
+	}}
+}
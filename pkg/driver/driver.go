@@ -4,16 +4,24 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/csi-hyperstack/pkg/hyperstack"
 	"k8s.io/csi-hyperstack/pkg/metrics"
+	"k8s.io/csi-hyperstack/pkg/policy"
+	util "k8s.io/csi-hyperstack/pkg/utils"
+	kubernetes "k8s.io/csi-hyperstack/pkg/utils/kubernetes"
+	"k8s.io/csi-hyperstack/pkg/utils/luks"
 	"k8s.io/csi-hyperstack/pkg/utils/metadata"
 	"k8s.io/csi-hyperstack/pkg/utils/mount"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/keymutex"
 )
 
 var (
@@ -28,12 +36,56 @@ type DriverOpts struct {
 	// Environment          string
 	// HyperstackClusterId  string
 	// HyperstackNodeId     string
-	HyperstackApiKey     string
+	HyperstackApiKey     util.Secret
 	HyperstackApiAddress string
+	HyperstackApiClient  hyperstack.ClientOpts
+	// ExtraTags are stored on every created volume, e.g. cost-allocation keys
+	ExtraTags map[string]string
+	// Policy holds the limits checked before volumes are created or expanded, nil disables them
+	Policy *policy.Policy
+	// DryRun logs mutating Hyperstack calls and node operations instead of running them
+	DryRun bool
+
+	KubeletDir            string
+	NodeReconcileInterval time.Duration
+	// MaxVolumesPerNode is reported to the scheduler by NodeGetInfo, by default defaultMaxVolumesPerNode
+	MaxVolumesPerNode int64
+	// Metadata configures how the node service reads the instance metadata
+	Metadata metadata.Opts
+	// HealthCacheTTL is how long readiness check results are reused, by default defaultHealthCacheTTL
+	HealthCacheTTL time.Duration
+	// GRPC configures TLS, keepalive and limits of the CSI gRPC server
+	GRPC GRPCServerOpts
+	// DrainTimeout is how long in-flight calls may run on shutdown before they are cancelled, by default defaultDrainTimeout
+	DrainTimeout time.Duration
+
+	OrphanGCInterval    time.Duration
+	OrphanGCDelete      bool
+	OrphanGCGracePeriod time.Duration
+
+	// HyperstackClient replaces the client built from the Hyperstack API options, e.g. in tests
+	HyperstackClient hyperstack.IHyperstack
+	// Kubeconfig is a kubeconfig file used instead of the in-cluster config, e.g. for local development
+	Kubeconfig string
+	// KubeClient replaces the client built from Kubeconfig or the in-cluster config
+	KubeClient k8sclient.Interface
+	// EventRecorder replaces the recorder built on the Kubernetes client
+	EventRecorder record.EventRecorder
+	// NodeName is the Kubernetes node the driver runs on, by default NODE_NAME from the downward API.
+	// The controller and node services require it.
+	NodeName string
+	// Mounter replaces the mount provider of the node service
+	Mounter mount.IMount
 }
 
+const (
+	defaultMaxVolumesPerNode = 10
+	defaultDrainTimeout      = 25 * time.Second
+)
+
 var (
 	volNameKeyFromControllerPublishVolume = "hyperstack/volume-name"
+	volumeContextEncryptedKey             = "encrypted"
 )
 
 type Driver struct {
@@ -41,51 +93,94 @@ type Driver struct {
 	version string
 
 	opts *DriverOpts
+	// nodeName is opts.NodeName or else NODE_NAME
+	nodeName string
 
 	// serverMux *http.ServeMux
 
 	hyperstackClient hyperstack.IHyperstack
+	// apiClient is the client built from the Hyperstack API options, closed on shutdown
+	apiClient *hyperstack.HyperstackClient
 
 	serviceIdentity   csi.IdentityServer
 	serviceController csi.ControllerServer
 	serviceNode       csi.NodeServer
 
-	readyMu sync.Mutex
-	ready   bool
+	health *healthChecker
+
+	kubeClientMu sync.Mutex
+	kubeClient   k8sclient.Interface
+	// kubeCache is started by Run and serves the node and PersistentVolumes once synced
+	kubeCache *kubernetes.Cache
+
+	eventRecorderOnce sync.Once
+	eventRecorder     record.EventRecorder
 
 	cscap []*csi.ControllerServiceCapability
 	nscap []*csi.NodeServiceCapability
 	vcap  []*csi.VolumeCapability_AccessMode
 }
 
-func NewDriver(opts *DriverOpts) *Driver {
+func NewDriver(opts *DriverOpts) (*Driver, error) {
 	d := &Driver{}
 	d.opts = opts
-	fmt.Printf("Driver started with opts: %#v\n", d.opts)
+	// Only settings are logged, the options also carry the API key and injected clients
+	klog.InfoS("Driver started",
+		"endpoint", opts.Endpoint,
+		"apiAddress", opts.HyperstackApiAddress,
+		"dryRun", opts.DryRun,
+		"policy", opts.Policy != nil,
+		"extraTags", opts.ExtraTags,
+		"kubeletDir", opts.KubeletDir,
+		"kubeconfig", opts.Kubeconfig,
+		"nodeName", opts.NodeName,
+		"nodeReconcileInterval", opts.NodeReconcileInterval,
+		"maxVolumesPerNode", opts.MaxVolumesPerNode,
+		"orphanGCInterval", opts.OrphanGCInterval,
+		"orphanGCDelete", opts.OrphanGCDelete,
+		"orphanGCGracePeriod", opts.OrphanGCGracePeriod,
+	)
 	d.name = DriverName
 	d.version = DriverVersion
+	d.nodeName = opts.NodeName
+	if d.nodeName == "" {
+		d.nodeName = kubernetes.NodeName()
+	}
 
 	klog.Info("Driver: ", d.name)
 	klog.Info("Driver version: ", d.version)
 	klog.Info("CSI Spec version: ", specVersion)
 
-	d.hyperstackClient = &hyperstack.Hyperstack{
-		Client: hyperstack.NewHyperstackClient(
-			opts.HyperstackApiKey,
+	if opts.HyperstackClient != nil {
+		d.hyperstackClient = opts.HyperstackClient
+	} else {
+		client, err := hyperstack.NewHyperstackClientWithOpts(
+			opts.HyperstackApiKey.Value(),
 			opts.HyperstackApiAddress,
-		),
+			opts.HyperstackApiClient,
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.apiClient = client
+		d.hyperstackClient = &hyperstack.Hyperstack{
+			Client: client,
+		}
 	}
+	d.kubeClient = opts.KubeClient
 
-	d.readyMu.Lock()
-	d.ready = true
-	d.readyMu.Unlock()
+	if opts.DryRun {
+		klog.Warning("Dry run mode: Hyperstack volumes and node mounts will not be changed")
+		d.hyperstackClient = hyperstack.NewDryRun(d.hyperstackClient)
+	}
 
+	d.health = newHealthChecker(opts.HealthCacheTTL)
+
+	// ListVolumes and ControllerGetVolume are not implemented, so LIST_VOLUMES, LIST_VOLUMES_PUBLISHED_NODES
+	// and GET_VOLUME are not advertised
 	d.cscap = MapControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
-		csi.ControllerServiceCapability_RPC_GET_VOLUME,
 	})
 
 	d.nscap = MapNodeServiceCapabilities([]csi.NodeServiceCapability_RPC_Type{
@@ -98,7 +193,7 @@ func NewDriver(opts *DriverOpts) *Driver {
 		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	})
 
-	return d
+	return d, nil
 }
 
 func (d *Driver) ValidateControllerServiceRequest(c csi.ControllerServiceCapability_RPC_Type) error {
@@ -115,6 +210,64 @@ func (d *Driver) ValidateControllerServiceRequest(c csi.ControllerServiceCapabil
 	return status.Error(codes.InvalidArgument, c.String())
 }
 
+// getKubeClient returns the Kubernetes client, creating it on first use. The client is shared by
+// every caller, so that its connections and rate limiter are reused.
+func (d *Driver) getKubeClient() (k8sclient.Interface, error) {
+	d.kubeClientMu.Lock()
+	defer d.kubeClientMu.Unlock()
+	if d.kubeClient == nil {
+		clientset, err := kubernetes.NewClientset(d.opts.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		d.kubeClient = clientset
+	}
+	return d.kubeClient, nil
+}
+
+// getNodeName returns the name of the Kubernetes node the driver runs on. The instance hostname is
+// not used instead, as it often differs from the node name.
+func (d *Driver) getNodeName() (string, error) {
+	if d.nodeName == "" {
+		return "", fmt.Errorf("the Kubernetes node name is unknown, set %s", kubernetes.NodeNameEnv)
+	}
+	return d.nodeName, nil
+}
+
+// getNodeLabel returns a label of the node the driver runs on, from the cache once it has synced
+func (d *Driver) getNodeLabel(ctx context.Context, labelKey string) (string, error) {
+	nodeName, err := d.getNodeName()
+	if err != nil {
+		return "", err
+	}
+	if d.kubeCache != nil && d.kubeCache.HasSynced() {
+		return d.kubeCache.GetNodeLabel(nodeName, labelKey)
+	}
+	clientset, err := d.getKubeClient()
+	if err != nil {
+		return "", err
+	}
+	return kubernetes.GetNodeLabelFromClient(ctx, clientset, nodeName, labelKey)
+}
+
+// startKubeCache starts the informers of the node and, on the controller, of the PersistentVolumes.
+// Lookups go to the API server until they have synced, so an unreachable API server does not
+// keep the driver from serving.
+func (d *Driver) startKubeCache(ctx context.Context) {
+	clientset, err := d.getKubeClient()
+	if err != nil {
+		klog.Warningf("Kubernetes cache is disabled: %v", err)
+		return
+	}
+	nodeName, err := d.getNodeName()
+	if err != nil {
+		klog.Warningf("Kubernetes cache is disabled: %v", err)
+		return
+	}
+	d.kubeCache = kubernetes.NewCache(clientset, nodeName, d.serviceController != nil)
+	d.kubeCache.Start(ctx)
+}
+
 func (d *Driver) SetupIdentityService() {
 	klog.Info("Providing identity service")
 	d.serviceIdentity = &identityServer{
@@ -125,33 +278,55 @@ func (d *Driver) SetupIdentityService() {
 func (d *Driver) SetupControllerService() {
 	klog.Info("Providing controller service")
 	d.serviceController = &controllerServer{
-		driver: d,
+		driver:         d,
+		namespaceLocks: keymutex.NewHashed(0),
 	}
+	d.health.add("hyperstack-api", d.checkAPIReady)
 }
 
 func (d *Driver) SetupNodeService() {
 	klog.Info("Providing node service")
-	d.serviceNode = &nodeServer{
+	mounter := d.opts.Mounter
+	if mounter == nil {
+		mounter = mount.GetMountProvider()
+	}
+	ns := &nodeServer{
 		driver:   d,
-		mount:    mount.GetMountProvider(),
-		metadata: metadata.GetMetadataProvider(d.hyperstackClient.GetMetadataOpts().SearchOrder),
+		mount:    mounter,
+		metadata: metadata.GetMetadataProviderWithOpts(d.opts.Metadata),
+		luks:     luks.GetLuksProvider(),
 	}
+	if d.opts.DryRun {
+		ns.mount = &mount.DryRun{IMount: ns.mount}
+		ns.luks = &luks.DryRun{ILuks: ns.luks}
+	}
+	d.serviceNode = ns
+	d.health.add("metadata", d.checkMetadataReady)
+	d.health.add("node-id", d.checkNodeIDReady)
 }
 
 func (d *Driver) Run(
 	ctx context.Context,
-	wg *sync.WaitGroup,
+	errs chan<- error,
 ) (*grpc.Server, error) {
 	if nil == d.serviceController && nil == d.serviceNode {
 		return nil, fmt.Errorf("no CSI services initialized")
 	}
+	// The services look up their node's labels, so fail now rather than in every CSI call
+	if _, err := d.getNodeName(); err != nil {
+		return nil, err
+	}
 
 	metrics.RegisterMetrics("hyperstack-csi")
 
+	// Started before the gRPC server, so that no CSI call reads kubeCache while it is set
+	d.startKubeCache(ctx)
+
 	srv, err := RunGRPCServer(
 		ctx,
-		wg,
+		errs,
 		d.opts.Endpoint,
+		d.opts.GRPC,
 		d.serviceIdentity,
 		d.serviceController,
 		d.serviceNode,
@@ -160,5 +335,29 @@ func (d *Driver) Run(
 		return nil, fmt.Errorf("failed running gRPC server: %w", err)
 	}
 
+	if d.serviceController != nil && d.opts.OrphanGCInterval > 0 {
+		go d.runOrphanCollector(ctx, d.opts.OrphanGCInterval, OrphanCollectorOpts{
+			Delete:      d.opts.OrphanGCDelete,
+			GracePeriod: d.opts.OrphanGCGracePeriod,
+		})
+	}
+
+	if ns, ok := d.serviceNode.(*nodeServer); ok {
+		go ns.runMountReconciler(ctx, d.opts.KubeletDir, d.opts.NodeReconcileInterval)
+	}
+
 	return srv, nil
 }
+
+// Shutdown stops the gRPC server started by Run, draining in-flight calls for at most DrainTimeout,
+// and releases the Hyperstack client. The background loops of Run stop with its context.
+func (d *Driver) Shutdown(srv *grpc.Server) {
+	drainTimeout := d.opts.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	StopGRPCServer(srv, d.opts.Endpoint, drainTimeout)
+	if d.apiClient != nil {
+		d.apiClient.Close()
+	}
+}
@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	kubernetes "k8s.io/csi-hyperstack/pkg/utils/kubernetes"
+)
+
+func TestGetNodeName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opt      string
+		env      string
+		expected string
+	}{
+		{name: "option", opt: "worker-1", env: "worker-2", expected: "worker-1"},
+		{name: "env", env: "worker-2", expected: "worker-2"},
+		{name: "unset"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(kubernetes.NodeNameEnv, tc.env)
+			d, err := NewDriver(&DriverOpts{HyperstackClient: fake.NewHyperstack(), NodeName: tc.opt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodeName, err := d.getNodeName()
+			if nodeName != tc.expected || (err != nil) != (tc.expected == "") {
+				t.Errorf("getNodeName() = %q, %v, expected %q", nodeName, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRunRequiresNodeName(t *testing.T) {
+	t.Setenv(kubernetes.NodeNameEnv, "")
+	d, err := NewDriver(&DriverOpts{
+		Endpoint:         "unix://" + t.TempDir() + "/csi.sock",
+		HyperstackClient: fake.NewHyperstack(),
+		KubeClient:       k8sfake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetupIdentityService()
+	d.SetupControllerService()
+
+	srv, err := d.Run(context.Background(), make(chan error, 1))
+	if err == nil {
+		srv.Stop()
+		t.Fatal("Run() without a node name succeeded")
+	}
+}
@@ -0,0 +1,79 @@
+package driver
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	kubernetes "k8s.io/csi-hyperstack/pkg/utils/kubernetes"
+)
+
+const (
+	eventReasonVolumeTypeInvalid = "VolumeTypeInvalid"
+	eventReasonQuotaExceeded     = "QuotaExceeded"
+	eventReasonAttachTimeout     = "AttachTimeout"
+	eventReasonFormatRefused     = "FormatRefused"
+	eventReasonMountFailed       = "MountFailed"
+
+	// pvcNameKey is set with pvcNamespaceKey by the csi-provisioner with --extra-create-metadata
+	pvcNameKey = "csi.storage.k8s.io/pvc/name"
+	// The pod keys are set in the NodePublishVolume volume context by the kubelet when the CSIDriver has podInfoOnMount
+	podNameKey      = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey       = "csi.storage.k8s.io/pod.uid"
+)
+
+// getEventRecorder returns the recorder shared by the CSI services and the background loops, or nil
+// when there is no Kubernetes client
+func (d *Driver) getEventRecorder() record.EventRecorder {
+	d.eventRecorderOnce.Do(func() {
+		if d.opts.EventRecorder != nil {
+			d.eventRecorder = d.opts.EventRecorder
+			return
+		}
+		clientset, err := d.getKubeClient()
+		if err != nil {
+			klog.Errorf("getEventRecorder: Events are disabled: %v", err)
+			return
+		}
+		d.eventRecorder = kubernetes.NewEventRecorder(clientset, d.name)
+	})
+	return d.eventRecorder
+}
+
+// eventObject returns the object Events about a volume are recorded on: the pod from the pod info in
+// a volume context, else the PVC from the CreateVolume parameters or the volume context. It returns
+// nil when neither is known.
+func eventObject(params map[string]string) *corev1.ObjectReference {
+	if params[podNameKey] != "" && params[podNamespaceKey] != "" {
+		return &corev1.ObjectReference{
+			Kind:      "Pod",
+			Name:      params[podNameKey],
+			Namespace: params[podNamespaceKey],
+			UID:       types.UID(params[podUIDKey]),
+		}
+	}
+	if params[pvcNameKey] != "" && params[pvcNamespaceKey] != "" {
+		return &corev1.ObjectReference{
+			Kind:      "PersistentVolumeClaim",
+			Name:      params[pvcNameKey],
+			Namespace: params[pvcNamespaceKey],
+		}
+	}
+	return nil
+}
+
+// recordWarning records a Warning Event on the pod or PVC of params, see eventObject. The Event is
+// dropped when the object is not known.
+func (d *Driver) recordWarning(params map[string]string, reason string, messageFmt string, args ...interface{}) {
+	object := eventObject(params)
+	if object == nil {
+		return
+	}
+	recorder := d.getEventRecorder()
+	if recorder == nil {
+		return
+	}
+	recorder.Eventf(object, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
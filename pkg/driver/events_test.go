@@ -0,0 +1,163 @@
+package driver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	"k8s.io/csi-hyperstack/pkg/policy"
+)
+
+func TestEventObject(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params map[string]string
+		kind   string
+		object string
+	}{
+		{"pod", map[string]string{podNameKey: "web-0", podNamespaceKey: "shop", podUIDKey: "uid-1", pvcNameKey: "data-web-0", pvcNamespaceKey: "shop"}, "Pod", "shop/web-0"},
+		{"pvc", map[string]string{pvcNameKey: "data-web-0", pvcNamespaceKey: "shop"}, "PersistentVolumeClaim", "shop/data-web-0"},
+		{"pvc_without_namespace", map[string]string{pvcNameKey: "data-web-0"}, "", ""},
+		{"none", nil, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref := eventObject(tc.params)
+			if ref == nil {
+				if tc.kind != "" {
+					t.Fatalf("eventObject() = nil, expected %s %s", tc.kind, tc.object)
+				}
+				return
+			}
+			if ref.Kind != tc.kind || ref.Namespace+"/"+ref.Name != tc.object {
+				t.Errorf("eventObject() = %s %s/%s, expected %s %s", ref.Kind, ref.Namespace, ref.Name, tc.kind, tc.object)
+			}
+		})
+	}
+}
+
+func TestControllerEvents(t *testing.T) {
+	pvcParams := func(params map[string]string) map[string]string {
+		params[pvcNameKey] = "data-web-0"
+		params[pvcNamespaceKey] = "shop"
+		return params
+	}
+
+	testCases := []struct {
+		name     string
+		req      *csi.CreateVolumeRequest
+		policy   *policy.Policy
+		injected error
+		code     codes.Code
+		reason   string
+	}{
+		{
+			name:   "volume_type_not_allowed",
+			req:    createVolumeRequest("pvc-1", 10, pvcParams(map[string]string{"type": "Cloud-HDD"})),
+			policy: &policy.Policy{AllowedVolumeTypes: []string{"Cloud-SSD"}},
+			code:   codes.InvalidArgument,
+			reason: eventReasonVolumeTypeInvalid,
+		},
+		{
+			name:   "namespace_quota_exceeded",
+			req:    createVolumeRequest("pvc-1", 100, pvcParams(map[string]string{"type": "Cloud-SSD"})),
+			policy: &policy.Policy{NamespaceQuota: &policy.NamespaceQuota{MaxTotalGiB: 50}},
+			code:   codes.ResourceExhausted,
+			reason: eventReasonQuotaExceeded,
+		},
+		{
+			name:     "hyperstack_quota_exceeded",
+			req:      createVolumeRequest("pvc-1", 10, pvcParams(map[string]string{"type": "Cloud-SSD"})),
+			injected: errors.New(`volume creation failed for volume pvc-1 with status 400: {"message":"Volume quota exceeded"}`),
+			code:     codes.ResourceExhausted,
+			reason:   eventReasonQuotaExceeded,
+		},
+		{
+			name:     "hyperstack_unknown_volume_type",
+			req:      createVolumeRequest("pvc-1", 10, pvcParams(map[string]string{"type": "Cloud-XYZ"})),
+			injected: errors.New(`volume creation failed for volume pvc-1 with status 400: {"message":"Invalid volume type Cloud-XYZ"}`),
+			code:     codes.InvalidArgument,
+			reason:   eventReasonVolumeTypeInvalid,
+		},
+		{
+			name:     "hyperstack_unavailable",
+			req:      createVolumeRequest("pvc-1", 10, pvcParams(map[string]string{"type": "Cloud-SSD"})),
+			injected: errors.New("connection refused"),
+			code:     codes.Internal,
+		},
+		{
+			name:   "no_pvc_metadata",
+			req:    createVolumeRequest("pvc-1", 10, map[string]string{"type": "Cloud-HDD"}),
+			policy: &policy.Policy{AllowedVolumeTypes: []string{"Cloud-SSD"}},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, hs := newTestController(t, tc.policy)
+			recorder := record.NewFakeRecorder(10)
+			cs.driver.opts.EventRecorder = recorder
+			if tc.injected != nil {
+				hs.InjectError("CreateVolume", tc.injected)
+			}
+
+			_, err := cs.CreateVolume(context.Background(), tc.req)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("CreateVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			expectEvent(t, recorder, tc.reason)
+		})
+	}
+}
+
+func TestControllerPublishVolumeAttachTimeout(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		attachTimeout, attachPollInterval = timeout, interval
+	}(attachTimeout, attachPollInterval)
+	attachTimeout, attachPollInterval = 50*time.Millisecond, 10*time.Millisecond
+
+	cs, hs := newTestController(t, nil)
+	recorder := record.NewFakeRecorder(10)
+	cs.driver.opts.EventRecorder = recorder
+	volumeID := strconv.Itoa(hs.AddVolume(fake.Volume{Name: "pvc-1", Size: 10, Status: "attaching"}))
+
+	_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volumeID,
+		NodeId:           strconv.Itoa(testVMID),
+		VolumeCapability: mountVolumeCapability(),
+		VolumeContext:    map[string]string{pvcNameKey: "data-web-0", pvcNamespaceKey: "shop"},
+	})
+	if code := status.Code(err); code != codes.DeadlineExceeded {
+		t.Fatalf("ControllerPublishVolume() code = %v, expected %v: %v", code, codes.DeadlineExceeded, err)
+	}
+	expectEvent(t, recorder, eventReasonAttachTimeout)
+}
+
+// expectEvent checks that the recorder got exactly one Warning with the reason, or no Event if reason is empty
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, reason string) {
+	t.Helper()
+	select {
+	case event := <-recorder.Events:
+		if reason == "" {
+			t.Fatalf("unexpected event %q", event)
+		}
+		if !strings.HasPrefix(event, "Warning "+reason+" ") {
+			t.Fatalf("event = %q, expected a Warning with reason %s", event, reason)
+		}
+	default:
+		if reason != "" {
+			t.Fatalf("no event recorded, expected %s", reason)
+		}
+	}
+}
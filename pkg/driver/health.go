@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+)
+
+const (
+	defaultHealthCacheTTL = 30 * time.Second
+	healthCheckTimeout    = 10 * time.Second
+)
+
+// HealthResult is the outcome of a readiness check
+type HealthResult struct {
+	Name string
+	Err  error
+}
+
+// HealthReporter reports whether the dependencies needed to serve CSI calls are available
+type HealthReporter interface {
+	Ready(ctx context.Context) (bool, []HealthResult)
+}
+
+type healthCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// healthChecker runs the readiness checks of the driver. Results are cached for ttl, so that the
+// kubelet, the livenessprobe sidecar and Probe calls do not each reach the Hyperstack API.
+type healthChecker struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	checks    []healthCheck
+	checkedAt time.Time
+	results   []HealthResult
+}
+
+func newHealthChecker(ttl time.Duration) *healthChecker {
+	if ttl <= 0 {
+		ttl = defaultHealthCacheTTL
+	}
+	return &healthChecker{ttl: ttl}
+}
+
+func (h *healthChecker) add(name string, run func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, healthCheck{name: name, run: run})
+	h.checkedAt = time.Time{}
+}
+
+// check returns the cached results, running the checks again if they are older than the TTL.
+// The checks are not bound to ctx, as their results are shared by every caller.
+func (h *healthChecker) check() []HealthResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.checkedAt.IsZero() && time.Since(h.checkedAt) < h.ttl {
+		return h.results
+	}
+
+	results := make([]HealthResult, 0, len(h.checks))
+	for _, c := range h.checks {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		results = append(results, HealthResult{Name: c.name, Err: c.run(ctx)})
+		cancel()
+	}
+	h.results = results
+	h.checkedAt = time.Now()
+	return results
+}
+
+// Ready reports whether every readiness check of the enabled services passes
+func (d *Driver) Ready(ctx context.Context) (bool, []HealthResult) {
+	results := d.health.check()
+	for _, r := range results {
+		if r.Err != nil {
+			return false, results
+		}
+	}
+	return true, results
+}
+
+// checkAPIReady checks that the Hyperstack API is reachable and accepts the API key
+func (d *Driver) checkAPIReady(ctx context.Context) error {
+	if _, err := d.hyperstackClient.ListVolumes(ctx); err != nil {
+		return fmt.Errorf("hyperstack API: %w", err)
+	}
+	return nil
+}
+
+// checkMetadataReady checks that the instance metadata can be read
+func (d *Driver) checkMetadataReady(ctx context.Context) error {
+	if _, err := metadata.GetMetadataProviderWithOpts(d.opts.Metadata).GetInstanceID(); err != nil {
+		return fmt.Errorf("instance metadata: %w", err)
+	}
+	return nil
+}
+
+// checkNodeIDReady checks that the node ID reported by NodeGetInfo is known
+func (d *Driver) checkNodeIDReady(ctx context.Context) error {
+	if _, err := d.getNodeLabel(ctx, hyperstackInstanceIdLabelKey); err != nil {
+		return fmt.Errorf("node ID: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+	"k8s.io/csi-hyperstack/pkg/utils/mount"
+)
+
+func TestReadiness(t *testing.T) {
+	metadata.Set(&metadata.Metadata{UUID: "7d2f5c1e", Name: testNodeName})
+	defer metadata.Clear()
+
+	testCases := []struct {
+		name       string
+		controller bool
+		node       bool
+		injected   string
+		nodeLabels map[string]string
+		ready      bool
+		body       string
+	}{
+		{
+			name:       "controller_ready",
+			controller: true,
+			ready:      true,
+			body:       "[+]hyperstack-api ok",
+		},
+		{
+			name:       "bad_api_key",
+			controller: true,
+			injected:   "ListVolumes",
+			body:       "[-]hyperstack-api failed: hyperstack API: volume listing failed with 401 error",
+		},
+		{
+			name:       "node_ready",
+			node:       true,
+			nodeLabels: map[string]string{hyperstackInstanceIdLabelKey: strconv.Itoa(testVMID)},
+			ready:      true,
+			body:       "[+]node-id ok",
+		},
+		{
+			name: "node_id_unknown",
+			node: true,
+			body: "[-]node-id failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hs := fake.NewHyperstack()
+			if tc.injected != "" {
+				hs.InjectError(tc.injected, errors.New("volume listing failed with 401 error"))
+			}
+			d, err := NewDriver(&DriverOpts{
+				HyperstackClient: hs,
+				KubeClient: k8sfake.NewSimpleClientset(&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: testNodeName, Labels: tc.nodeLabels},
+				}),
+				NodeName: testNodeName,
+				Mounter:  mount.NewFakeMount(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.SetupIdentityService()
+			if tc.controller {
+				d.SetupControllerService()
+			}
+			if tc.node {
+				d.SetupNodeService()
+			}
+
+			resp, err := d.serviceIdentity.Probe(context.Background(), &csi.ProbeRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetReady().GetValue() != tc.ready {
+				t.Errorf("Probe() ready = %v, expected %v", resp.GetReady().GetValue(), tc.ready)
+			}
+
+			rec := httptest.NewRecorder()
+			readyzHandler(d).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			expectedCode := http.StatusOK
+			if !tc.ready {
+				expectedCode = http.StatusServiceUnavailable
+			}
+			if rec.Code != expectedCode {
+				t.Errorf("/readyz code = %d, expected %d", rec.Code, expectedCode)
+			}
+			if !strings.Contains(rec.Body.String(), tc.body) {
+				t.Errorf("/readyz body = %q, expected it to contain %q", rec.Body.String(), tc.body)
+			}
+
+			// Probe and /readyz share the cached result
+			if tc.controller && hs.Calls("ListVolumes") != 1 {
+				t.Errorf("ListVolumes was called %d times, expected 1", hs.Calls("ListVolumes"))
+			}
+		})
+	}
+}
+
+func TestHealthCheckerCache(t *testing.T) {
+	h := newHealthChecker(time.Hour)
+	runs := 0
+	h.add("counter", func(ctx context.Context) error {
+		runs++
+		return nil
+	})
+
+	h.check()
+	h.check()
+	if runs != 1 {
+		t.Errorf("check ran %d times within the TTL, expected 1", runs)
+	}
+
+	h.ttl = 0
+	h.check()
+	if runs != 2 {
+		t.Errorf("check ran %d times after the TTL, expected 2", runs)
+	}
+}
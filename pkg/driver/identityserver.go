@@ -32,13 +32,17 @@ func (ids *identityServer) GetPluginInfo(
 	}, nil
 }
 
+// Probe reports whether the dependencies of the enabled services are available, see Driver.Ready
 func (ids *identityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	klog.Infof("probe called")
-	ids.driver.readyMu.Lock()
-	defer ids.driver.readyMu.Unlock()
+	ready, results := ids.driver.Ready(ctx)
+	for _, r := range results {
+		if r.Err != nil {
+			klog.FromContext(ctx).Info("Readiness check failed", "check", r.Name, "err", r.Err)
+		}
+	}
 	return &csi.ProbeResponse{
 		Ready: &wrappers.BoolValue{
-			Value: ids.driver.ready,
+			Value: ready,
 		},
 	}, nil
 }
@@ -1,63 +1,211 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"strings"
+	"path/filepath"
+	"strconv"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	// "github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"k8s.io/klog/v2"
 
 	// cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
-	kubernetes "k8s.io/csi-hyperstack/pkg/utils/kubernetes"
+	"k8s.io/csi-hyperstack/pkg/utils/luks"
 	"k8s.io/csi-hyperstack/pkg/utils/metadata"
 	"k8s.io/csi-hyperstack/pkg/utils/mount"
+	mountutils "k8s.io/mount-utils"
 )
 
 const (
 	hyperstackInstanceIdLabelKey = "hyperstack.cloud/instance-id"
+
+	// encryptionPassphraseKey is the NodeStageSecrets/NodeExpandSecrets key holding the LUKS passphrase
+	encryptionPassphraseKey = "encryptionPassphrase"
+	// luksDiskFormat is the format blkid reports for a device with a LUKS header
+	luksDiskFormat = "crypto_LUKS"
 )
 
 type nodeServer struct {
 	driver   *Driver
 	mount    mount.IMount
 	metadata metadata.IMetadata
+	luks     luks.ILuks
 	csi.UnimplementedNodeServer
 }
 
 func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	klog.Infof("\n==============NodeStageVolume: called================\n")
-	klog.Infof("NodeStageVolume: called with args %+v", protosanitizer.StripSecrets(*req))
+	logger := klog.FromContext(ctx)
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume: Volume ID must be provided")
+	}
+	if len(req.GetStagingTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume: Staging Target Path must be provided")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume: Volume capability must be provided")
+	}
 	devicename := req.PublishContext[volNameKeyFromControllerPublishVolume]
 	if devicename == "" {
 		return nil, status.Error(codes.InvalidArgument, "Device name not found in publish context. Please wait for volume to be attached.")
 	}
-	klog.Infof("NodeStageVolume: devicename from publish context: %s", devicename)
-	err := formateAndMakeFS(devicename, "ext4")
+	logger.V(4).Info("Got device from publish context", "device", devicename)
+	encrypted, err := isEncryptedVolume(req.GetVolumeContext())
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	source := devicename
+	if encrypted {
+		source, err = ns.openEncryptedDevice(ctx, req.GetVolumeId(), devicename, req.GetVolumeContext(), req.GetSecrets())
+		if err != nil {
+			return nil, err
+		}
+		// The LUKS layer survives unstage, so only create the filesystem once
+		existingFormat, err := ns.mount.Mounter().GetDiskFormat(source)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeStageVolume: failed to get disk format of %s: %v", source, err)
+		}
+		if existingFormat == "" {
+			if err := ns.formateAndMakeFS(ctx, source, "ext4"); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// Only create the filesystem on an empty device, mkfs would destroy the data of a restaged
+		// volume or of a volume that was encrypted before
+		existingFormat, err := ns.mount.Mounter().GetDiskFormat(devicename)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeStageVolume: failed to get disk format of %s: %v", devicename, err)
+		}
+		switch existingFormat {
+		case "":
+			if err := ns.formateAndMakeFS(ctx, devicename, "ext4"); err != nil {
+				return nil, err
+			}
+		case "ext4":
+			logger.V(4).Info("Device already has a filesystem", "device", devicename, "format", existingFormat)
+		case luksDiskFormat:
+			ns.driver.recordWarning(req.GetVolumeContext(), eventReasonFormatRefused,
+				"Volume %s is not encrypted but device %s has a LUKS header, refusing to format it", req.GetVolumeId(), devicename)
+			return nil, status.Errorf(codes.FailedPrecondition, "NodeStageVolume: volume %s is not encrypted but device %s has a LUKS header", req.GetVolumeId(), devicename)
+		default:
+			ns.driver.recordWarning(req.GetVolumeContext(), eventReasonFormatRefused,
+				"Volume %s holds %s data on device %s, refusing to mount it as ext4", req.GetVolumeId(), existingFormat, devicename)
+			return nil, status.Errorf(codes.FailedPrecondition, "NodeStageVolume: volume %s has %s data on device %s, expected ext4", req.GetVolumeId(), existingFormat, devicename)
+		}
 	}
 
 	target := req.StagingTargetPath
-	err = mountDevice(devicename, target, "ext4", []string{})
+	err = ns.mountDevice(ctx, source, target, "ext4", []string{})
 	if err != nil {
 		return nil, err
 	}
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
-func formateAndMakeFS(device string, fstype string) error {
-	klog.Infof("formateAndMakeFS: called with args %s, %s", device, fstype)
+// sameDevice reports whether two device paths name the same device, following symlinks such as /dev/disk/by-id
+func sameDevice(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	resolvedA, errA := filepath.EvalSymlinks(a)
+	resolvedB, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && resolvedA == resolvedB
+}
+
+// isEncryptedVolume reads the "encrypted" flag CreateVolume copied from the StorageClass into the volume context
+func isEncryptedVolume(volumeContext map[string]string) (bool, error) {
+	value, ok := volumeContext[volumeContextEncryptedKey]
+	if !ok || value == "" {
+		return false, nil
+	}
+	encrypted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for volume context key %q", value, volumeContextEncryptedKey)
+	}
+	return encrypted, nil
+}
+
+// openEncryptedDevice formats the device with LUKS on first use, opens it and returns the mapper device path.
+// A device that already holds a filesystem is not formatted, as that would destroy its data.
+func (ns *nodeServer) openEncryptedDevice(ctx context.Context, volumeID string, device string, volumeContext map[string]string, secrets map[string]string) (string, error) {
+	passphrase := secrets[encryptionPassphraseKey]
+	if passphrase == "" {
+		return "", status.Errorf(codes.InvalidArgument, "NodeStageVolume: encrypted volume %s requires %q in node stage secrets", volumeID, encryptionPassphraseKey)
+	}
+
+	mapperName := luks.MapperName(volumeID)
+	open, err := ns.luks.IsOpen(mapperName)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "NodeStageVolume: failed to check LUKS device %s: %v", mapperName, err)
+	}
+	if open {
+		backingDevice, err := ns.luks.BackingDevice(mapperName)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "NodeStageVolume: failed to get the backing device of %s: %v", mapperName, err)
+		}
+		if sameDevice(backingDevice, device) {
+			klog.FromContext(ctx).Info("LUKS device is already open", "mapper", mapperName)
+			return luks.MapperPath(mapperName), nil
+		}
+		// The mapping outlives the disk it was opened from, e.g. when the volume was detached while the node was down
+		klog.FromContext(ctx).Info("Closing LUKS device opened from another device", "mapper", mapperName, "backingDevice", backingDevice, "device", device)
+		if err := ns.luks.Close(mapperName); err != nil {
+			return "", status.Errorf(codes.Internal, "NodeStageVolume: %v", err)
+		}
+	}
+
+	isLuks, err := ns.luks.IsLuks(device)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "NodeStageVolume: %v", err)
+	}
+	if !isLuks {
+		existingFormat, err := ns.mount.Mounter().GetDiskFormat(device)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "NodeStageVolume: failed to get disk format of %s: %v", device, err)
+		}
+		if existingFormat != "" {
+			ns.driver.recordWarning(volumeContext, eventReasonFormatRefused,
+				"Volume %s is encrypted but device %s holds %s data, refusing to format it with LUKS", volumeID, device, existingFormat)
+			return "", status.Errorf(codes.FailedPrecondition, "NodeStageVolume: encrypted volume %s has %s data on device %s and no LUKS header", volumeID, existingFormat, device)
+		}
+		klog.FromContext(ctx).Info("Device has no LUKS header, formatting", "device", device)
+		if err := ns.luks.Format(device, passphrase); err != nil {
+			if errors.Is(err, luks.ErrDeviceHasData) {
+				return "", status.Errorf(codes.FailedPrecondition, "NodeStageVolume: %v", err)
+			}
+			return "", status.Errorf(codes.Internal, "NodeStageVolume: %v", err)
+		}
+	}
+	if err := ns.luks.Open(device, mapperName, passphrase); err != nil {
+		return "", status.Errorf(codes.Internal, "NodeStageVolume: %v", err)
+	}
+	return luks.MapperPath(mapperName), nil
+}
+
+func (ns *nodeServer) formateAndMakeFS(ctx context.Context, device string, fstype string) (err error) {
+	logger := klog.FromContext(ctx)
+	_, span := tracer.Start(ctx, "mkfs", trace.WithAttributes(
+		attribute.String("device", device),
+		attribute.String("fs_type", fstype),
+	))
+	defer func() { endSpan(span, err) }()
+	if ns.driver.opts.DryRun {
+		logger.Info("Dry run: skipping mutating call", "action", "Mkfs", "device", device, "fsType", fstype)
+		return nil
+	}
 	mkfsCmd := fmt.Sprintf("mkfs.%s", fstype)
+	executor := ns.mount.Mounter().Exec
 
-	_, err := exec.LookPath(mkfsCmd)
+	_, err = executor.LookPath(mkfsCmd)
 	if err != nil {
 		return fmt.Errorf("unable to find the mkfs (%s) utiltiy errors is %s", mkfsCmd, err.Error())
 	}
@@ -65,48 +213,45 @@ func formateAndMakeFS(device string, fstype string) error {
 	// actually run mkfs.ext4 -F source
 	mkfsArgs := []string{"-F", device}
 
-	out, err := exec.Command(mkfsCmd, mkfsArgs...).CombinedOutput()
+	// mkfs is killed if the call is cancelled, e.g. when the driver shuts down
+	out, err := executor.CommandContext(ctx, mkfsCmd, mkfsArgs...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("create fs command failed output: %s, and err: %s", out, err.Error())
 	}
-	klog.Infof("formateAndMakeFS: command output: %s", out)
+	logger.Info("Created filesystem", "device", device, "fsType", fstype, "output", string(out))
 	return nil
 }
 
-func mountDevice(source string, target string, fsType string, options []string) error {
-	klog.Infof("mountDevice: called with args %s, %s, %s, %v", source, target, fsType, options)
-	mountCmd := "mount"
-
+func (ns *nodeServer) mountDevice(ctx context.Context, source string, target string, fsType string, options []string) (err error) {
+	logger := klog.FromContext(ctx)
+	_, span := tracer.Start(ctx, "mount", trace.WithAttributes(
+		attribute.String("source", source),
+		attribute.String("target", target),
+		attribute.String("fs_type", fsType),
+	))
+	defer func() { endSpan(span, err) }()
+	if ns.driver.opts.DryRun {
+		logger.Info("Dry run: skipping mutating call", "action", "Mount", "source", source, "target", target, "fsType", fsType, "options", options)
+		return nil
+	}
 	if fsType == "" {
 		return fmt.Errorf("fstype is not provided")
 	}
 
-	mountArgs := []string{}
-	err := os.MkdirAll(target, 0777)
+	err = ns.mount.MakeDir(target)
 	if err != nil {
 		return fmt.Errorf("error: %s, creating the target dir", err.Error())
 	}
-	mountArgs = append(mountArgs, "-t", fsType)
-
-	// check of options and then append them at the end of the mount command
-	if len(options) > 0 {
-		mountArgs = append(mountArgs, "-o", strings.Join(options, ","))
-	}
 
-	mountArgs = append(mountArgs, source)
-	mountArgs = append(mountArgs, target)
-
-	out, err := exec.Command(mountCmd, mountArgs...).CombinedOutput()
-	klog.Infof("mountDevice: command output: %s", out)
+	err = ns.mount.Mounter().Mount(source, target, fsType, options)
 	if err != nil {
-		return fmt.Errorf("error %s, mounting the source %s to tar %s. Output: %s", err.Error(), source, target, out)
+		return fmt.Errorf("error %s, mounting the source %s to tar %s", err.Error(), source, target)
 	}
+	logger.Info("Mounted device", "source", source, "target", target, "fsType", fsType, "options", options)
 	return nil
 }
 
 func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	klog.Infof("==============NodeUnstageVolume: called================\n")
-	klog.Infof("NodeUnstageVolume: called with args %+v", protosanitizer.StripSecrets(*req))
 
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
@@ -123,12 +268,33 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Errorf(codes.Internal, "Unmount of targetPath %s failed with error %v", stagingTargetPath, err)
 	}
 
+	mapperName := luks.MapperName(volumeID)
+	open, err := ns.luks.IsOpen(mapperName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check LUKS device %s: %v", mapperName, err)
+	}
+	if open {
+		if err := ns.luks.Close(mapperName); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to close LUKS device %s: %v", mapperName, err)
+		}
+	}
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.Infof("==============NodePublishVolume: called================\n")
-	klog.Infof("NodePublishVolume: called with args %+v", protosanitizer.StripSecrets(*req))
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Volume ID must be provided")
+	}
+	if len(req.GetStagingTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Staging Target Path must be provided")
+	}
+	if len(req.GetTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Target Path must be provided")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Volume capability must be provided")
+	}
 
 	options := []string{"bind"}
 	if req.Readonly {
@@ -136,15 +302,16 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 
 	fsType := "ext4"
-	if req.VolumeCapability.GetMount().FsType != "" {
-		fsType = req.VolumeCapability.GetMount().FsType
+	if req.GetVolumeCapability().GetMount().GetFsType() != "" {
+		fsType = req.GetVolumeCapability().GetMount().GetFsType()
 	}
 
 	source := req.StagingTargetPath
 	target := req.TargetPath
 
-	err := mountDevice(source, target, fsType, options)
+	err := ns.mountDevice(ctx, source, target, fsType, options)
 	if err != nil {
+		ns.driver.recordWarning(req.GetVolumeContext(), eventReasonMountFailed, "Volume %s could not be mounted into the pod: %v", req.GetVolumeId(), err)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Error %s, mounting the volume from staging dir to target dir", err.Error()))
 	}
 
@@ -152,8 +319,6 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 }
 
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	klog.Infof("NodeUnPublishVolume: called with args %+v", protosanitizer.StripSecrets(*req))
-
 	volumeID := req.GetVolumeId()
 	targetPath := req.GetTargetPath()
 	if len(targetPath) == 0 {
@@ -172,14 +337,14 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 }
 
 func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	nodeID, err := kubernetes.GetNodeLabel(hyperstackInstanceIdLabelKey)
+	nodeID, err := ns.driver.getNodeLabel(ctx, hyperstackInstanceIdLabelKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get node UUID: %v", err)
 	}
-	klog.Infof("NodeGetInfo called with nodeID: %#v\n", nodeID)
+	klog.FromContext(ctx).V(4).Info("Got node ID", "nodeID", nodeID)
 	return &csi.NodeGetInfoResponse{
 		NodeId:            nodeID,
-		MaxVolumesPerNode: 10,
+		MaxVolumesPerNode: ns.maxVolumesPerNode(),
 		AccessibleTopology: &csi.Topology{
 			Segments: map[string]string{
 				"hyperstack.cloud/instance-id": nodeID,
@@ -188,20 +353,78 @@ func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 	}, nil
 }
 
+// maxVolumesPerNode returns the number of volumes the scheduler may place on the node
+func (ns *nodeServer) maxVolumesPerNode() int64 {
+	if ns.driver.opts.MaxVolumesPerNode > 0 {
+		return ns.driver.opts.MaxVolumesPerNode
+	}
+	return defaultMaxVolumesPerNode
+}
+
 func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	klog.Infof("==============NodeGetCapabilities: called================\n")
-	klog.Infof("NodeGetCapabilities: called with args %+v", protosanitizer.StripSecrets(*req))
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: ns.driver.nscap,
 	}, nil
 }
 
 func (ns *nodeServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	klog.Infof("NodeGetVolumeStats: called with args %+v", protosanitizer.StripSecrets(*req))
-	return &csi.NodeGetVolumeStatsResponse{}, nil
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats: Volume ID must be provided")
+	}
+	volumePath := req.GetVolumePath()
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats: Volume Path must be provided")
+	}
+	if _, err := os.Stat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "NodeGetVolumeStats: Volume Path %s not found", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "NodeGetVolumeStats: failed to stat %s: %v", volumePath, err)
+	}
+
+	stats, err := ns.mount.GetDeviceStats(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "NodeGetVolumeStats: failed to get stats of %s: %v", volumePath, err)
+	}
+	if stats.Block {
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{Total: stats.TotalBytes, Unit: csi.VolumeUsage_BYTES},
+			},
+		}, nil
+	}
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{Total: stats.TotalBytes, Available: stats.AvailableBytes, Used: stats.UsedBytes, Unit: csi.VolumeUsage_BYTES},
+			{Total: stats.TotalInodes, Available: stats.AvailableInodes, Used: stats.UsedInodes, Unit: csi.VolumeUsage_INODES},
+		},
+	}, nil
 }
 
 func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	klog.Infof("NodeExpandVolume: called with args %+v", protosanitizer.StripSecrets(*req))
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume: volumeID must be provided")
+	}
+
+	mapperName := luks.MapperName(volumeID)
+	open, err := ns.luks.IsOpen(mapperName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "NodeExpandVolume: failed to check LUKS device %s: %v", mapperName, err)
+	}
+	if open {
+		if err := ns.luks.Resize(mapperName, req.GetSecrets()[encryptionPassphraseKey]); err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeExpandVolume: %v", err)
+		}
+		if ns.driver.opts.DryRun {
+			klog.FromContext(ctx).Info("Dry run: skipping mutating call", "action", "ResizeFs", "device", luks.MapperPath(mapperName), "path", req.GetVolumePath())
+			return &csi.NodeExpandVolumeResponse{}, nil
+		}
+		resizer := mountutils.NewResizeFs(ns.mount.Mounter().Exec)
+		if _, err := resizer.Resize(luks.MapperPath(mapperName), req.GetVolumePath()); err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeExpandVolume: failed to resize filesystem on %s: %v", mapperName, err)
+		}
+	}
+
 	return &csi.NodeExpandVolumeResponse{}, nil
 }
@@ -0,0 +1,198 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+	mountutils "k8s.io/mount-utils"
+
+	"k8s.io/csi-hyperstack/pkg/metrics"
+	"k8s.io/csi-hyperstack/pkg/utils/luks"
+)
+
+const (
+	staleMountCorrupted = "corrupted"
+	staleMountOrphaned  = "orphaned"
+
+	staleMountCleaned = "cleaned"
+	staleMountFailed  = "failed"
+)
+
+// csiVolumeData is the subset of kubelet's vol_data.json needed to tell which driver owns a publish path
+type csiVolumeData struct {
+	DriverName string `json:"driverName"`
+}
+
+// runMountReconciler cleans up stale mounts once on startup and then every interval until ctx is done.
+// A zero interval only runs the startup pass.
+func (ns *nodeServer) runMountReconciler(ctx context.Context, kubeletDir string, interval time.Duration) {
+	ns.reconcileStaleMounts(kubeletDir)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ns.reconcileStaleMounts(kubeletDir)
+		}
+	}
+}
+
+// reconcileStaleMounts unmounts staging and publish paths of this driver whose mount is corrupted
+// or whose backing device is gone, e.g. after the volume was detached while the node was down.
+// The LUKS devices of unmounted paths are closed once nothing mounts them anymore.
+func (ns *nodeServer) reconcileStaleMounts(kubeletDir string) {
+	mounts, err := ns.listMounts()
+	if err != nil {
+		klog.ErrorS(err, "Failed to list mount points")
+		return
+	}
+
+	staleMappers := map[string]bool{}
+	for _, path := range ns.driverMountPaths(kubeletDir) {
+		reason := ns.staleMountReason(path, mounts)
+		if reason == "" {
+			continue
+		}
+
+		klog.InfoS("Cleaning up stale mount", "path", path, "reason", reason)
+		if err := ns.mount.UnmountPath(path); err != nil {
+			klog.ErrorS(err, "Failed to clean up stale mount", "path", path, "reason", reason)
+			metrics.ObserveStaleMount(reason, staleMountFailed)
+			continue
+		}
+		klog.InfoS("Cleaned up stale mount", "path", path, "reason", reason)
+		metrics.ObserveStaleMount(reason, staleMountCleaned)
+		if name, ok := luks.ParseMapperPath(mounts[path].Device); ok {
+			staleMappers[name] = true
+		}
+	}
+	if len(staleMappers) > 0 {
+		ns.closeStaleMappers(staleMappers)
+	}
+}
+
+// closeStaleMappers closes the LUKS devices that are no longer mounted anywhere, so that the next
+// NodeStageVolume opens the volume's disk again instead of reusing a mapping of a detached one
+func (ns *nodeServer) closeStaleMappers(names map[string]bool) {
+	mounts, err := ns.listMounts()
+	if err != nil {
+		klog.ErrorS(err, "Failed to list mount points")
+		return
+	}
+	for _, mp := range mounts {
+		if name, ok := luks.ParseMapperPath(mp.Device); ok {
+			delete(names, name)
+		}
+	}
+	for name := range names {
+		open, err := ns.luks.IsOpen(name)
+		if err != nil {
+			klog.ErrorS(err, "Failed to check LUKS device", "mapper", name)
+			continue
+		}
+		if !open {
+			continue
+		}
+		if err := ns.luks.Close(name); err != nil {
+			klog.ErrorS(err, "Failed to close stale LUKS device", "mapper", name)
+			continue
+		}
+		klog.InfoS("Closed stale LUKS device", "mapper", name)
+	}
+}
+
+// listMounts returns the mount points of the node by path
+func (ns *nodeServer) listMounts() (map[string]mountutils.MountPoint, error) {
+	mountPoints, err := ns.mount.Mounter().List()
+	if err != nil {
+		return nil, err
+	}
+	mounts := make(map[string]mountutils.MountPoint, len(mountPoints))
+	for _, mp := range mountPoints {
+		mounts[mp.Path] = mp
+	}
+	return mounts, nil
+}
+
+// driverMountPaths returns the staging and publish paths kubelet created for this driver
+func (ns *nodeServer) driverMountPaths(kubeletDir string) []string {
+	stagingPaths, err := filepath.Glob(filepath.Join(kubeletDir, "plugins", "kubernetes.io", "csi", ns.driver.name, "*", "globalmount"))
+	if err != nil {
+		klog.ErrorS(err, "Failed to list staging paths")
+	}
+
+	publishPaths, err := filepath.Glob(filepath.Join(kubeletDir, "pods", "*", "volumes", "kubernetes.io~csi", "*", "mount"))
+	if err != nil {
+		klog.ErrorS(err, "Failed to list publish paths")
+	}
+
+	paths := stagingPaths
+	for _, path := range publishPaths {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), "vol_data.json"))
+		if err != nil {
+			klog.V(4).InfoS("Skipping publish path", "path", path, "err", err)
+			continue
+		}
+		var volData csiVolumeData
+		if err := json.Unmarshal(data, &volData); err != nil {
+			klog.V(4).InfoS("Skipping publish path", "path", path, "err", err)
+			continue
+		}
+		if volData.DriverName == ns.driver.name {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// staleMountReason returns why the mount at path is stale, or an empty string if it is healthy or not mounted
+func (ns *nodeServer) staleMountReason(path string, mounts map[string]mountutils.MountPoint) string {
+	if _, err := os.Stat(path); err != nil && mountutils.IsCorruptedMnt(err) {
+		return staleMountCorrupted
+	}
+
+	mp, ok := mounts[path]
+	if !ok {
+		return ""
+	}
+	device := mp.Device
+	if name, ok := luks.ParseMapperPath(device); ok {
+		// A LUKS mapper device outlives the disk it was opened from, so check the disk instead
+		open, err := ns.luks.IsOpen(name)
+		if err != nil {
+			klog.ErrorS(err, "Failed to check LUKS device", "mapper", name)
+			return ""
+		}
+		if !open {
+			return staleMountOrphaned
+		}
+		device, err = ns.luks.BackingDevice(name)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get the backing device of LUKS device", "mapper", name)
+			return ""
+		}
+		// cryptsetup reports no device path once the disk is gone
+		if !filepath.IsAbs(device) {
+			return staleMountOrphaned
+		}
+	}
+
+	if !strings.HasPrefix(device, "/dev/") {
+		return ""
+	}
+	if _, err := os.Stat(device); os.IsNotExist(err) {
+		return staleMountOrphaned
+	}
+	return ""
+}
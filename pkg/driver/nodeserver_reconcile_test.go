@@ -0,0 +1,172 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	mountutils "k8s.io/mount-utils"
+
+	"k8s.io/csi-hyperstack/pkg/utils/luks"
+)
+
+const (
+	// testPresentDevice exists on every Linux host, testMissingDevice on none
+	testPresentDevice = "/dev/null"
+	testMissingDevice = "/dev/hyperstack-test-missing"
+)
+
+// stagingPath creates the staging path kubelet uses for a volume of the driver
+func stagingPath(t *testing.T, kubeletDir string, driverName string, volumeHash string) string {
+	t.Helper()
+	path := filepath.Join(kubeletDir, "plugins", "kubernetes.io", "csi", driverName, volumeHash, "globalmount")
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// publishPath creates the publish path kubelet uses for a volume of a pod, with volData as its vol_data.json
+func publishPath(t *testing.T, kubeletDir string, podUID string, pvName string, volData string) string {
+	t.Helper()
+	path := filepath.Join(kubeletDir, "pods", podUID, "volumes", "kubernetes.io~csi", pvName, "mount")
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if volData != "" {
+		if err := os.WriteFile(filepath.Join(filepath.Dir(path), "vol_data.json"), []byte(volData), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func volData(driverName string) string {
+	return `{"driverName":"` + driverName + `","volumeHandle":"4711"}`
+}
+
+func TestDriverMountPaths(t *testing.T) {
+	ns, _, _, _ := newTestNode(t)
+	kubeletDir := t.TempDir()
+
+	expected := []string{
+		stagingPath(t, kubeletDir, DriverName, "a1b2"),
+		publishPath(t, kubeletDir, "pod-1", "pvc-1", volData(DriverName)),
+	}
+	stagingPath(t, kubeletDir, "ebs.csi.aws.com", "c3d4")
+	publishPath(t, kubeletDir, "pod-2", "pvc-2", volData("ebs.csi.aws.com"))
+	publishPath(t, kubeletDir, "pod-3", "pvc-3", "")
+	publishPath(t, kubeletDir, "pod-4", "pvc-4", "{")
+
+	paths := ns.driverMountPaths(kubeletDir)
+	sort.Strings(paths)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("driverMountPaths() = %v, expected %v", paths, expected)
+	}
+}
+
+func TestStaleMountReason(t *testing.T) {
+	mapperName := luks.MapperName(testVolumeID)
+
+	testCases := []struct {
+		name   string
+		device string
+		// backingDevice is the device the LUKS mapper was opened from, if it is open
+		backingDevice string
+		reason        string
+	}{
+		{name: "not_mounted"},
+		{name: "healthy", device: testPresentDevice},
+		{name: "device_gone", device: testMissingDevice, reason: staleMountOrphaned},
+		{name: "not_a_device", device: "tmpfs"},
+		{name: "luks_healthy", device: luks.MapperPath(mapperName), backingDevice: testPresentDevice},
+		{name: "luks_backing_device_gone", device: luks.MapperPath(mapperName), backingDevice: testMissingDevice, reason: staleMountOrphaned},
+		{name: "luks_backing_device_unknown", device: luks.MapperPath(mapperName), backingDevice: "(null)", reason: staleMountOrphaned},
+		{name: "luks_closed", device: luks.MapperPath(mapperName), reason: staleMountOrphaned},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ns, _, l, _ := newTestNode(t)
+			if tc.backingDevice != "" {
+				l.open[mapperName] = tc.backingDevice
+			}
+			path := t.TempDir()
+			mounts := map[string]mountutils.MountPoint{}
+			if tc.device != "" {
+				mounts[path] = mountutils.MountPoint{Device: tc.device, Path: path, Type: "ext4"}
+			}
+
+			if reason := ns.staleMountReason(path, mounts); reason != tc.reason {
+				t.Errorf("staleMountReason() = %q, expected %q", reason, tc.reason)
+			}
+		})
+	}
+}
+
+func TestReconcileStaleMounts(t *testing.T) {
+	ns, _, l, _ := newTestNode(t)
+	kubeletDir := t.TempDir()
+	mapperName := luks.MapperName(testVolumeID)
+	l.open[mapperName] = testMissingDevice
+
+	healthy := stagingPath(t, kubeletDir, DriverName, "a1b2")
+	orphaned := stagingPath(t, kubeletDir, DriverName, "c3d4")
+	orphanedEncrypted := publishPath(t, kubeletDir, "pod-1", "pvc-1", volData(DriverName))
+	otherDriver := publishPath(t, kubeletDir, "pod-2", "pvc-2", volData("ebs.csi.aws.com"))
+
+	mounter := ns.mount.Mounter().Interface
+	for path, device := range map[string]string{
+		healthy:           testPresentDevice,
+		orphaned:          testMissingDevice,
+		orphanedEncrypted: luks.MapperPath(mapperName),
+		otherDriver:       testMissingDevice,
+	} {
+		if err := mounter.Mount(device, path, "ext4", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ns.reconcileStaleMounts(kubeletDir)
+
+	for path, mounted := range map[string]bool{
+		healthy:           true,
+		orphaned:          false,
+		orphanedEncrypted: false,
+		otherDriver:       true,
+	} {
+		if source := mountedSource(t, ns, path); (source != "") != mounted {
+			t.Errorf("%s mounted from %q, expected mounted = %v", path, source, mounted)
+		}
+	}
+	if open, _ := l.IsOpen(mapperName); open {
+		t.Error("LUKS device of the orphaned mount still open after reconcile")
+	}
+}
+
+func TestReconcileStaleMountsKeepsMountedLuksDevice(t *testing.T) {
+	ns, _, l, _ := newTestNode(t)
+	kubeletDir := t.TempDir()
+	mapperName := luks.MapperName(testVolumeID)
+	l.open[mapperName] = testMissingDevice
+
+	orphaned := stagingPath(t, kubeletDir, DriverName, "a1b2")
+	mounter := ns.mount.Mounter().Interface
+	for _, path := range []string{orphaned, t.TempDir()} {
+		if err := mounter.Mount(luks.MapperPath(mapperName), path, "ext4", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ns.reconcileStaleMounts(kubeletDir)
+
+	if source := mountedSource(t, ns, orphaned); source != "" {
+		t.Errorf("%s still mounted from %s", orphaned, source)
+	}
+	if open, _ := l.IsOpen(mapperName); !open {
+		t.Error("LUKS device closed while it is still mounted outside the driver's paths")
+	}
+}
@@ -0,0 +1,358 @@
+package driver
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	"k8s.io/csi-hyperstack/pkg/utils/luks"
+	"k8s.io/csi-hyperstack/pkg/utils/mount"
+)
+
+const (
+	testDevice     = "/dev/vdb"
+	testVolumeID   = "4711"
+	testPassphrase = "correct horse battery staple"
+)
+
+// fakeDisks is an exec.Interface that answers blkid from an in-memory table of disk formats
+// and records the devices mkfs ran on
+type fakeDisks struct {
+	formats map[string]string
+	mkfs    []string
+}
+
+var _ utilexec.Interface = &fakeDisks{}
+
+func newFakeDisks() *fakeDisks {
+	return &fakeDisks{formats: map[string]string{}}
+}
+
+func (f *fakeDisks) Command(cmd string, args ...string) utilexec.Cmd {
+	fakeCmd := &testingexec.FakeCmd{
+		CombinedOutputScript: []testingexec.FakeAction{func() ([]byte, []byte, error) {
+			return f.run(cmd, args)
+		}},
+	}
+	return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+}
+
+func (f *fakeDisks) CommandContext(_ context.Context, cmd string, args ...string) utilexec.Cmd {
+	return f.Command(cmd, args...)
+}
+
+func (f *fakeDisks) LookPath(file string) (string, error) {
+	return file, nil
+}
+
+func (f *fakeDisks) run(cmd string, args []string) ([]byte, []byte, error) {
+	device := args[len(args)-1]
+	switch {
+	case cmd == "blkid":
+		format := f.formats[device]
+		if format == "" {
+			// blkid exits with 2 for a device without a known format
+			return nil, nil, &testingexec.FakeExitError{Status: 2}
+		}
+		return []byte("DEVNAME=" + device + "\nTYPE=" + format + "\n"), nil, nil
+	case strings.HasPrefix(cmd, "mkfs."):
+		f.mkfs = append(f.mkfs, device)
+		f.formats[device] = strings.TrimPrefix(cmd, "mkfs.")
+		return nil, nil, nil
+	}
+	return nil, nil, nil
+}
+
+// fakeLuks keeps LUKS headers in the disk table of a fakeDisks, so that blkid reports them
+type fakeLuks struct {
+	disks     *fakeDisks
+	open      map[string]string
+	formatted []string
+}
+
+var _ luks.ILuks = &fakeLuks{}
+
+func newFakeLuks(disks *fakeDisks) *fakeLuks {
+	return &fakeLuks{disks: disks, open: map[string]string{}}
+}
+
+func (l *fakeLuks) IsLuks(device string) (bool, error) {
+	return l.disks.formats[device] == luksDiskFormat, nil
+}
+
+func (l *fakeLuks) Format(device string, passphrase string) error {
+	if format := l.disks.formats[device]; format != "" {
+		return fmt.Errorf("refusing to format %s with LUKS, it holds %s: %w", device, format, luks.ErrDeviceHasData)
+	}
+	l.formatted = append(l.formatted, device)
+	l.disks.formats[device] = luksDiskFormat
+	return nil
+}
+
+func (l *fakeLuks) Open(device string, name string, passphrase string) error {
+	l.open[name] = device
+	return nil
+}
+
+func (l *fakeLuks) IsOpen(name string) (bool, error) {
+	_, ok := l.open[name]
+	return ok, nil
+}
+
+func (l *fakeLuks) BackingDevice(name string) (string, error) {
+	return l.open[name], nil
+}
+
+func (l *fakeLuks) Close(name string) error {
+	delete(l.open, name)
+	return nil
+}
+
+func (l *fakeLuks) Resize(name string, passphrase string) error {
+	return nil
+}
+
+// newTestNode returns a node server with fake disk, LUKS and mount backends
+func newTestNode(t *testing.T) (*nodeServer, *fakeDisks, *fakeLuks, *record.FakeRecorder) {
+	t.Helper()
+	recorder := record.NewFakeRecorder(10)
+	d, err := NewDriver(&DriverOpts{
+		HyperstackClient: fake.NewHyperstack(),
+		NodeName:         testNodeName,
+		EventRecorder:    recorder,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disks := newFakeDisks()
+	l := newFakeLuks(disks)
+	ns := &nodeServer{
+		driver: d,
+		mount: &mount.Mount{BaseMounter: &mountutils.SafeFormatAndMount{
+			Interface: mount.NewFakeMounter(),
+			Exec:      disks,
+		}},
+		luks: l,
+	}
+	return ns, disks, l, recorder
+}
+
+func stageVolumeRequest(stagingPath string, volumeContext map[string]string, secrets map[string]string) *csi.NodeStageVolumeRequest {
+	return &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		PublishContext:    map[string]string{volNameKeyFromControllerPublishVolume: testDevice},
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  mountVolumeCapability(),
+		VolumeContext:     volumeContext,
+		Secrets:           secrets,
+	}
+}
+
+// mountedSource returns the device mounted at target, or an empty string
+func mountedSource(t *testing.T, ns *nodeServer, target string) string {
+	t.Helper()
+	mounts, err := ns.mount.Mounter().List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mp := range mounts {
+		if mp.Path == target {
+			return mp.Device
+		}
+	}
+	return ""
+}
+
+func TestNodeStageVolumeEncrypted(t *testing.T) {
+	encrypted := map[string]string{volumeContextEncryptedKey: "true", pvcNameKey: "data-web-0", pvcNamespaceKey: "shop"}
+	passphrase := map[string]string{encryptionPassphraseKey: testPassphrase}
+	mapperPath := luks.MapperPath(luks.MapperName(testVolumeID))
+
+	testCases := []struct {
+		name string
+		// deviceFormat and mapperFormat are what blkid reports before the call
+		deviceFormat string
+		mapperFormat string
+		// openedFrom is the device the LUKS device is already open from
+		openedFrom string
+		secrets    map[string]string
+		code       codes.Code
+		luksFormat bool
+		mkfs       bool
+		reason     string
+	}{
+		{
+			name:       "first_stage",
+			secrets:    passphrase,
+			luksFormat: true,
+			mkfs:       true,
+		},
+		{
+			name:         "restage",
+			deviceFormat: luksDiskFormat,
+			mapperFormat: "ext4",
+			secrets:      passphrase,
+		},
+		{
+			name:         "restage_already_open",
+			deviceFormat: luksDiskFormat,
+			mapperFormat: "ext4",
+			openedFrom:   testDevice,
+			secrets:      passphrase,
+		},
+		{
+			name:         "restage_open_from_detached_disk",
+			deviceFormat: luksDiskFormat,
+			mapperFormat: "ext4",
+			openedFrom:   "/dev/vdz",
+			secrets:      passphrase,
+		},
+		{
+			name:         "refuse_existing_filesystem",
+			deviceFormat: "ext4",
+			secrets:      passphrase,
+			code:         codes.FailedPrecondition,
+			reason:       eventReasonFormatRefused,
+		},
+		{
+			name: "missing_passphrase",
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ns, disks, l, recorder := newTestNode(t)
+			disks.formats[testDevice] = tc.deviceFormat
+			disks.formats[mapperPath] = tc.mapperFormat
+			if tc.openedFrom != "" {
+				l.open[luks.MapperName(testVolumeID)] = tc.openedFrom
+			}
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+
+			_, err := ns.NodeStageVolume(context.Background(), stageVolumeRequest(stagingPath, encrypted, tc.secrets))
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("NodeStageVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			if formatted := len(l.formatted) > 0; formatted != tc.luksFormat {
+				t.Errorf("luksFormat ran = %v, expected %v", formatted, tc.luksFormat)
+			}
+			if ran := len(disks.mkfs) > 0; ran != tc.mkfs {
+				t.Errorf("mkfs ran on %v, expected %v", disks.mkfs, tc.mkfs)
+			}
+			if tc.mkfs && disks.mkfs[0] != mapperPath {
+				t.Errorf("mkfs ran on %s, expected the LUKS device %s", disks.mkfs[0], mapperPath)
+			}
+			expectEvent(t, recorder, tc.reason)
+			if tc.code != codes.OK {
+				if disks.formats[testDevice] != tc.deviceFormat {
+					t.Errorf("device format = %q after a failed stage, expected %q", disks.formats[testDevice], tc.deviceFormat)
+				}
+				return
+			}
+			if source := mountedSource(t, ns, stagingPath); source != mapperPath {
+				t.Errorf("staging path mounted from %q, expected %s", source, mapperPath)
+			}
+			if openedFrom := l.open[luks.MapperName(testVolumeID)]; openedFrom != testDevice {
+				t.Errorf("LUKS device open from %q, expected %s", openedFrom, testDevice)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolume(t *testing.T) {
+	volumeContext := map[string]string{pvcNameKey: "data-web-0", pvcNamespaceKey: "shop"}
+
+	testCases := []struct {
+		name string
+		// deviceFormat is what blkid reports before the call
+		deviceFormat string
+		code         codes.Code
+		mkfs         bool
+		reason       string
+	}{
+		{
+			name: "first_stage",
+			mkfs: true,
+		},
+		{
+			name:         "restage",
+			deviceFormat: "ext4",
+		},
+		{
+			name:         "refuse_luks_header",
+			deviceFormat: luksDiskFormat,
+			code:         codes.FailedPrecondition,
+			reason:       eventReasonFormatRefused,
+		},
+		{
+			name:         "refuse_other_filesystem",
+			deviceFormat: "xfs",
+			code:         codes.FailedPrecondition,
+			reason:       eventReasonFormatRefused,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ns, disks, _, recorder := newTestNode(t)
+			disks.formats[testDevice] = tc.deviceFormat
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+
+			_, err := ns.NodeStageVolume(context.Background(), stageVolumeRequest(stagingPath, volumeContext, nil))
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("NodeStageVolume() code = %v, expected %v: %v", code, tc.code, err)
+			}
+			if ran := len(disks.mkfs) > 0; ran != tc.mkfs {
+				t.Errorf("mkfs ran on %v, expected %v", disks.mkfs, tc.mkfs)
+			}
+			expectEvent(t, recorder, tc.reason)
+			if tc.code != codes.OK {
+				if disks.formats[testDevice] != tc.deviceFormat {
+					t.Errorf("device format = %q after a failed stage, expected %q", disks.formats[testDevice], tc.deviceFormat)
+				}
+				return
+			}
+			if source := mountedSource(t, ns, stagingPath); source != testDevice {
+				t.Errorf("staging path mounted from %q, expected %s", source, testDevice)
+			}
+		})
+	}
+}
+
+func TestNodeUnstageVolumeEncrypted(t *testing.T) {
+	ns, _, l, _ := newTestNode(t)
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	req := stageVolumeRequest(stagingPath, map[string]string{volumeContextEncryptedKey: "true"}, map[string]string{encryptionPassphraseKey: testPassphrase})
+	if _, err := ns.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: stagingPath})
+	if err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if source := mountedSource(t, ns, stagingPath); source != "" {
+		t.Errorf("staging path still mounted from %s", source)
+	}
+	if open, _ := l.IsOpen(luks.MapperName(testVolumeID)); open {
+		t.Error("LUKS device still open after unstage")
+	}
+
+	// Unstaging again finds nothing to unmount or close
+	_, err = ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: stagingPath})
+	if err != nil {
+		t.Fatalf("second NodeUnstageVolume() error = %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+//go:build sanity
+
+// The CSI sanity suite is not part of the default test run because it runs the whole
+// csi-test suite against the driver. Run it with:
+//
+//	make test-sanity
+
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	"k8s.io/csi-hyperstack/pkg/utils/mount"
+)
+
+// sanitySkips lists the sanity specs the driver does not pass yet, each with the reason
+var sanitySkips = []string{
+	// NodeExpandVolume only resizes encrypted volumes
+	`NodeExpandVolume`,
+	// Publishing does not check node existence, attach limits or existing attachments to other nodes
+	`ControllerPublishVolume.*should fail when the node is missing`,
+	`ControllerPublishVolume.*should fail when publishing more volumes than the node max attach limit`,
+	`ControllerPublishVolume.*should fail when the volume is already published to another node`,
+}
+
+func TestSanity(t *testing.T) {
+	// The name and version are set with -ldflags in release builds
+	if DriverName == "" {
+		DriverName, DriverVersion = "hyperstack.csi.nexgencloud.com", "sanity"
+		t.Cleanup(func() { DriverName, DriverVersion = "", "" })
+	}
+
+	tmp := t.TempDir()
+	socket := filepath.Join(tmp, "csi.sock")
+
+	hs := fake.NewHyperstack()
+	hs.AddCluster(testClusterID, "test-cluster", "CANADA-1")
+	kubeClient := k8sfake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testNodeName,
+			Labels: map[string]string{
+				hyperstackClusterIdLabelKey:  strconv.Itoa(testClusterID),
+				hyperstackInstanceIdLabelKey: strconv.Itoa(testVMID),
+			},
+		},
+	})
+
+	d, err := NewDriver(&DriverOpts{
+		Endpoint:         "unix://" + strings.TrimPrefix(socket, "/"),
+		HyperstackClient: hs,
+		KubeClient:       kubeClient,
+		NodeName:         testNodeName,
+		Mounter:          mount.NewFakeMount(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetupIdentityService()
+	d.SetupControllerService()
+	d.SetupNodeService()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, err := RunGRPCServer(ctx, make(chan error, 1), d.opts.Endpoint, d.opts.GRPC, d.serviceIdentity, d.serviceController, d.serviceNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	config := sanity.NewTestConfig()
+	config.Address = socket
+	config.TargetPath = filepath.Join(tmp, "target")
+	config.StagingPath = filepath.Join(tmp, "staging")
+	config.TestVolumeSize = 1024 * 1024 * 1024
+
+	sc := sanity.GinkgoTest(&config)
+	suiteConfig, reporterConfig := ginkgo.GinkgoConfiguration()
+	suiteConfig.SkipStrings = append(suiteConfig.SkipStrings, sanitySkips...)
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Hyperstack CSI Driver Sanity Suite", suiteConfig, reporterConfig)
+	sc.Finalize()
+}
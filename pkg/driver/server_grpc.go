@@ -5,19 +5,45 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"sync"
+	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 	"k8s.io/klog/v2"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
 
+// GRPCServerOpts configures the CSI gRPC server
+type GRPCServerOpts struct {
+	// TLSCertFile and TLSKeyFile enable TLS on a tcp:// endpoint. They are reloaded when they change.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile requires clients to present a certificate signed by one of its CAs
+	TLSClientCAFile string
+	// DisableReflection turns off the gRPC reflection service used by tools like grpcurl
+	DisableReflection bool
+	// KeepaliveTime is the idle time after which the server pings a client, 0 keeps the gRPC default of 2h
+	KeepaliveTime time.Duration
+	// KeepaliveTimeout is how long the server waits for a ping ack before closing the connection
+	KeepaliveTimeout time.Duration
+	// MaxConcurrentStreams limits the concurrent calls per connection, 0 means no limit
+	MaxConcurrentStreams uint32
+}
+
+// RunGRPCServer serves the CSI services and the grpc.health.v1 service on endpoint. The health
+// service reports NOT_SERVING once ctx is done. An error stopping the server is sent to errs,
+// a stop through StopGRPCServer sends nothing.
 func RunGRPCServer(
 	ctx context.Context,
-	wg *sync.WaitGroup,
+	errs chan<- error,
 	endpoint string,
+	serverOpts GRPCServerOpts,
 	ids csi.IdentityServer,
 	cs csi.ControllerServer,
 	ns csi.NodeServer,
@@ -35,19 +61,52 @@ func RunGRPCServer(
 		}
 	}
 
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(metricsGRPC, logGRPC, recoverGRPC),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}
+
+	tlsConfig, err := newGRPCTLSConfig(serverOpts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tlsConfig != nil && proto != "tcp":
+		return nil, fmt.Errorf("TLS is only supported on tcp endpoints, not %s", endpoint)
+	case tlsConfig != nil:
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	case proto == "tcp":
+		klog.Warningf("gRPC endpoint %s is served without TLS, set the TLS certificate and key to encrypt it", endpoint)
+	}
+
+	if serverOpts.KeepaliveTime > 0 || serverOpts.KeepaliveTimeout > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    serverOpts.KeepaliveTime,
+			Timeout: serverOpts.KeepaliveTimeout,
+		}))
+	}
+	if serverOpts.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(serverOpts.MaxConcurrentStreams))
+	}
+
 	listenConf := net.ListenConfig{}
 	listener, err := listenConf.Listen(ctx, proto, addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen: %w", err)
 	}
 
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
-	}
 	server := grpc.NewServer(opts...)
 
-	// Register reflection service on gRPC server
-	reflection.Register(server)
+	if !serverOpts.DisableReflection {
+		reflection.Register(server)
+	}
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go func() {
+		<-ctx.Done()
+		healthServer.Shutdown()
+	}()
 
 	if ids != nil {
 		csi.RegisterIdentityServer(server, ids)
@@ -58,14 +117,44 @@ func RunGRPCServer(
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
+	for service := range server.GetServiceInfo() {
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
 
 	go func() {
-		defer wg.Done()
 		klog.Infof("gRPC listening on address: %s", listener.Addr().String())
 		if err := server.Serve(listener); err != nil && err != grpc.ErrServerStopped {
-			klog.Errorf("Server stopped with: %v", err)
+			errs <- fmt.Errorf("gRPC server stopped: %w", err)
 		}
 	}()
 
 	return server, nil
 }
+
+// StopGRPCServer stops accepting calls and waits for the in-flight calls to finish. Calls still running
+// after drainTimeout are cancelled, so that e.g. a mkfs is killed rather than left behind. The unix
+// socket of endpoint is removed last.
+func StopGRPCServer(server *grpc.Server, endpoint string, drainTimeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		klog.Info("gRPC server stopped, all calls completed")
+	case <-time.After(drainTimeout):
+		klog.Warningf("gRPC calls still running after %s, cancelling them", drainTimeout)
+		server.Stop()
+		<-stopped
+	}
+
+	proto, addr, err := ParseEndpoint(endpoint)
+	if err != nil || proto != "unix" {
+		return
+	}
+	if err := os.Remove("/" + addr); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("Failed to remove socket %s: %v", "/"+addr, err)
+	}
+}
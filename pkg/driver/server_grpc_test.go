@@ -0,0 +1,287 @@
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// slowIdentityServer answers Probe after delay, or when the call is cancelled
+type slowIdentityServer struct {
+	csi.UnimplementedIdentityServer
+	delay     time.Duration
+	started   chan struct{}
+	cancelled chan bool
+}
+
+func (s *slowIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	close(s.started)
+	select {
+	case <-time.After(s.delay):
+		s.cancelled <- false
+		return &csi.ProbeResponse{}, nil
+	case <-ctx.Done():
+		s.cancelled <- true
+		return nil, ctx.Err()
+	}
+}
+
+func TestStopGRPCServer(t *testing.T) {
+	testCases := []struct {
+		name         string
+		delay        time.Duration
+		drainTimeout time.Duration
+		cancelled    bool
+	}{
+		{name: "drained", delay: 100 * time.Millisecond, drainTimeout: 10 * time.Second},
+		{name: "cancelled_after_timeout", delay: time.Minute, drainTimeout: 100 * time.Millisecond, cancelled: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			socket := filepath.Join(t.TempDir(), "csi.sock")
+			endpoint := "unix://" + socket[1:]
+			ids := &slowIdentityServer{delay: tc.delay, started: make(chan struct{}), cancelled: make(chan bool, 1)}
+
+			errs := make(chan error, 1)
+			srv, err := RunGRPCServer(context.Background(), errs, endpoint, GRPCServerOpts{}, ids, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			probeErr := make(chan error, 1)
+			go func() {
+				_, err := csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{})
+				probeErr <- err
+			}()
+			<-ids.started
+
+			StopGRPCServer(srv, endpoint, tc.drainTimeout)
+
+			if cancelled := <-ids.cancelled; cancelled != tc.cancelled {
+				t.Errorf("call cancelled = %v, expected %v", cancelled, tc.cancelled)
+			}
+			err = <-probeErr
+			if tc.cancelled && status.Code(err) == codes.OK {
+				t.Error("expected the in-flight call to fail")
+			}
+			if !tc.cancelled && err != nil {
+				t.Errorf("expected the in-flight call to complete, got %v", err)
+			}
+			if _, err := os.Stat(socket); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be removed, got %v", socket, err)
+			}
+			select {
+			case err := <-errs:
+				t.Errorf("unexpected server error: %v", err)
+			default:
+			}
+		})
+	}
+}
+
+// panickingIdentityServer panics in GetPluginInfo like a nil dereference in a handler would
+type panickingIdentityServer struct {
+	csi.UnimplementedIdentityServer
+}
+
+func (s *panickingIdentityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	var info *csi.GetPluginInfoResponse
+	return &csi.GetPluginInfoResponse{Name: info.Name}, nil
+}
+
+func (s *panickingIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{}, nil
+}
+
+// writeTestCert writes a certificate and key signed by parent, or a self-signed CA if parent is nil,
+// to dir and returns the certificate
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// freeTCPEndpoint returns a tcp:// endpoint on a port that is free at the time of the call
+func freeTCPEndpoint(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return "tcp://" + l.Addr().String()
+}
+
+func TestGRPCServerRecovery(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, err := RunGRPCServer(ctx, make(chan error, 1), "unix://"+socket[1:], GRPCServerOpts{DisableReflection: true}, &panickingIdentityServer{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if _, ok := srv.GetServiceInfo()["grpc.reflection.v1.ServerReflection"]; ok {
+		t.Error("expected reflection to be disabled")
+	}
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := csi.NewIdentityClient(conn)
+
+	if _, err := client.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}); status.Code(err) != codes.Internal {
+		t.Errorf("GetPluginInfo() error = %v, expected Internal", err)
+	}
+	// The server keeps serving after the panic
+	if _, err := client.Probe(ctx, &csi.ProbeRequest{}); err != nil {
+		t.Errorf("Probe() after a panic failed: %v", err)
+	}
+
+	health := healthpb.NewHealthClient(conn)
+	resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: "csi.v1.Identity"})
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health Check() = %v, %v, expected SERVING", resp.GetStatus(), err)
+	}
+	cancel()
+	for i := 0; ; i++ {
+		resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_NOT_SERVING {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("health Check() = %v, %v, expected NOT_SERVING once the context is done", resp.GetStatus(), err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGRPCServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "server", ca, caKey)
+	writeTestCert(t, dir, "client", ca, caKey)
+	otherCA, otherCAKey := writeTestCert(t, dir, "other-ca", nil, nil)
+	writeTestCert(t, dir, "other-client", otherCA, otherCAKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert := func(name string) []tls.Certificate {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []tls.Certificate{cert}
+	}
+
+	testCases := []struct {
+		name     string
+		clientCA bool
+		creds    credentials.TransportCredentials
+		wantErr  bool
+	}{
+		{name: "tls", creds: credentials.NewTLS(&tls.Config{RootCAs: roots})},
+		{name: "plaintext_client", creds: insecure.NewCredentials(), wantErr: true},
+		{name: "mtls", clientCA: true, creds: credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: clientCert("client")})},
+		{name: "mtls_without_client_cert", clientCA: true, creds: credentials.NewTLS(&tls.Config{RootCAs: roots}), wantErr: true},
+		{name: "mtls_untrusted_client_cert", clientCA: true, creds: credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: clientCert("other-client")}), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := GRPCServerOpts{
+				TLSCertFile: filepath.Join(dir, "server.crt"),
+				TLSKeyFile:  filepath.Join(dir, "server.key"),
+			}
+			if tc.clientCA {
+				opts.TLSClientCAFile = filepath.Join(dir, "ca.crt")
+			}
+			endpoint := freeTCPEndpoint(t)
+			srv, err := RunGRPCServer(context.Background(), make(chan error, 1), endpoint, opts, &panickingIdentityServer{}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Stop()
+
+			conn, err := grpc.NewClient(endpoint[len("tcp://"):], grpc.WithTransportCredentials(tc.creds))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = csi.NewIdentityClient(conn).Probe(ctx, &csi.ProbeRequest{})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Probe() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	_, err := RunGRPCServer(context.Background(), make(chan error, 1), "unix://"+filepath.Join(dir, "csi.sock")[1:], GRPCServerOpts{
+		TLSCertFile: filepath.Join(dir, "server.crt"),
+		TLSKeyFile:  filepath.Join(dir, "server.key"),
+	}, &panickingIdentityServer{}, nil, nil)
+	if err == nil {
+		t.Error("expected an error for TLS on a unix socket")
+	}
+}
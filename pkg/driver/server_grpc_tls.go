@@ -0,0 +1,119 @@
+package driver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// grpcTLSReloader serves the certificate and client CA bundle of the gRPC server from disk and
+// reloads them when the files change, so that rotated certificates are picked up by new connections
+// without restarting the driver.
+type grpcTLSReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu      sync.Mutex
+	modTime time.Time
+	config  *tls.Config
+}
+
+// newGRPCTLSConfig returns the TLS configuration of the gRPC server, or nil if TLS is not enabled
+func newGRPCTLSConfig(opts GRPCServerOpts) (*tls.Config, error) {
+	if opts.TLSCertFile == "" && opts.TLSKeyFile == "" && opts.TLSClientCAFile == "" {
+		return nil, nil
+	}
+	if opts.TLSCertFile == "" || opts.TLSKeyFile == "" {
+		return nil, fmt.Errorf("gRPC TLS certificate and key must be set together")
+	}
+
+	r := &grpcTLSReloader{
+		certFile:     opts.TLSCertFile,
+		keyFile:      opts.TLSKeyFile,
+		clientCAFile: opts.TLSClientCAFile,
+	}
+	if _, err := r.configForClient(nil); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.configForClient,
+	}, nil
+}
+
+// configForClient returns the current TLS configuration, reloading it if one of the files changed.
+// If a changed file cannot be loaded, the previous configuration is kept.
+func (r *grpcTLSReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime := time.Time{}
+	for _, f := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			if r.config != nil {
+				klog.Errorf("grpcTLSReloader: failed to stat %s, keeping the loaded certificates: %v", f, err)
+				return r.config, nil
+			}
+			return nil, fmt.Errorf("failed to read gRPC TLS file: %w", err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.config != nil && modTime.Equal(r.modTime) {
+		return r.config, nil
+	}
+
+	config, err := r.load()
+	if err != nil {
+		if r.config != nil {
+			klog.Errorf("grpcTLSReloader: keeping the loaded certificates: %v", err)
+			return r.config, nil
+		}
+		return nil, err
+	}
+	if r.config != nil {
+		klog.Infof("grpcTLSReloader: reloaded certificate %s", r.certFile)
+	}
+	r.config = config
+	r.modTime = modTime
+	return r.config, nil
+}
+
+func (r *grpcTLSReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gRPC TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// The config replaces the one set up by grpc, which advertises HTTP/2 through ALPN
+		NextProtos: []string{"h2"},
+	}
+	if r.clientCAFile == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(r.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gRPC client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in gRPC client CA file %s", r.clientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
@@ -7,20 +7,28 @@ import (
 	"k8s.io/klog/v2"
 	"net"
 	"net/http"
-	"sync"
+	"strings"
 )
 
+// RunHttpServer serves metrics, /healthz for liveness and /readyz with the readiness checks of health.
+// An error stopping the server is sent to errs, a Shutdown sends nothing.
 func RunHttpServer(
 	ctx context.Context,
-	wg *sync.WaitGroup,
+	errs chan<- error,
 	endpoint string,
 	metricsEnabled bool,
+	health HealthReporter,
 ) *http.Server {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+	// The process is alive as long as it serves HTTP, unavailable dependencies only affect readiness.
+	// /health is kept for existing probe configurations.
+	healthz := func(w http.ResponseWriter, req *http.Request) {
 		_, _ = fmt.Fprintf(w, "ok")
-	})
+	}
+	mux.HandleFunc("/health", healthz)
+	mux.HandleFunc("/healthz", healthz)
+	mux.Handle("/readyz", readyzHandler(health))
 
 	if metricsEnabled {
 		klog.Infof("metrics available in %s/metrics", endpoint)
@@ -34,13 +42,43 @@ func RunHttpServer(
 	}
 
 	go func() {
-		defer wg.Done()
-
 		klog.Infof("Running server on %q", endpoint)
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			klog.Fatalf("ListenAndServe(): %v", err)
+			errs <- fmt.Errorf("HTTP server stopped: %w", err)
 		}
 	}()
 
 	return srv
 }
+
+// readyzHandler answers 200 if every readiness check passes and 503 otherwise, listing the
+// result of each check in the format of the Kubernetes API server:
+//
+//	[+]hyperstack-api ok
+//	[-]node-id failed: ...
+func readyzHandler(health HealthReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ready, results := true, []HealthResult(nil)
+		if health != nil {
+			ready, results = health.Ready(req.Context())
+		}
+
+		var body strings.Builder
+		for _, r := range results {
+			if r.Err != nil {
+				fmt.Fprintf(&body, "[-]%s failed: %v\n", r.Name, r.Err)
+			} else {
+				fmt.Fprintf(&body, "[+]%s ok\n", r.Name)
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			body.WriteString("readyz check failed\n")
+		} else {
+			body.WriteString("readyz check passed\n")
+		}
+		_, _ = w.Write([]byte(body.String()))
+	})
+}
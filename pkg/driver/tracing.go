@@ -0,0 +1,19 @@
+package driver
+
+import (
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of driver operations that are not HTTP or gRPC calls, e.g. mkfs and mount
+var tracer = otel.Tracer("k8s.io/csi-hyperstack/pkg/driver")
+
+// endSpan marks the span as failed if err is set and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
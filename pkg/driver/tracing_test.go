@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/net/context"
+
+	"k8s.io/csi-hyperstack/pkg/tracing"
+	"k8s.io/csi-hyperstack/pkg/utils/mount"
+)
+
+func TestNodeOperationSpans(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	recorder := tracetest.NewSpanRecorder()
+	tp := tracing.NewProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ns := &nodeServer{driver: &Driver{opts: &DriverOpts{}}, mount: mount.NewFakeMount()}
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "NodeStageVolume")
+	if err := ns.formateAndMakeFS(ctx, "/dev/vdb", "ext4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.mountDevice(ctx, "/dev/vdb", filepath.Join(t.TempDir(), "staging"), "ext4", nil); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"mkfs", "mount"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span recorded", name)
+			continue
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of the CSI call span", name)
+		}
+	}
+}
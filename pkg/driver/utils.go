@@ -2,13 +2,20 @@ package driver
 
 import (
 	"fmt"
+	"path"
+	"runtime/debug"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/csi-hyperstack/pkg/metrics"
 	"k8s.io/klog/v2"
 )
 
@@ -65,6 +72,18 @@ func MapNodeServiceCapabilities(
 	return items
 }
 
+// sleepCtx waits for d, returning the gRPC status of the context error if ctx ends first
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func ParseEndpoint(ep string) (string, string, error) {
 	if strings.HasPrefix(strings.ToLower(ep), "unix://") || strings.HasPrefix(strings.ToLower(ep), "tcp://") {
 		s := strings.SplitN(ep, "://", 2)
@@ -75,16 +94,46 @@ func ParseEndpoint(ep string) (string, string, error) {
 	return "", "", fmt.Errorf("invalid endpoint: %v", ep)
 }
 
+// metricsGRPC records the status code, latency and in-flight count of every CSI call
+func metricsGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+	start := time.Now()
+	metrics.GRPCRequestStarted(method)
+	resp, err := handler(ctx, req)
+	metrics.ObserveGRPCRequest(method, status.Code(err).String(), time.Since(start))
+	return resp, err
+}
+
+// recoverGRPC turns a panic in a CSI call into an Internal error, so that one bad call does not take
+// down the plugin with every other call in flight
+func recoverGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.FromContext(ctx).Error(nil, "GRPC call panicked", "panic", r, "stack", string(debug.Stack()))
+			metrics.GRPCPanic(path.Base(info.FullMethod))
+			err = status.Errorf(codes.Internal, "%s: internal error: %v", path.Base(info.FullMethod), r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// logGRPC logs every CSI call and stores a logger with the call ID, and the trace ID when tracing is on,
+// in the context, so that logs of the Hyperstack API requests made for the call can be correlated
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	callID := atomic.AddUint64(&serverGRPCEndpointCallCounter, 1)
 
-	klog.Infof("[ID:%d] GRPC call: %s", callID, info.FullMethod)
-	klog.Infof("[ID:%d] GRPC request: %s", callID, protosanitizer.StripSecrets(req))
+	logger := klog.FromContext(ctx).WithValues("callID", callID, "method", info.FullMethod)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = logger.WithValues("traceID", sc.TraceID().String())
+	}
+	ctx = klog.NewContext(ctx, logger)
+
+	logger.Info("GRPC call", "request", protosanitizer.StripSecrets(req).String())
 	resp, err := handler(ctx, req)
 	if err != nil {
-		klog.Errorf("[ID:%d] GRPC error: %v", callID, err)
+		logger.Error(err, "GRPC error")
 	} else {
-		klog.Infof("[ID:%d] GRPC response: %s", callID, protosanitizer.StripSecrets(resp))
+		logger.Info("GRPC response", "response", protosanitizer.StripSecrets(resp).String())
 	}
 
 	return resp, err
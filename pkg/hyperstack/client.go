@@ -2,29 +2,154 @@ package hyperstack
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	util "k8s.io/csi-hyperstack/pkg/utils"
 )
 
 type HyperstackClient struct {
 	Client    *http.Client
-	ApiKey    string
+	ApiKey    util.Secret
 	ApiServer string
+
+	// apiKeyFile replaces ApiKey when the key is read from a file
+	apiKeyFile *apiKeyFile
+}
+
+// ClientOpts configures timeouts, retries and client-side rate limiting of Hyperstack API requests
+type ClientOpts struct {
+	// Timeout of a request including retries when its context has no deadline, 0 disables it
+	Timeout time.Duration
+	// MaxRetries is the number of retries of a failed request
+	MaxRetries int
+	// QPS and Burst configure the token bucket shared by all requests, a QPS of 0 disables it
+	QPS   float64
+	Burst int
+
+	// CAFile is a PEM bundle trusted in addition to the system roots, reloaded when it changes
+	CAFile string
+	// Proxy is the URL of the HTTP(S) proxy, by default HTTPS_PROXY/NO_PROXY from the environment apply
+	Proxy string
+	// ClientCertFile and ClientKeyFile hold the client certificate for mTLS, reloaded when they change
+	ClientCertFile string
+	ClientKeyFile  string
+	// InsecureSkipVerify disables verification of the server certificate
+	InsecureSkipVerify bool
+
+	// APIKeyFile holds the API key instead of the apiKey argument, reloaded when it changes
+	APIKeyFile string
+}
+
+// DefaultClientOpts returns the options used by NewHyperstackClient
+func DefaultClientOpts() ClientOpts {
+	return ClientOpts{
+		Timeout:    2 * time.Minute,
+		MaxRetries: 5,
+		QPS:        10,
+		Burst:      20,
+	}
 }
 
 func NewHyperstackClient(
 	apiKey string,
 	apiServer string,
 ) *HyperstackClient {
-	return &HyperstackClient{
-		Client:    http.DefaultClient,
-		ApiKey:    apiKey,
+	// The default options have no TLS or proxy settings, which are the only ones that can fail
+	client, _ := NewHyperstackClientWithOpts(apiKey, apiServer, DefaultClientOpts())
+	return client
+}
+
+// NewHyperstackClientWithOpts creates a client whose requests are retried and rate limited according to opts
+func NewHyperstackClientWithOpts(
+	apiKey string,
+	apiServer string,
+	opts ClientOpts,
+) (*HyperstackClient, error) {
+	base := newTransport()
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS settings for the Hyperstack API: %w", err)
+	}
+	if tlsConfig != nil {
+		base.TLSClientConfig = tlsConfig
+	}
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid Hyperstack API proxy URL %q", opts.Proxy)
+		}
+		base.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := &retryTransport{
+		base:       newTracedTransport(base),
+		timeout:    opts.Timeout,
+		maxRetries: opts.MaxRetries,
+		baseDelay:  500 * time.Millisecond,
+		maxDelay:   30 * time.Second,
+	}
+	if opts.QPS > 0 {
+		burst := opts.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		transport.limiter = rate.NewLimiter(rate.Limit(opts.QPS), burst)
+	}
+
+	client := &HyperstackClient{
+		Client: &http.Client{
+			Transport: transport,
+		},
+		ApiKey:    util.Secret(apiKey),
 		ApiServer: apiServer,
 	}
+	if opts.APIKeyFile != "" {
+		keyFile, err := newAPIKeyFile(opts.APIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		client.apiKeyFile = keyFile
+	}
+	return client, nil
+}
+
+// newTransport returns the transport shared by all SDK clients. The idle pool is sized so that
+// concurrent attaches and detaches reuse connections instead of opening a new one per call.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConns = 100
+	t.MaxIdleConnsPerHost = 32
+	t.IdleConnTimeout = 90 * time.Second
+	t.TLSHandshakeTimeout = 10 * time.Second
+	t.ResponseHeaderTimeout = time.Minute
+	t.ExpectContinueTimeout = time.Second
+	return t
+}
+
+// Close stops watching the API key file
+func (c *HyperstackClient) Close() {
+	if c.apiKeyFile != nil {
+		c.apiKeyFile.close()
+	}
+}
+
+// apiKey returns the current API key, read from the API key file if one is set
+func (c HyperstackClient) apiKey() util.Secret {
+	if c.apiKeyFile != nil {
+		return c.apiKeyFile.get()
+	}
+	return c.ApiKey
 }
 
 func (c HyperstackClient) GetAddHeadersFn() func(ctx context.Context, req *http.Request) error {
 	return func(ctx context.Context, req *http.Request) error {
-		req.Header.Add("api_key", c.ApiKey)
+		req.Header.Add("api_key", c.apiKey().Value())
 		return nil
 	}
 }
@@ -0,0 +1,148 @@
+package hyperstack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"k8s.io/csi-hyperstack/pkg/metrics"
+	util "k8s.io/csi-hyperstack/pkg/utils"
+	"k8s.io/klog/v2"
+)
+
+// apiKeyFileResync is the interval at which the API key file is checked for changes that were not notified
+var apiKeyFileResync = time.Minute
+
+// apiKeyFile serves the API key from a file, e.g. a mounted Secret, and reloads it when the file changes,
+// so that a rotated key is used by the next request without restarting the driver.
+//
+// The directory of the file is watched rather than the file itself: the kubelet updates a Secret volume by
+// writing a new timestamped directory and atomically swapping the ..data symlink the key file points through.
+type apiKeyFile struct {
+	path    string
+	watcher *fsnotify.Watcher
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu      sync.RWMutex
+	key     util.Secret
+	modTime time.Time
+}
+
+// newAPIKeyFile loads the API key from path and starts watching it for changes
+func newAPIKeyFile(path string) (*apiKeyFile, error) {
+	f := &apiKeyFile{
+		path: filepath.Clean(path),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch API key file: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch API key file %s: %w", f.path, err)
+	}
+	f.watcher = watcher
+	go f.watch()
+	return f, nil
+}
+
+// get returns the current API key
+func (f *apiKeyFile) get() util.Secret {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.key
+}
+
+// load reads the API key file. If it cannot be read or is empty, the loaded key is kept.
+func (f *apiKeyFile) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read API key file: %w", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read API key file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return fmt.Errorf("API key file %s is empty", f.path)
+	}
+
+	f.mu.Lock()
+	reloaded := f.key != "" && f.key.Value() != key
+	f.key = util.Secret(key)
+	f.modTime = info.ModTime()
+	f.mu.Unlock()
+
+	if reloaded {
+		klog.InfoS("Reloaded Hyperstack API key", "file", f.path)
+	}
+	metrics.ObserveAPICredentialsReload(info.ModTime())
+	return nil
+}
+
+// changed reports whether the modification time of the API key file differs from the loaded one
+func (f *apiKeyFile) changed() bool {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !info.ModTime().Equal(f.modTime)
+}
+
+func (f *apiKeyFile) watch() {
+	defer close(f.done)
+	ticker := time.NewTicker(apiKeyFileResync)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case event, ok := <-f.watcher.Events:
+			if !ok {
+				return
+			}
+			// Events for the other keys of a Secret volume are ignored
+			if name := filepath.Base(event.Name); name != filepath.Base(f.path) && name != "..data" {
+				continue
+			}
+			if err := f.load(); err != nil {
+				klog.ErrorS(err, "Keeping the loaded Hyperstack API key")
+			}
+		case err, ok := <-f.watcher.Errors:
+			if !ok {
+				return
+			}
+			klog.ErrorS(err, "Failed to watch the Hyperstack API key file", "file", f.path)
+		case <-ticker.C:
+			if !f.changed() {
+				continue
+			}
+			if err := f.load(); err != nil {
+				klog.ErrorS(err, "Keeping the loaded Hyperstack API key")
+			}
+		}
+	}
+}
+
+// close stops watching the API key file
+func (f *apiKeyFile) close() {
+	close(f.stop)
+	<-f.done
+	f.watcher.Close()
+}
@@ -0,0 +1,111 @@
+package hyperstack
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSecretVolume lays out dir like the kubelet does for a Secret volume and returns the key file path:
+// key -> ..data/key, ..data -> ..<version>/
+func writeSecretVolume(t *testing.T, dir, version, key string) string {
+	versionDir := filepath.Join(dir, ".."+version)
+	if err := os.Mkdir(versionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(versionDir, "apiKey"), []byte(key+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The new ..data symlink is created under a temporary name and renamed over the old one
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(versionDir), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(dir, "apiKey")
+	if _, err := os.Lstat(keyFile); os.IsNotExist(err) {
+		if err := os.Symlink(filepath.Join("..data", "apiKey"), keyFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keyFile
+}
+
+func headerAPIKey(t *testing.T, c *HyperstackClient) string {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GetAddHeadersFn()(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	return req.Header.Get("api_key")
+}
+
+func TestAPIKeyFileReload(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := writeSecretVolume(t, dir, "v1", "first-key")
+
+	opts := DefaultClientOpts()
+	opts.APIKeyFile = keyFile
+	client, err := NewHyperstackClientWithOpts("", "http://localhost", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if got := headerAPIKey(t, client); got != "first-key" {
+		t.Fatalf("api_key = %q, expected first-key", got)
+	}
+
+	tests := []struct {
+		name     string
+		update   func()
+		expected string
+	}{
+		{
+			name:     "secret update swaps the data symlink",
+			update:   func() { writeSecretVolume(t, dir, "v2", "second-key") },
+			expected: "second-key",
+		},
+		{
+			name:     "empty key is ignored",
+			update:   func() { writeSecretVolume(t, dir, "v3", "") },
+			expected: "second-key",
+		},
+		{
+			name:     "next update is picked up",
+			update:   func() { writeSecretVolume(t, dir, "v4", "third-key") },
+			expected: "third-key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.update()
+			deadline := time.Now().Add(5 * time.Second)
+			got := headerAPIKey(t, client)
+			for got != tt.expected && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				got = headerAPIKey(t, client)
+			}
+			if got != tt.expected {
+				t.Errorf("api_key = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAPIKeyFileMissing(t *testing.T) {
+	opts := DefaultClientOpts()
+	opts.APIKeyFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := NewHyperstackClientWithOpts("", "http://localhost", opts); err == nil {
+		t.Error("expected an error for a missing API key file")
+	}
+}
@@ -0,0 +1,178 @@
+package hyperstack
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// tlsReloader serves the CA bundle and client certificate of the API connection from disk and
+// reloads them when the files change, so that rotated certificates are picked up by new connections
+// without restarting the driver.
+type tlsReloader struct {
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	caModTime   time.Time
+	caPool      *x509.CertPool
+	certModTime time.Time
+	cert        *tls.Certificate
+}
+
+// newTLSConfig returns the TLS configuration for the options, or nil if the defaults apply
+func newTLSConfig(opts ClientOpts) (*tls.Config, error) {
+	if opts.CAFile == "" && opts.ClientCertFile == "" && opts.ClientKeyFile == "" && !opts.InsecureSkipVerify {
+		return nil, nil
+	}
+	if (opts.ClientCertFile == "") != (opts.ClientKeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	r := &tlsReloader{
+		caFile:   opts.CAFile,
+		certFile: opts.ClientCertFile,
+		keyFile:  opts.ClientKeyFile,
+	}
+
+	if opts.ClientCertFile != "" {
+		if _, err := r.clientCertificate(nil); err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = r.clientCertificate
+	}
+
+	if opts.InsecureSkipVerify {
+		klog.Warning("!!! TLS verification of the Hyperstack API is DISABLED (--hyperstack-insecure-skip-verify). " +
+			"The connection and the API key are open to interception. Do not use this in production. !!!")
+		cfg.InsecureSkipVerify = true
+		return cfg, nil
+	}
+
+	if opts.CAFile != "" {
+		if _, err := r.rootCAs(); err != nil {
+			return nil, err
+		}
+		// The built-in verification only supports a fixed RootCAs pool, so it is replaced by
+		// verifyConnection, which checks the chain against the current CA bundle
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = r.verifyConnection
+	}
+	return cfg, nil
+}
+
+// rootCAs returns the system roots plus the CA bundle, reloading the bundle if the file changed.
+// If a changed file cannot be loaded, the previous pool is kept.
+func (r *tlsReloader) rootCAs() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.caFile)
+	if err != nil {
+		if r.caPool != nil {
+			klog.Errorf("tlsReloader: failed to stat CA file %s, keeping the loaded CA bundle: %v", r.caFile, err)
+			return r.caPool, nil
+		}
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	if r.caPool != nil && info.ModTime().Equal(r.caModTime) {
+		return r.caPool, nil
+	}
+
+	pool, err := loadCAPool(r.caFile)
+	if err != nil {
+		if r.caPool != nil {
+			klog.Errorf("tlsReloader: keeping the loaded CA bundle: %v", err)
+			return r.caPool, nil
+		}
+		return nil, err
+	}
+	if r.caPool != nil {
+		klog.Infof("tlsReloader: reloaded CA bundle %s", r.caFile)
+	}
+	r.caPool = pool
+	r.caModTime = info.ModTime()
+	return r.caPool, nil
+}
+
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+	return pool, nil
+}
+
+// clientCertificate returns the client certificate, reloading it if the certificate or key file changed
+func (r *tlsReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime := time.Time{}
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			if r.cert != nil {
+				klog.Errorf("tlsReloader: failed to stat %s, keeping the loaded client certificate: %v", f, err)
+				return r.cert, nil
+			}
+			return nil, fmt.Errorf("failed to read client certificate: %w", err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && modTime.Equal(r.certModTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			klog.Errorf("tlsReloader: keeping the loaded client certificate: %v", err)
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	if r.cert != nil {
+		klog.Infof("tlsReloader: reloaded client certificate %s", r.certFile)
+	}
+	r.cert = &cert
+	r.certModTime = modTime
+	return r.cert, nil
+}
+
+// verifyConnection verifies the server certificate chain and host name against the current CA bundle
+func (r *tlsReloader) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+	roots, err := r.rootCAs()
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
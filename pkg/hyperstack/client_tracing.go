@@ -0,0 +1,30 @@
+package hyperstack
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// tracedTransport starts a span for every HTTP request sent to the Hyperstack API, including each retry.
+// Spans are children of the span in the request context, usually the one of the CSI call.
+type tracedTransport struct {
+	http.RoundTripper
+	base *http.Transport
+}
+
+func newTracedTransport(base *http.Transport) *tracedTransport {
+	return &tracedTransport{
+		RoundTripper: otelhttp.NewTransport(base,
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return "Hyperstack API " + r.Method + " " + r.URL.Path
+			}),
+		),
+		base: base,
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the base transport, otelhttp does not forward it
+func (t *tracedTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
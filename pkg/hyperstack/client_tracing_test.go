@@ -0,0 +1,47 @@
+package hyperstack
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	"k8s.io/csi-hyperstack/pkg/tracing"
+)
+
+func TestTracedRequests(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	recorder := tracetest.NewSpanRecorder()
+	tp := tracing.NewProvider(sdktrace.WithSpanProcessor(recorder))
+
+	hs, srv := newTestHyperstack(t)
+	srv.AddCluster(fake.Cluster{ID: 1168, Environment: "CANADA-1"})
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "CreateVolume")
+	if _, err := hs.GetClusterDetail(ctx, 1168); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	var found bool
+	for _, span := range recorder.Ended() {
+		if span.Name() != "Hyperstack API GET /v1/core/clusters/1168" {
+			continue
+		}
+		found = true
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() || span.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("HTTP span is not a child of the CSI call span")
+		}
+	}
+	if !found {
+		names := []string{}
+		for _, span := range recorder.Ended() {
+			names = append(names, span.Name())
+		}
+		t.Errorf("no span for the cluster request, recorded %v", names)
+	}
+}
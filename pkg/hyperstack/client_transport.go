@@ -0,0 +1,196 @@
+package hyperstack
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+
+	"k8s.io/csi-hyperstack/pkg/metrics"
+)
+
+// retryTransport applies a deadline to requests without one, retries failed Hyperstack API requests with exponential backoff and full jitter,
+// honours Retry-After and limits the request rate with a token bucket shared by all requests.
+//
+// Only requests that are safe to repeat are retried on errors and 5xx responses: GET, HEAD, OPTIONS,
+// PUT and DELETE. POST and PATCH (create, attach, detach, update attachment) are only retried on
+// 429, which the API returns before doing any work.
+type retryTransport struct {
+	base       http.RoundTripper
+	timeout    time.Duration
+	limiter    *rate.Limiter
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+var retryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); ok || t.timeout <= 0 {
+		return t.roundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.roundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline has to outlive RoundTrip until the caller is done reading the body
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the idle connections of the base transport
+func (t *retryTransport) CloseIdleConnections() {
+	if ci, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		// A RoundTripper must not modify the request, so retries send a copy with a fresh body
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(ctx)
+			if req.Body != nil && req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		reason, retryable := t.retryReason(req, resp, err)
+		if !retryable || attempt >= t.maxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		klog.FromContext(req.Context()).V(4).Info("Retrying Hyperstack API request", "method", req.Method, "path", req.URL.Path, "delay", delay, "reason", reason, "attempt", attempt+1, "maxRetries", t.maxRetries)
+		metrics.ObserveAPIRetry(reason)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// wait blocks until the token bucket allows the next request
+func (t *retryTransport) wait(ctx context.Context) error {
+	if t.limiter == nil {
+		return nil
+	}
+	r := t.limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	metrics.ObserveAPIThrottle(delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryReason returns why the request should be retried, or false if it should not
+func (t *retryTransport) retryReason(req *http.Request, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		if req.Context().Err() != nil {
+			return "", false
+		}
+		return "error", isIdempotent(req.Method)
+	}
+	if !retryableStatusCodes[resp.StatusCode] {
+		return "", false
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return strconv.Itoa(resp.StatusCode), true
+	}
+	return strconv.Itoa(resp.StatusCode), isIdempotent(req.Method)
+}
+
+// backoff returns the Retry-After delay of the response if set, otherwise an exponential delay with full jitter
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if d > t.maxDelay {
+				return t.maxDelay
+			}
+			return d
+		}
+	}
+	delay := t.baseDelay << attempt
+	if delay <= 0 || delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		d := time.Until(date)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
@@ -0,0 +1,149 @@
+package hyperstack
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	testCases := []struct {
+		name          string
+		method        string
+		statuses      []int
+		retryAfter    string
+		expectedCalls int32
+		expectedCode  int
+	}{
+		{"get_retried_on_502", http.MethodGet, []int{502, 503, 200}, "", 3, 200},
+		{"get_gives_up_after_max_retries", http.MethodGet, []int{503, 503, 503, 503}, "", 3, 503},
+		{"post_not_retried_on_502", http.MethodPost, []int{502, 200}, "", 1, 502},
+		{"post_retried_on_429", http.MethodPost, []int{429, 200}, "0", 2, 200},
+		{"get_not_retried_on_404", http.MethodGet, []int{404, 200}, "", 1, 404},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				if r.Method == http.MethodPost {
+					body, _ := io.ReadAll(r.Body)
+					if string(body) != "payload" {
+						t.Errorf("request %d has body %q", n, body)
+					}
+				}
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			client := &http.Client{Transport: &retryTransport{
+				base:       http.DefaultTransport,
+				maxRetries: 2,
+				baseDelay:  time.Millisecond,
+				maxDelay:   10 * time.Millisecond,
+			}}
+			req, err := http.NewRequest(tc.method, srv.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.expectedCode {
+				t.Errorf("status code = %d, expected %d", resp.StatusCode, tc.expectedCode)
+			}
+			if got := atomic.LoadInt32(&calls); got != tc.expectedCalls {
+				t.Errorf("server called %d times, expected %d", got, tc.expectedCalls)
+			}
+		})
+	}
+}
+
+// recordingTransport answers with the given status codes and records the requests it was sent
+type recordingTransport struct {
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	req.Body.Close()
+	rt.requests = append(rt.requests, req)
+	rt.bodies = append(rt.bodies, string(body))
+	return &http.Response{
+		StatusCode: rt.statuses[len(rt.requests)-1],
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestRetryTransportDoesNotModifyRequest(t *testing.T) {
+	base := &recordingTransport{statuses: []int{503, 503, 200}}
+	transport := &retryTransport{
+		base:       base,
+		maxRetries: 2,
+		baseDelay:  time.Millisecond,
+		maxDelay:   10 * time.Millisecond,
+	}
+	req, err := http.NewRequest(http.MethodPut, "http://hyperstack.invalid/v1/core/volumes/1", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := req.Body
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if req.Body != body {
+		t.Error("RoundTrip replaced the body of the caller's request")
+	}
+	if len(base.requests) != 3 {
+		t.Fatalf("base transport called %d times, expected 3", len(base.requests))
+	}
+	for i, sent := range base.requests {
+		if i > 0 && sent == req {
+			t.Errorf("attempt %d reused the caller's request", i+1)
+		}
+		if base.bodies[i] != "payload" {
+			t.Errorf("attempt %d sent body %q", i+1, base.bodies[i])
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"seconds", "3", 3 * time.Second, true},
+		{"past_date", "Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+		{"empty", "", 0, false},
+		{"invalid", "soon", 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tc.value)
+			if got != tc.expected || ok != tc.ok {
+				t.Errorf("parseRetryAfter(%q) = %v, %v, expected %v, %v", tc.value, got, ok, tc.expected, tc.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package fake provides an in-process Hyperstack API server for hermetic tests. It implements the
+// volume, volume-attachment and cluster endpoints used by the driver with realistic status
+// transitions, API key checks and injectable errors and latency.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Operation identifies an API endpoint for error injection
+type Operation string
+
+const (
+	OpListVolumes      Operation = "ListVolumes"
+	OpGetVolume        Operation = "GetVolume"
+	OpCreateVolume     Operation = "CreateVolume"
+	OpDeleteVolume     Operation = "DeleteVolume"
+	OpAttachVolumes    Operation = "AttachVolumes"
+	OpDetachVolumes    Operation = "DetachVolumes"
+	OpUpdateAttachment Operation = "UpdateAttachment"
+	OpGetCluster       Operation = "GetCluster"
+)
+
+// Volume statuses as reported by the API
+const (
+	StatusCreating  = "creating"
+	StatusAvailable = "available"
+	StatusInUse     = "in-use"
+
+	attachmentAttached = "ATTACHED"
+)
+
+// APIKeyHeader is the header carrying the API key
+const APIKeyHeader = "api_key"
+
+// Volume is a volume stored by the fake server
+type Volume struct {
+	ID          int
+	Name        string
+	Size        int
+	VolumeType  string
+	Environment string
+	Description string
+	Status      string
+	CreatedAt   time.Time
+	// availableAt is when a creating volume becomes available
+	availableAt time.Time
+	Attachment  *Attachment
+}
+
+// Attachment is the attachment of a volume to a virtual machine
+type Attachment struct {
+	ID         int
+	InstanceID int
+	Device     string
+	Protected  bool
+}
+
+// Cluster is a Kubernetes cluster known to the fake server
+type Cluster struct {
+	ID          int
+	Name        string
+	Environment string
+}
+
+type fault struct {
+	status int
+	count  int
+}
+
+// Server is a fake Hyperstack API served over HTTP
+type Server struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	apiKey string
+	// createDelay is how long a new volume stays in status creating
+	createDelay      time.Duration
+	latency          time.Duration
+	nextVolumeID     int
+	nextAttachmentID int
+	volumes          map[int]*Volume
+	clusters         map[int]*Cluster
+	faults           map[Operation]*fault
+	calls            map[Operation]int
+}
+
+// NewServer starts a fake API server accepting the given API key
+func NewServer(apiKey string) *Server {
+	s := &Server{
+		apiKey:           apiKey,
+		nextVolumeID:     1000,
+		nextAttachmentID: 5000,
+		volumes:          map[int]*Volume{},
+		clusters:         map[int]*Cluster{},
+		faults:           map[Operation]*fault{},
+		calls:            map[Operation]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/core/volumes", s.handle(OpListVolumes, s.listVolumes))
+	mux.HandleFunc("POST /v1/core/volumes", s.handle(OpCreateVolume, s.createVolume))
+	mux.HandleFunc("GET /v1/core/volumes/{id}", s.handle(OpGetVolume, s.getVolume))
+	mux.HandleFunc("DELETE /v1/core/volumes/{id}", s.handle(OpDeleteVolume, s.deleteVolume))
+	mux.HandleFunc("POST /v1/core/virtual-machines/{id}/attach-volumes", s.handle(OpAttachVolumes, s.attachVolumes))
+	mux.HandleFunc("POST /v1/core/virtual-machines/{id}/detach-volumes", s.handle(OpDetachVolumes, s.detachVolumes))
+	mux.HandleFunc("PATCH /v1/core/volume-attachments/{id}", s.handle(OpUpdateAttachment, s.updateAttachment))
+	mux.HandleFunc("GET /v1/core/clusters/{id}", s.handle(OpGetCluster, s.getCluster))
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// URL returns the API address to configure the client with
+func (s *Server) URL() string {
+	return s.srv.URL + "/v1"
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetCreateDelay sets how long new volumes stay in status creating before becoming available
+func (s *Server) SetCreateDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createDelay = d
+}
+
+// SetLatency delays every response by d
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// InjectError makes the next count calls of op fail with the HTTP status. A count of -1 fails all calls.
+func (s *Server) InjectError(op Operation, status int, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[op] = &fault{status: status, count: count}
+}
+
+// Calls returns how many times op was called, including failed calls
+func (s *Server) Calls(op Operation) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[op]
+}
+
+// AddCluster registers a cluster
+func (s *Server) AddCluster(c Cluster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusters[c.ID] = &c
+}
+
+// AddVolume stores a volume and returns its ID. A zero ID is replaced by the next free one and an
+// empty status by available. A volume added as creating becomes available after the create delay.
+func (s *Server) AddVolume(v Volume) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v.ID == 0 {
+		s.nextVolumeID++
+		v.ID = s.nextVolumeID
+	}
+	if v.Status == "" {
+		v.Status = StatusAvailable
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	v.availableAt = time.Now().Add(s.createDelay)
+	if v.Attachment != nil && v.Attachment.ID == 0 {
+		s.nextAttachmentID++
+		v.Attachment.ID = s.nextAttachmentID
+	}
+	s.volumes[v.ID] = &v
+	return v.ID
+}
+
+// GetVolume returns a copy of the stored volume
+func (s *Server) GetVolume(id int) (Volume, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.volumes[id]
+	if !ok {
+		return Volume{}, false
+	}
+	s.refresh(v)
+	return *v, true
+}
+
+// refresh moves a creating volume to available once its create delay passed
+func (s *Server) refresh(v *Volume) {
+	if v.Status == StatusCreating && !time.Now().Before(v.availableAt) {
+		v.Status = StatusAvailable
+	}
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request)
+
+// handle wraps an endpoint with latency, API key checks and error injection
+func (s *Server) handle(op Operation, h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls[op]++
+		latency := s.latency
+		var injected int
+		if f, ok := s.faults[op]; ok && f.count != 0 {
+			injected = f.status
+			if f.count > 0 {
+				f.count--
+			}
+		}
+		s.mu.Unlock()
+
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		if r.Header.Get(APIKeyHeader) != s.apiKey {
+			writeError(w, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+		if injected != 0 {
+			writeError(w, injected, fmt.Sprintf("injected error for %s", op))
+			return
+		}
+		h(w, r)
+	}
+}
+
+type errorResponse struct {
+	Status      bool   `json:"status"`
+	Message     string `json:"message"`
+	ErrorReason string `json:"error_reason"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Status: false, Message: message, ErrorReason: http.StatusText(status)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid id %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
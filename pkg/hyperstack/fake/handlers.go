@@ -0,0 +1,303 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type environmentJSON struct {
+	Name string `json:"name"`
+}
+
+type volumeAttachmentJSON struct {
+	ID         int    `json:"id"`
+	InstanceID int    `json:"instance_id"`
+	Device     string `json:"device"`
+	Status     string `json:"status"`
+	Protected  bool   `json:"protected"`
+}
+
+type volumeJSON struct {
+	ID          int                    `json:"id"`
+	Name        string                 `json:"name"`
+	Size        int                    `json:"size"`
+	VolumeType  string                 `json:"volume_type"`
+	Description string                 `json:"description"`
+	Status      string                 `json:"status"`
+	Bootable    bool                   `json:"bootable"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+	Environment environmentJSON        `json:"environment"`
+	Attachments []volumeAttachmentJSON `json:"attachments"`
+}
+
+func toVolumeJSON(v *Volume) volumeJSON {
+	attachments := []volumeAttachmentJSON{}
+	if v.Attachment != nil {
+		attachments = append(attachments, volumeAttachmentJSON{
+			ID:         v.Attachment.ID,
+			InstanceID: v.Attachment.InstanceID,
+			Device:     v.Attachment.Device,
+			Status:     attachmentAttached,
+			Protected:  v.Attachment.Protected,
+		})
+	}
+	return volumeJSON{
+		ID:          v.ID,
+		Name:        v.Name,
+		Size:        v.Size,
+		VolumeType:  v.VolumeType,
+		Description: v.Description,
+		Status:      v.Status,
+		CreatedAt:   v.CreatedAt,
+		UpdatedAt:   v.CreatedAt,
+		Environment: environmentJSON{Name: v.Environment},
+		Attachments: attachments,
+	}
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	volumes := []volumeJSON{}
+	for _, v := range s.volumes {
+		s.refresh(v)
+		volumes = append(volumes, toVolumeJSON(v))
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "Getting volumes success", "volumes": volumes})
+}
+
+func (s *Server) getVolume(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.volumes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("volume %d not found", id))
+		return
+	}
+	s.refresh(v)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "Getting volume success", "volume": toVolumeJSON(v)})
+}
+
+type createVolumePayload struct {
+	Name            string  `json:"name"`
+	Size            int     `json:"size"`
+	VolumeType      string  `json:"volume_type"`
+	EnvironmentName string  `json:"environment_name"`
+	Description     *string `json:"description"`
+}
+
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request) {
+	var payload createVolumePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid payload: %v", err))
+		return
+	}
+	if payload.Name == "" || payload.Size <= 0 || payload.EnvironmentName == "" || payload.VolumeType == "" {
+		writeError(w, http.StatusBadRequest, "name, size, volume_type and environment_name are required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextVolumeID++
+	now := time.Now()
+	v := &Volume{
+		ID:          s.nextVolumeID,
+		Name:        payload.Name,
+		Size:        payload.Size,
+		VolumeType:  payload.VolumeType,
+		Environment: payload.EnvironmentName,
+		Status:      StatusCreating,
+		CreatedAt:   now,
+		availableAt: now.Add(s.createDelay),
+	}
+	if payload.Description != nil {
+		v.Description = *payload.Description
+	}
+	s.volumes[v.ID] = v
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "Creating volume success", "volume": toVolumeJSON(v)})
+}
+
+func (s *Server) deleteVolume(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.volumes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("volume %d not found", id))
+		return
+	}
+	s.refresh(v)
+	if v.Status != StatusAvailable {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("volume %d is %s and cannot be deleted", id, v.Status))
+		return
+	}
+	delete(s.volumes, id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "Deleting volume success"})
+}
+
+type volumeIDsPayload struct {
+	VolumeIDs []int `json:"volume_ids"`
+	Protected *bool `json:"protected"`
+}
+
+func (s *Server) attachVolumes(w http.ResponseWriter, r *http.Request) {
+	vmID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var payload volumeIDsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload.VolumeIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "volume_ids is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range payload.VolumeIDs {
+		v, ok := s.volumes[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("volume %d not found", id))
+			return
+		}
+		s.refresh(v)
+		if v.Status != StatusAvailable {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("volume %d is %s and cannot be attached", id, v.Status))
+			return
+		}
+	}
+
+	now := time.Now()
+	attachments := []map[string]interface{}{}
+	for i, id := range payload.VolumeIDs {
+		v := s.volumes[id]
+		s.nextAttachmentID++
+		v.Attachment = &Attachment{
+			ID:         s.nextAttachmentID,
+			InstanceID: vmID,
+			Device:     fmt.Sprintf("/dev/vd%c", 'b'+i),
+			Protected:  payload.Protected != nil && *payload.Protected,
+		}
+		v.Status = StatusInUse
+		attachments = append(attachments, map[string]interface{}{
+			"id":          v.Attachment.ID,
+			"instance_id": vmID,
+			"volume_id":   id,
+			"device":      v.Attachment.Device,
+			"status":      attachmentAttached,
+			"protected":   v.Attachment.Protected,
+			"created_at":  now,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "Attaching volumes success", "volume_attachments": attachments})
+}
+
+func (s *Server) detachVolumes(w http.ResponseWriter, r *http.Request) {
+	vmID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var payload volumeIDsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload.VolumeIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "volume_ids is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range payload.VolumeIDs {
+		v, ok := s.volumes[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("volume %d not found", id))
+			return
+		}
+		if v.Attachment == nil || v.Attachment.InstanceID != vmID {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("volume %d is not attached to virtual machine %d", id, vmID))
+			return
+		}
+		if v.Attachment.Protected {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("volume attachment %d is protected", v.Attachment.ID))
+			return
+		}
+	}
+
+	detached := []map[string]interface{}{}
+	for _, id := range payload.VolumeIDs {
+		v := s.volumes[id]
+		detached = append(detached, map[string]interface{}{
+			"id":          v.Attachment.ID,
+			"instance_id": vmID,
+			"volume_id":   id,
+			"status":      "DETACHED",
+		})
+		v.Attachment = nil
+		v.Status = StatusAvailable
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "Detaching volumes success", "volume_attachments": detached})
+}
+
+func (s *Server) updateAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Protected *bool `json:"protected"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Protected == nil {
+		writeError(w, http.StatusBadRequest, "protected is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.volumes {
+		if v.Attachment != nil && v.Attachment.ID == attachmentID {
+			v.Attachment.Protected = *payload.Protected
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status":  true,
+				"message": "Updating volume attachment success",
+				"volume_attachment": map[string]interface{}{
+					"id":        attachmentID,
+					"protected": v.Attachment.Protected,
+				},
+			})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("volume attachment %d not found", attachmentID))
+}
+
+func (s *Server) getCluster(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clusters[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("cluster %d not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  true,
+		"message": "Getting cluster success",
+		"cluster": map[string]interface{}{
+			"id":               c.ID,
+			"name":             c.Name,
+			"environment_name": c.Environment,
+			"status":           "ACTIVE",
+		},
+	})
+}
@@ -0,0 +1,352 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/clusters"
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume_attachment"
+
+	util "k8s.io/csi-hyperstack/pkg/utils"
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+)
+
+// Hyperstack is a thread-safe in-memory implementation of hyperstack.IHyperstack for driver tests.
+// Volumes follow the API state machine: created volumes are creating until they were read
+// CreatePolls times, then available, in-use while attached and available again after detaching.
+// Errors can be injected per method with InjectError.
+type Hyperstack struct {
+	mu sync.Mutex
+
+	// CreatePolls is how many GetVolume calls a new volume stays in status creating
+	CreatePolls int
+	// DescribeVolume returns the description of a created volume from its tags, by default
+	// the comma-separated key=value pairs. An error fails CreateVolume.
+	DescribeVolume func(tags map[string]string) (string, error)
+
+	nextVolumeID     int
+	nextAttachmentID int
+	volumes          map[int]*memVolume
+	clusters         map[int]clusters.ClusterFields
+	errors           map[string]error
+	calls            map[string]int
+}
+
+type memVolume struct {
+	Volume
+	polls int
+}
+
+// NewHyperstack returns an empty in-memory Hyperstack
+func NewHyperstack() *Hyperstack {
+	return &Hyperstack{
+		nextVolumeID:     1000,
+		nextAttachmentID: 5000,
+		volumes:          map[int]*memVolume{},
+		clusters:         map[int]clusters.ClusterFields{},
+		errors:           map[string]error{},
+		calls:            map[string]int{},
+	}
+}
+
+// InjectError makes every following call of the method, e.g. "AttachVolumeToNode", fail with err.
+// A nil err removes the injected error.
+func (h *Hyperstack) InjectError(method string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		delete(h.errors, method)
+		return
+	}
+	h.errors[method] = err
+}
+
+// Calls returns how many times the method was called
+func (h *Hyperstack) Calls(method string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls[method]
+}
+
+// AddCluster registers a cluster
+func (h *Hyperstack) AddCluster(id int, name string, environment string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clusters[id] = clusters.ClusterFields{Id: &id, Name: &name, EnvironmentName: &environment}
+}
+
+// AddVolume stores a volume and returns its ID, see Server.AddVolume
+func (h *Hyperstack) AddVolume(v Volume) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v.ID == 0 {
+		h.nextVolumeID++
+		v.ID = h.nextVolumeID
+	}
+	if v.Status == "" {
+		v.Status = StatusAvailable
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	if v.Attachment != nil && v.Attachment.ID == 0 {
+		h.nextAttachmentID++
+		v.Attachment.ID = h.nextAttachmentID
+	}
+	h.volumes[v.ID] = &memVolume{Volume: v}
+	return v.ID
+}
+
+// Volume returns a copy of the stored volume
+func (h *Hyperstack) Volume(id int) (Volume, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.volumes[id]
+	if !ok {
+		return Volume{}, false
+	}
+	return v.Volume, true
+}
+
+// call counts a call of the method and returns its injected error
+func (h *Hyperstack) call(method string) error {
+	h.calls[method]++
+	return h.errors[method]
+}
+
+func toVolumeFields(v *Volume) *volume.VolumeFields {
+	id, name, size, vtype, description, status, environment := v.ID, v.Name, v.Size, v.VolumeType, v.Description, v.Status, v.Environment
+	createdAt := v.CreatedAt
+	attachments := []volume.AttachmentsFieldsForVolume{}
+	if v.Attachment != nil {
+		a := *v.Attachment
+		attached := attachmentAttached
+		attachments = append(attachments, volume.AttachmentsFieldsForVolume{
+			Id:         &a.ID,
+			InstanceId: &a.InstanceID,
+			Device:     &a.Device,
+			Protected:  &a.Protected,
+			Status:     &attached,
+		})
+	}
+	return &volume.VolumeFields{
+		Id:          &id,
+		Name:        &name,
+		Size:        &size,
+		VolumeType:  &vtype,
+		Description: &description,
+		Status:      &status,
+		CreatedAt:   &createdAt,
+		UpdatedAt:   &createdAt,
+		Environment: &volume.EnvironmentFieldsForVolume{Name: &environment},
+		Attachments: &attachments,
+	}
+}
+
+func (h *Hyperstack) CreateVolume(ctx context.Context, name string, size int, vtype, environment string, tags map[string]string) (*volume.VolumeFields, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("CreateVolume"); err != nil {
+		return nil, err
+	}
+	if name == "" || size <= 0 || environment == "" {
+		return nil, fmt.Errorf("name, size and environment are required")
+	}
+
+	description := ""
+	if h.DescribeVolume != nil {
+		var err error
+		if description, err = h.DescribeVolume(tags); err != nil {
+			return nil, err
+		}
+	} else {
+		pairs := []string{}
+		for k, v := range tags {
+			pairs = append(pairs, k+"="+v)
+		}
+		description = strings.Join(pairs, ",")
+	}
+	h.nextVolumeID++
+	v := &memVolume{Volume: Volume{
+		ID:          h.nextVolumeID,
+		Name:        name,
+		Size:        size,
+		VolumeType:  vtype,
+		Environment: environment,
+		Description: description,
+		Status:      StatusCreating,
+		CreatedAt:   time.Now(),
+	}}
+	if h.CreatePolls <= 0 {
+		v.Status = StatusAvailable
+	}
+	h.volumes[v.ID] = v
+	return toVolumeFields(&v.Volume), nil
+}
+
+func (h *Hyperstack) GetVolume(ctx context.Context, volumeID int) (*volume.VolumeFields, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("GetVolume"); err != nil {
+		return nil, err
+	}
+	v, ok := h.volumes[volumeID]
+	if !ok {
+		return nil, fmt.Errorf("volume %d %w", volumeID, util.ErrNotFound)
+	}
+	if v.Status == StatusCreating {
+		v.polls++
+		if v.polls >= h.CreatePolls {
+			v.Status = StatusAvailable
+		}
+	}
+	return toVolumeFields(&v.Volume), nil
+}
+
+func (h *Hyperstack) GetVolumesByName(ctx context.Context, name string) ([]volume.VolumeFields, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("GetVolumesByName"); err != nil {
+		return nil, err
+	}
+	res := []volume.VolumeFields{}
+	for _, v := range h.volumes {
+		if v.Name == name {
+			res = append(res, *toVolumeFields(&v.Volume))
+		}
+	}
+	return res, nil
+}
+
+func (h *Hyperstack) ListVolumes(ctx context.Context) ([]volume.VolumeFields, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("ListVolumes"); err != nil {
+		return nil, err
+	}
+	res := []volume.VolumeFields{}
+	for _, v := range h.volumes {
+		res = append(res, *toVolumeFields(&v.Volume))
+	}
+	return res, nil
+}
+
+func (h *Hyperstack) DeleteVolume(ctx context.Context, volumeID int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("DeleteVolume"); err != nil {
+		return err
+	}
+	v, ok := h.volumes[volumeID]
+	if !ok {
+		return fmt.Errorf("volume %d %w", volumeID, util.ErrNotFound)
+	}
+	if v.Status != StatusAvailable {
+		return fmt.Errorf("volume %d is %s and cannot be deleted", volumeID, v.Status)
+	}
+	delete(h.volumes, volumeID)
+	return nil
+}
+
+func (h *Hyperstack) GetMetadataOpts() metadata.Opts {
+	return metadata.Opts{}
+}
+
+func (h *Hyperstack) AttachVolumeToNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.AttachVolumeFields, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("AttachVolumeToNode"); err != nil {
+		return nil, err
+	}
+	v, ok := h.volumes[volumeID]
+	if !ok {
+		return nil, fmt.Errorf("volume %d %w", volumeID, util.ErrNotFound)
+	}
+	if v.Status != StatusAvailable {
+		return nil, fmt.Errorf("volume %d is %s and cannot be attached", volumeID, v.Status)
+	}
+
+	h.nextAttachmentID++
+	v.Attachment = &Attachment{ID: h.nextAttachmentID, InstanceID: virtualMachineId, Device: "/dev/vdb", Protected: true}
+	v.Status = StatusInUse
+
+	a := *v.Attachment
+	attached := attachmentAttached
+	createdAt := time.Now()
+	return &volume_attachment.AttachVolumeFields{
+		Id:         &a.ID,
+		InstanceId: &a.InstanceID,
+		VolumeId:   &volumeID,
+		Device:     &a.Device,
+		Protected:  &a.Protected,
+		Status:     &attached,
+		CreatedAt:  &createdAt,
+	}, nil
+}
+
+func (h *Hyperstack) DetachVolumeFromNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.DetachVolumes, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("DetachVolumeFromNode"); err != nil {
+		return nil, err
+	}
+	v, ok := h.volumes[volumeID]
+	if !ok {
+		return nil, fmt.Errorf("volume %d %w", volumeID, util.ErrNotFound)
+	}
+	if v.Attachment == nil || v.Attachment.InstanceID != virtualMachineId {
+		return nil, fmt.Errorf("volume %d is not attached to virtual machine %d", volumeID, virtualMachineId)
+	}
+
+	attachmentID := v.Attachment.ID
+	v.Attachment = nil
+	v.Status = StatusAvailable
+
+	message := "Detaching volumes success"
+	ok = true
+	detached := "DETACHED"
+	return &volume_attachment.DetachVolumes{
+		Message: &message,
+		Status:  &ok,
+		VolumeAttachments: &[]volume_attachment.DetachVolumeFields{{
+			Id:         &attachmentID,
+			InstanceId: &virtualMachineId,
+			VolumeId:   &volumeID,
+			Status:     &detached,
+		}},
+	}, nil
+}
+
+func (h *Hyperstack) UnprotectVolumeAttachment(ctx context.Context, volumeID int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("UnprotectVolumeAttachment"); err != nil {
+		return err
+	}
+	v, ok := h.volumes[volumeID]
+	if !ok {
+		return fmt.Errorf("volume %d %w", volumeID, util.ErrNotFound)
+	}
+	if v.Attachment == nil {
+		return fmt.Errorf("volume %d has no attachment to update", volumeID)
+	}
+	v.Attachment.Protected = false
+	return nil
+}
+
+func (h *Hyperstack) GetClusterDetail(ctx context.Context, clusterID int) (*clusters.ClusterFields, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.call("GetClusterDetail"); err != nil {
+		return nil, err
+	}
+	c, ok := h.clusters[clusterID]
+	if !ok {
+		return nil, fmt.Errorf("cluster %d not found", clusterID)
+	}
+	return &c, nil
+}
@@ -1,6 +1,9 @@
 package hyperstack
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/NexGenCloud/hyperstack-sdk-go/lib/clusters"
 	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
 	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume_attachment"
@@ -12,18 +15,80 @@ type IHyperstack interface {
 	CreateVolume(ctx context.Context, name string, size int, vtype, environment string, tags map[string]string) (*volume.VolumeFields, error)
 	GetVolume(ctx context.Context, volumeID int) (*volume.VolumeFields, error)
 	GetVolumesByName(ctx context.Context, name string) ([]volume.VolumeFields, error)
+	ListVolumes(ctx context.Context) ([]volume.VolumeFields, error)
 	DeleteVolume(ctx context.Context, volumeID int) error
 	GetMetadataOpts() metadata.Opts
 	AttachVolumeToNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.AttachVolumeFields, error)
 	DetachVolumeFromNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.DetachVolumes, error)
+	UnprotectVolumeAttachment(ctx context.Context, volumeID int) error
 	GetClusterDetail(ctx context.Context, clusterID int) (*clusters.ClusterFields, error)
 }
 
 type Hyperstack struct {
 	Client       *HyperstackClient
 	metadataOpts metadata.Opts
+
+	// The typed SDK clients are created once on first use and share Client's transport
+	clientsOnce      sync.Once
+	clientsErr       error
+	volumeClient     *volume.ClientWithResponses
+	attachmentClient *volume_attachment.ClientWithResponses
+	clustersClient   *clusters.ClientWithResponses
 }
 
 func (hs *Hyperstack) GetMetadataOpts() metadata.Opts {
 	return hs.metadataOpts
 }
+
+// initClients creates the typed SDK clients
+func (hs *Hyperstack) initClients() error {
+	hs.clientsOnce.Do(func() {
+		if hs.Client == nil {
+			hs.clientsErr = fmt.Errorf("hyperstack client is not initialized")
+			return
+		}
+		hs.volumeClient, hs.clientsErr = volume.NewClientWithResponses(
+			hs.Client.ApiServer,
+			volume.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
+			volume.WithHTTPClient(hs.Client.Client),
+		)
+		if hs.clientsErr != nil {
+			return
+		}
+		hs.attachmentClient, hs.clientsErr = volume_attachment.NewClientWithResponses(
+			hs.Client.ApiServer,
+			volume_attachment.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
+			volume_attachment.WithHTTPClient(hs.Client.Client),
+		)
+		if hs.clientsErr != nil {
+			return
+		}
+		hs.clustersClient, hs.clientsErr = clusters.NewClientWithResponses(
+			hs.Client.ApiServer,
+			clusters.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
+			clusters.WithHTTPClient(hs.Client.Client),
+		)
+	})
+	return hs.clientsErr
+}
+
+func (hs *Hyperstack) getVolumeClient() (*volume.ClientWithResponses, error) {
+	if err := hs.initClients(); err != nil {
+		return nil, err
+	}
+	return hs.volumeClient, nil
+}
+
+func (hs *Hyperstack) getVolumeAttachmentClient() (*volume_attachment.ClientWithResponses, error) {
+	if err := hs.initClients(); err != nil {
+		return nil, err
+	}
+	return hs.attachmentClient, nil
+}
+
+func (hs *Hyperstack) getClustersClient() (*clusters.ClientWithResponses, error) {
+	if err := hs.initClients(); err != nil {
+		return nil, err
+	}
+	return hs.clustersClient, nil
+}
@@ -0,0 +1,87 @@
+package hyperstack
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume_attachment"
+)
+
+const attachResponse = `{"status":true,"volume_attachments":[{"id":1,"instance_id":1,"volume_id":1,"status":"ATTACHED","device":"/dev/vdb"}]}`
+
+// newAttachServer serves attach requests with a small latency and counts the connections opened by clients
+func newAttachServer(b *testing.B) (*httptest.Server, *int64) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests have to overlap for the size of the idle pool to matter
+		time.Sleep(time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(attachResponse))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	b.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+// BenchmarkConcurrentAttach compares creating an SDK client per call, as the driver used to do,
+// with the shared clients and transport of Hyperstack. conns/op is the number of new TCP connections per attach.
+func BenchmarkConcurrentAttach(b *testing.B) {
+	b.Run("per_call_clients", func(b *testing.B) {
+		srv, conns := newAttachServer(b)
+		headers := HyperstackClient{ApiKey: "key"}.GetAddHeadersFn()
+		b.ReportAllocs()
+		b.SetParallelism(8)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				client, err := volume_attachment.NewClientWithResponses(srv.URL, volume_attachment.WithRequestEditorFn(headers))
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				protected := true
+				if _, err := client.AttachVolumesToVirtualMachineWithResponse(context.Background(), 1, volume_attachment.AttachVolumesPayload{
+					VolumeIds: &[]int{1},
+					Protected: &protected,
+				}); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+		b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+		http.DefaultClient.CloseIdleConnections()
+	})
+
+	b.Run("shared_clients", func(b *testing.B) {
+		srv, conns := newAttachServer(b)
+		client, err := NewHyperstackClientWithOpts("key", srv.URL, ClientOpts{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		hs := &Hyperstack{Client: client}
+		b.ReportAllocs()
+		b.SetParallelism(8)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := hs.AttachVolumeToNode(context.Background(), 1, 1); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+		b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+		hs.Client.Client.CloseIdleConnections()
+	})
+}
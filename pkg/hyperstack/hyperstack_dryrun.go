@@ -0,0 +1,221 @@
+package hyperstack
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/clusters"
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume_attachment"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+
+	util "k8s.io/csi-hyperstack/pkg/utils"
+	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+)
+
+// dryRunVolumeIDBase is the first ID handed out for volumes "created" in dry-run mode, well above real IDs
+const dryRunVolumeIDBase = 900000000
+
+// DryRun wraps an IHyperstack so that read calls reach the API while mutating calls are only logged
+// as intents and answered with synthetic results. Volumes created in dry-run mode are kept in memory,
+// so that the follow-up reads of the driver see a consistent plan. Every method is implemented
+// explicitly, so that a method added to IHyperstack does not build until it is classified here.
+type DryRun struct {
+	hs IHyperstack
+
+	mu      sync.Mutex
+	nextID  int
+	volumes map[int]*volume.VolumeFields
+	deleted map[int]bool
+}
+
+var _ IHyperstack = &DryRun{}
+
+// NewDryRun returns an IHyperstack that never changes anything in Hyperstack
+func NewDryRun(hs IHyperstack) *DryRun {
+	return &DryRun{
+		hs:      hs,
+		nextID:  dryRunVolumeIDBase,
+		volumes: map[int]*volume.VolumeFields{},
+		deleted: map[int]bool{},
+	}
+}
+
+func logIntent(action string, keysAndValues ...interface{}) {
+	klog.InfoS("Dry run: skipping mutating call", append([]interface{}{"action", action}, keysAndValues...)...)
+}
+
+func (dr *DryRun) CreateVolume(ctx context.Context, name string, size int, vtype, environment string, tags map[string]string) (*volume.VolumeFields, error) {
+	description, err := encodeVolumeDescription(tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe volume %s: %w", name, err)
+	}
+	logIntent("CreateVolume", "name", name, "sizeGiB", size, "type", vtype, "environment", environment, "tags", tags)
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.nextID++
+	id := dr.nextID
+	status := "available"
+	createdAt := time.Now()
+	vol := &volume.VolumeFields{
+		Id:          &id,
+		Name:        &name,
+		Size:        &size,
+		VolumeType:  &vtype,
+		Status:      &status,
+		Description: &description,
+		CreatedAt:   &createdAt,
+		Environment: &volume.EnvironmentFieldsForVolume{Name: &environment},
+		Attachments: &[]volume.AttachmentsFieldsForVolume{},
+	}
+	dr.volumes[id] = vol
+	copied := *vol
+	return &copied, nil
+}
+
+func (dr *DryRun) GetVolume(ctx context.Context, volumeID int) (*volume.VolumeFields, error) {
+	dr.mu.Lock()
+	vol, ok := dr.volumes[volumeID]
+	deleted := dr.deleted[volumeID]
+	dr.mu.Unlock()
+	if ok {
+		copied := *vol
+		return &copied, nil
+	}
+	if deleted {
+		return nil, fmt.Errorf("volume %d was deleted in dry-run mode: %w", volumeID, util.ErrNotFound)
+	}
+	return dr.hs.GetVolume(ctx, volumeID)
+}
+
+func (dr *DryRun) GetVolumesByName(ctx context.Context, name string) ([]volume.VolumeFields, error) {
+	volumes, err := dr.hs.GetVolumesByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return dr.merge(volumes, func(vol *volume.VolumeFields) bool {
+		return vol.Name != nil && *vol.Name == name
+	}), nil
+}
+
+func (dr *DryRun) ListVolumes(ctx context.Context) ([]volume.VolumeFields, error) {
+	volumes, err := dr.hs.ListVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dr.merge(volumes, func(*volume.VolumeFields) bool { return true }), nil
+}
+
+func (dr *DryRun) GetMetadataOpts() metadata.Opts {
+	return dr.hs.GetMetadataOpts()
+}
+
+func (dr *DryRun) GetClusterDetail(ctx context.Context, clusterID int) (*clusters.ClusterFields, error) {
+	return dr.hs.GetClusterDetail(ctx, clusterID)
+}
+
+// merge drops volumes deleted in dry-run mode from the API result and adds the synthetic ones matching the filter
+func (dr *DryRun) merge(volumes []volume.VolumeFields, match func(*volume.VolumeFields) bool) []volume.VolumeFields {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	merged := []volume.VolumeFields{}
+	for _, vol := range volumes {
+		if vol.Id != nil && dr.deleted[*vol.Id] {
+			continue
+		}
+		merged = append(merged, vol)
+	}
+	for _, vol := range dr.volumes {
+		if match(vol) {
+			merged = append(merged, *vol)
+		}
+	}
+	return merged
+}
+
+func (dr *DryRun) DeleteVolume(ctx context.Context, volumeID int) error {
+	logIntent("DeleteVolume", "volumeID", volumeID)
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	delete(dr.volumes, volumeID)
+	dr.deleted[volumeID] = true
+	return nil
+}
+
+func (dr *DryRun) AttachVolumeToNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.AttachVolumeFields, error) {
+	logIntent("AttachVolumeToNode", "virtualMachineID", virtualMachineId, "volumeID", volumeID)
+
+	device := "/dev/vdz"
+	status := "attached"
+	protected := true
+	createdAt := time.Now()
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if vol, ok := dr.volumes[volumeID]; ok {
+		inUse := "in-use"
+		vol.Status = &inUse
+		vol.Attachments = &[]volume.AttachmentsFieldsForVolume{{
+			Device:     &device,
+			Id:         &volumeID,
+			InstanceId: &virtualMachineId,
+			Protected:  &protected,
+			Status:     &status,
+		}}
+	}
+	return &volume_attachment.AttachVolumeFields{
+		CreatedAt:  &createdAt,
+		Device:     &device,
+		Id:         &volumeID,
+		InstanceId: &virtualMachineId,
+		Protected:  &protected,
+		Status:     &status,
+		VolumeId:   &volumeID,
+	}, nil
+}
+
+func (dr *DryRun) UnprotectVolumeAttachment(ctx context.Context, volumeID int) error {
+	logIntent("UpdateVolumeAttachment", "volumeID", volumeID, "protected", false)
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if vol, ok := dr.volumes[volumeID]; ok && vol.Attachments != nil {
+		unprotected := false
+		for i := range *vol.Attachments {
+			(*vol.Attachments)[i].Protected = &unprotected
+		}
+	}
+	return nil
+}
+
+// DetachVolumeFromNode also covers the attachment update that unprotects the volume before detaching it
+func (dr *DryRun) DetachVolumeFromNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.DetachVolumes, error) {
+	logIntent("UpdateVolumeAttachment", "volumeID", volumeID, "protected", false)
+	logIntent("DetachVolumeFromNode", "virtualMachineID", virtualMachineId, "volumeID", volumeID)
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if vol, ok := dr.volumes[volumeID]; ok {
+		available := "available"
+		vol.Status = &available
+		vol.Attachments = &[]volume.AttachmentsFieldsForVolume{}
+	}
+
+	message := "dry run"
+	ok := true
+	status := "detached"
+	return &volume_attachment.DetachVolumes{
+		Message: &message,
+		Status:  &ok,
+		VolumeAttachments: &[]volume_attachment.DetachVolumeFields{{
+			Id:         &volumeID,
+			InstanceId: &virtualMachineId,
+			Status:     &status,
+			VolumeId:   &volumeID,
+		}},
+	}, nil
+}
@@ -0,0 +1,124 @@
+package hyperstack
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+	"golang.org/x/net/context"
+
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	util "k8s.io/csi-hyperstack/pkg/utils"
+)
+
+// dryRunReads are the IHyperstack methods that DryRun passes through. Every other method mutates
+// and must never reach the wrapped client.
+var dryRunReads = map[string]bool{
+	"GetVolume":        true,
+	"GetVolumesByName": true,
+	"ListVolumes":      true,
+	"GetMetadataOpts":  true,
+	"GetClusterDetail": true,
+}
+
+// emptyHyperstack answers list calls with no volumes; any other call panics
+type emptyHyperstack struct {
+	IHyperstack
+}
+
+func (e *emptyHyperstack) GetVolumesByName(ctx context.Context, name string) ([]volume.VolumeFields, error) {
+	return nil, nil
+}
+
+func (e *emptyHyperstack) ListVolumes(ctx context.Context) ([]volume.VolumeFields, error) {
+	return nil, nil
+}
+
+func TestDryRunVolumeLifecycle(t *testing.T) {
+	ctx := context.Background()
+	dr := NewDryRun(&emptyHyperstack{})
+
+	vol, err := dr.CreateVolume(ctx, "pvc-1", 10, "Cloud-SSD", "CANADA-1", map[string]string{"cost-center": "42"})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if *vol.Status != "available" || !IsCreatedByDriver(vol) {
+		t.Errorf("unexpected synthetic volume: status %s, description %s", *vol.Status, *vol.Description)
+	}
+
+	byName, err := dr.GetVolumesByName(ctx, "pvc-1")
+	if err != nil || len(byName) != 1 {
+		t.Fatalf("GetVolumesByName() = %v, %v, expected the synthetic volume", byName, err)
+	}
+
+	if _, err := dr.AttachVolumeToNode(ctx, 7, *vol.Id); err != nil {
+		t.Fatalf("AttachVolumeToNode() error = %v", err)
+	}
+	got, err := dr.GetVolume(ctx, *vol.Id)
+	if err != nil || *got.Status != "in-use" || len(*got.Attachments) != 1 {
+		t.Fatalf("GetVolume() after attach = %+v, %v", got, err)
+	}
+
+	if err := dr.UnprotectVolumeAttachment(ctx, *vol.Id); err != nil {
+		t.Fatalf("UnprotectVolumeAttachment() error = %v", err)
+	}
+	if got, _ := dr.GetVolume(ctx, *vol.Id); *(*got.Attachments)[0].Protected {
+		t.Errorf("attachment is still protected after UnprotectVolumeAttachment()")
+	}
+
+	if _, err := dr.DetachVolumeFromNode(ctx, 7, *vol.Id); err != nil {
+		t.Fatalf("DetachVolumeFromNode() error = %v", err)
+	}
+	if err := dr.DeleteVolume(ctx, *vol.Id); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := dr.GetVolume(ctx, *vol.Id); !errors.Is(err, util.ErrNotFound) {
+		t.Errorf("GetVolume() of a deleted volume error = %v, expected ErrNotFound", err)
+	}
+	if volumes, _ := dr.ListVolumes(ctx); len(volumes) != 0 {
+		t.Errorf("ListVolumes() = %v, expected no volumes", volumes)
+	}
+}
+
+func TestDryRunNeverCallsMutatingMethods(t *testing.T) {
+	hs := fake.NewHyperstack()
+	volumeID := hs.AddVolume(fake.Volume{Name: "pvc-1", Size: 10, Status: fake.StatusInUse, Attachment: &fake.Attachment{InstanceID: 7, Protected: true}})
+	dr := reflect.ValueOf(NewDryRun(hs))
+
+	contextType := reflect.TypeOf((*context.Context)(nil)).Elem()
+	methods := reflect.TypeOf((*IHyperstack)(nil)).Elem()
+	for i := 0; i < methods.NumMethod(); i++ {
+		name := methods.Method(i).Name
+		if dryRunReads[name] {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			method := dr.MethodByName(name)
+			args := []reflect.Value{}
+			for j := 0; j < method.Type().NumIn(); j++ {
+				switch in := method.Type().In(j); {
+				case in == contextType:
+					args = append(args, reflect.ValueOf(context.Background()))
+				case in.Kind() == reflect.Int:
+					args = append(args, reflect.ValueOf(volumeID))
+				case in.Kind() == reflect.String:
+					args = append(args, reflect.ValueOf("pvc-2").Convert(in))
+				default:
+					args = append(args, reflect.Zero(in))
+				}
+			}
+
+			out := method.Call(args)
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				t.Errorf("%s() error = %v", name, err)
+			}
+			if calls := hs.Calls(name); calls != 0 {
+				t.Errorf("%s() reached the wrapped client %d times in dry-run mode", name, calls)
+			}
+		})
+	}
+	if vol, _ := hs.Volume(volumeID); vol.Status != fake.StatusInUse || vol.Attachment == nil || !vol.Attachment.Protected {
+		t.Errorf("volume changed in dry-run mode: %+v", vol)
+	}
+}
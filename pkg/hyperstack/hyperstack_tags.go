@@ -0,0 +1,139 @@
+package hyperstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+	"k8s.io/klog/v2"
+
+	util "k8s.io/csi-hyperstack/pkg/utils"
+)
+
+const (
+	volumeCreatedBy = "hyperstack-csi"
+
+	// volumeDescriptionMaxLength is the maximum length of a volume description accepted by the API
+	volumeDescriptionMaxLength = 255
+)
+
+// ErrProvenanceTooLong is returned for tags whose provenance keys alone do not fit into the volume description
+var ErrProvenanceTooLong = errors.New("provenance tags exceed the volume description limit")
+
+// tagAliases shortens the well-known provenance keys so that they fit into the volume description.
+// Provenance tags are never dropped, as orphan collection and namespace quotas count volumes by them.
+var tagAliases = []struct {
+	key   string
+	alias string
+}{
+	{"hyperstack.csi.nexgencloud.com/cluster", "cluster"},
+	{"csi.storage.k8s.io/pv/name", "pv"},
+	{"csi.storage.k8s.io/pvc/namespace", "ns"},
+	{"csi.storage.k8s.io/pvc/name", "pvc"},
+}
+
+// volumeDescriptionData is the structured volume description, which is the only free-form
+// field of a volume the API persists. Provenance tags are kept apart from user tags under their
+// aliases, so that a user tag named like an alias can neither replace nor pose as one.
+type volumeDescriptionData struct {
+	CreatedBy  string            `json:"by"`
+	Provenance map[string]string `json:"p,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// encodeVolumeDescription stores the tags as JSON in the volume description. Provenance tags are
+// added first and must fit, user tags that would push the description over the API limit are dropped.
+func encodeVolumeDescription(tags map[string]string) (string, error) {
+	data := volumeDescriptionData{
+		CreatedBy:  volumeCreatedBy,
+		Provenance: map[string]string{},
+		Tags:       map[string]string{},
+	}
+
+	type entry struct {
+		key   string
+		field map[string]string
+		name  string
+	}
+	aliased := map[string]bool{}
+	for _, a := range tagAliases {
+		aliased[a.key] = true
+		if v, ok := tags[a.key]; ok {
+			data.Provenance[a.alias] = v
+		}
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	if len(encoded) > volumeDescriptionMaxLength {
+		return "", fmt.Errorf("%w: %d characters are over the limit of %d", ErrProvenanceTooLong, len(encoded), volumeDescriptionMaxLength)
+	}
+
+	entries := []entry{}
+	extraKeys := []string{}
+	for k := range tags {
+		if !aliased[k] {
+			extraKeys = append(extraKeys, k)
+		}
+	}
+	sort.Strings(extraKeys)
+	for _, k := range extraKeys {
+		entries = append(entries, entry{key: k, field: data.Tags, name: k})
+	}
+
+	for _, e := range entries {
+		e.field[e.name] = tags[e.key]
+		b, err := json.Marshal(data)
+		if err != nil || len(b) > volumeDescriptionMaxLength {
+			klog.Warningf("encodeVolumeDescription: dropping tag %q, volume description would exceed %d characters", e.key, volumeDescriptionMaxLength)
+			delete(e.field, e.name)
+			continue
+		}
+		encoded = b
+	}
+	return util.Sprintf255("%s", encoded), nil
+}
+
+// decodeVolumeDescription returns the tags stored by encodeVolumeDescription, and false if the
+// description was not written by this driver in the structured format. Provenance keys are only
+// read from the provenance field.
+func decodeVolumeDescription(description string) (map[string]string, bool) {
+	var data volumeDescriptionData
+	if err := json.Unmarshal([]byte(description), &data); err != nil || data.CreatedBy != volumeCreatedBy {
+		return nil, false
+	}
+
+	tags := make(map[string]string, len(data.Provenance)+len(data.Tags))
+	for k, v := range data.Tags {
+		tags[k] = v
+	}
+	for _, a := range tagAliases {
+		delete(tags, a.key)
+		if v, ok := data.Provenance[a.alias]; ok {
+			tags[a.key] = v
+		}
+	}
+	return tags, true
+}
+
+// VolumeDescription returns the description the driver stores on a volume created with the tags,
+// or ErrProvenanceTooLong if the provenance tags do not fit into it
+func VolumeDescription(tags map[string]string) (string, error) {
+	return encodeVolumeDescription(tags)
+}
+
+// VolumeTags returns the tags the driver stored on the volume when creating it. Volumes created
+// before tags were persisted, or not by this driver, return nil.
+func VolumeTags(vol *volume.VolumeFields) map[string]string {
+	if vol == nil || vol.Description == nil {
+		return nil
+	}
+	tags, ok := decodeVolumeDescription(*vol.Description)
+	if !ok {
+		return nil
+	}
+	return tags
+}
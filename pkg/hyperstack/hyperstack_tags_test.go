@@ -0,0 +1,151 @@
+package hyperstack
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/NexGenCloud/hyperstack-sdk-go/lib/volume"
+)
+
+func TestVolumeDescriptionRoundTrip(t *testing.T) {
+	tags := map[string]string{
+		"hyperstack.csi.nexgencloud.com/cluster": "1168",
+		"csi.storage.k8s.io/pvc/name":            "data-postgres-0",
+		"csi.storage.k8s.io/pvc/namespace":       "databases",
+		"csi.storage.k8s.io/pv/name":             "pvc-0b4e9a64-3c4b-4c55-9a0e-3f1f6f0e2a11",
+		"cost-center":                            "42",
+	}
+
+	description, err := encodeVolumeDescription(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(description) > volumeDescriptionMaxLength {
+		t.Fatalf("description exceeds %d characters: %d", volumeDescriptionMaxLength, len(description))
+	}
+
+	vol := &volume.VolumeFields{Description: &description}
+	if !IsCreatedByDriver(vol) {
+		t.Errorf("volume with structured description should be recognised as created by the driver")
+	}
+	if got := VolumeTags(vol); !reflect.DeepEqual(got, tags) {
+		t.Errorf("unexpected tags after round trip: %v", got)
+	}
+}
+
+func TestVolumeDescriptionDropsTagsOverLimit(t *testing.T) {
+	tags := map[string]string{
+		"hyperstack.csi.nexgencloud.com/cluster": "1168",
+		"csi.storage.k8s.io/pvc/name":            "data",
+		"zz-long":                                strings.Repeat("x", 300),
+	}
+
+	description, err := encodeVolumeDescription(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(description) > volumeDescriptionMaxLength {
+		t.Fatalf("description exceeds %d characters: %d", volumeDescriptionMaxLength, len(description))
+	}
+
+	got, ok := decodeVolumeDescription(description)
+	if !ok {
+		t.Fatalf("truncated description should still be valid: %s", description)
+	}
+	if got["hyperstack.csi.nexgencloud.com/cluster"] != "1168" || got["csi.storage.k8s.io/pvc/name"] != "data" {
+		t.Errorf("provenance tags should be kept: %v", got)
+	}
+	if _, ok := got["zz-long"]; ok {
+		t.Errorf("tag over the limit should be dropped: %v", got)
+	}
+}
+
+func TestVolumeDescriptionKeepsProvenanceOverUserTags(t *testing.T) {
+	tags := map[string]string{
+		"hyperstack.csi.nexgencloud.com/cluster": "1168",
+		"csi.storage.k8s.io/pvc/namespace":       "databases",
+		"csi.storage.k8s.io/pvc/name":            strings.Repeat("p", 150),
+		"aa-cost-center":                         strings.Repeat("c", 80),
+	}
+
+	description, err := encodeVolumeDescription(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := VolumeTags(&volume.VolumeFields{Description: &description})
+	for _, key := range []string{"hyperstack.csi.nexgencloud.com/cluster", "csi.storage.k8s.io/pvc/namespace", "csi.storage.k8s.io/pvc/name"} {
+		if got[key] != tags[key] {
+			t.Errorf("provenance tag %s = %q, expected %q", key, got[key], tags[key])
+		}
+	}
+	if _, ok := got["aa-cost-center"]; ok {
+		t.Errorf("user tag over the limit should be dropped: %v", got)
+	}
+
+	tags["csi.storage.k8s.io/pvc/name"] = strings.Repeat("p", 253)
+	if _, err := encodeVolumeDescription(tags); !errors.Is(err, ErrProvenanceTooLong) {
+		t.Errorf("encodeVolumeDescription() error = %v, expected ErrProvenanceTooLong", err)
+	}
+}
+
+func TestVolumeDescriptionUserTagsNamedLikeAliases(t *testing.T) {
+	tags := map[string]string{
+		"hyperstack.csi.nexgencloud.com/cluster": "1168",
+		"csi.storage.k8s.io/pvc/name":            "data",
+		"cluster":                                "2000",
+		"pvc":                                    "spoofed",
+		"ns":                                     "team-a",
+	}
+
+	description, err := encodeVolumeDescription(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := VolumeTags(&volume.VolumeFields{Description: &description})
+	if !reflect.DeepEqual(got, tags) {
+		t.Errorf("unexpected tags after round trip: %v", got)
+	}
+
+	// A user tag spelled like a provenance key does not make a volume look owned
+	spoofed := `{"by":"hyperstack-csi","p":{"cluster":"1168"},"tags":{"hyperstack.csi.nexgencloud.com/cluster":"2000"}}`
+	got = VolumeTags(&volume.VolumeFields{Description: &spoofed})
+	if got["hyperstack.csi.nexgencloud.com/cluster"] != "1168" {
+		t.Errorf("provenance tag overridden by a user tag: %v", got)
+	}
+	untagged := `{"by":"hyperstack-csi","tags":{"cluster":"2000","hyperstack.csi.nexgencloud.com/cluster":"2000"}}`
+	got = VolumeTags(&volume.VolumeFields{Description: &untagged})
+	if _, ok := got["hyperstack.csi.nexgencloud.com/cluster"]; ok || got["cluster"] != "2000" {
+		t.Errorf("user tags should not be read as provenance tags: %v", got)
+	}
+}
+
+func TestIsCreatedByDriver(t *testing.T) {
+	legacy := volumeDescription
+	foreign := "created by hand"
+	foreignJSON := `{"by":"someone-else","tags":{"cluster":"1"}}`
+
+	testCases := []struct {
+		name        string
+		description *string
+		expected    bool
+	}{
+		{"legacy_description", &legacy, true},
+		{"foreign_description", &foreign, false},
+		{"foreign_json_description", &foreignJSON, false},
+		{"nil_description", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vol := &volume.VolumeFields{Description: tc.description}
+			if got := IsCreatedByDriver(vol); got != tc.expected {
+				t.Errorf("IsCreatedByDriver() = %v, expected %v", got, tc.expected)
+			}
+			if tags := VolumeTags(vol); tags != nil {
+				t.Errorf("VolumeTags() should be nil, got %v", tags)
+			}
+		})
+	}
+}
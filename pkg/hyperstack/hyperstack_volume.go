@@ -2,6 +2,7 @@ package hyperstack
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/NexGenCloud/hyperstack-sdk-go/lib/clusters"
@@ -11,26 +12,34 @@ import (
 
 	"golang.org/x/net/context"
 	"k8s.io/csi-hyperstack/pkg/metrics"
+	util "k8s.io/csi-hyperstack/pkg/utils"
+	"k8s.io/klog/v2"
 )
 
-var volumeDescription = "Created by Hyperstack CSI driver"
-
-// GetVolumesByName is a wrapper around ListVolumes that creates a Name filter to act as a GetByName
-// Returns a list of Volume references with the specified name
-func (hs *Hyperstack) GetVolumesByName(ctx context.Context, n string) ([]volume.VolumeFields, error) {
-	if hs.Client == nil {
-		return nil, fmt.Errorf("hyperstack client is not initialized")
+// statusCode returns the HTTP status code of an SDK response, or 0 when no response was received
+func statusCode[R any, PR interface {
+	*R
+	StatusCode() int
+}](resp PR) int {
+	if resp == nil {
+		return 0
 	}
+	return resp.StatusCode()
+}
 
-	client, err := volume.NewClientWithResponses(
-		hs.Client.ApiServer,
-		volume.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+// volumeDescription is the plain description of volumes created before tags were stored in it
+var volumeDescription = "Created by Hyperstack CSI driver"
+
+// ListVolumes returns all volumes visible to the API key
+func (hs *Hyperstack) ListVolumes(ctx context.Context) ([]volume.VolumeFields, error) {
+	client, err := hs.getVolumeClient()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create volume client: %w", err)
 	}
 
+	mc := metrics.NewMetricContext("volume", "list")
 	result, err := client.ListVolumesWithResponse(ctx, &volume.ListVolumesParams{})
+	mc.ObserveRequest(statusCode(result), err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list volumes: %w", err)
 	}
@@ -39,8 +48,7 @@ func (hs *Hyperstack) GetVolumesByName(ctx context.Context, n string) ([]volume.
 		return nil, fmt.Errorf("received nil response from volume list API")
 	}
 
-	fmt.Printf("ListVolumes status code: %d\n", result.StatusCode())
-	fmt.Printf("Response HTTP: %+v\n", result.HTTPResponse)
+	klog.FromContext(ctx).V(4).Info("Listed volumes", "statusCode", result.StatusCode())
 
 	if result.JSON200 == nil {
 		return nil, fmt.Errorf("volume list result is nil (status code: %d)", result.StatusCode())
@@ -53,40 +61,70 @@ func (hs *Hyperstack) GetVolumesByName(ctx context.Context, n string) ([]volume.
 
 	res := []volume.VolumeFields{}
 	for _, row := range *callResult {
+		res = append(res, volume.VolumeFields{
+			Attachments: row.Attachments,
+			Bootable:    row.Bootable,
+			CallbackUrl: row.CallbackUrl,
+			CreatedAt:   row.CreatedAt,
+			Description: row.Description,
+			Environment: row.Environment,
+			Id:          row.Id,
+			ImageId:     row.ImageId,
+			Name:        row.Name,
+			Size:        row.Size,
+			Status:      row.Status,
+			UpdatedAt:   row.UpdatedAt,
+			VolumeType:  row.VolumeType,
+		})
+	}
+	return res, nil
+}
+
+// GetVolumesByName is a wrapper around ListVolumes that creates a Name filter to act as a GetByName
+// Returns a list of Volume references with the specified name
+func (hs *Hyperstack) GetVolumesByName(ctx context.Context, n string) ([]volume.VolumeFields, error) {
+	volumes, err := hs.ListVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []volume.VolumeFields{}
+	for _, row := range volumes {
 		if row.Name != nil && strings.Contains(*row.Name, n) {
-			res = append(res, volume.VolumeFields{
-				Attachments: row.Attachments,
-				Bootable:    row.Bootable,
-				CallbackUrl: row.CallbackUrl,
-				CreatedAt:   row.CreatedAt,
-				Description: row.Description,
-				Environment: row.Environment,
-				Id:          row.Id,
-				ImageId:     row.ImageId,
-				Name:        row.Name,
-				Size:        row.Size,
-				Status:      row.Status,
-				UpdatedAt:   row.UpdatedAt,
-				VolumeType:  row.VolumeType,
-			})
+			klog.FromContext(ctx).V(4).Info("Found volume by name", "name", *row.Name, "tags", VolumeTags(&row))
+			res = append(res, row)
 		}
 	}
 	return res, nil
 }
 
+// IsCreatedByDriver reports whether the volume was provisioned by this driver
+func IsCreatedByDriver(vol *volume.VolumeFields) bool {
+	if vol.Description == nil {
+		return false
+	}
+	if *vol.Description == volumeDescription {
+		return true
+	}
+	_, ok := decodeVolumeDescription(*vol.Description)
+	return ok
+}
+
 // GetVolume retrieves Volume by its ID.
 func (hs *Hyperstack) GetVolume(ctx context.Context, volumeID int) (*volume.VolumeFields, error) {
-	client, err := volume.NewClientWithResponses(
-		hs.Client.ApiServer,
-		volume.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+	client, err := hs.getVolumeClient()
 	if err != nil {
 		return nil, err
 	}
+	mc := metrics.NewMetricContext("volume", "get")
 	result, err := client.FetchVolumeDetailsWithResponse(ctx, volumeID)
+	mc.ObserveRequest(statusCode(result), err)
 	if err != nil {
 		return nil, err
 	}
+	if statusCode(result) == http.StatusNotFound {
+		return nil, fmt.Errorf("volume %d %w", volumeID, util.ErrNotFound)
+	}
 	if result.JSON200 == nil {
 		return nil, fmt.Errorf("volume details response is nil")
 	}
@@ -120,19 +158,21 @@ func (hs *Hyperstack) GetVolume(ctx context.Context, volumeID int) (*volume.Volu
 		UpdatedAt:   result.JSON200.Volume.UpdatedAt,
 		VolumeType:  result.JSON200.Volume.VolumeType,
 	}
+	klog.FromContext(ctx).V(4).Info("Got volume", "volumeID", volumeID, "tags", VolumeTags(&response))
 	return &response, nil
 }
 
 // CreateVolume creates a volume of given size
 func (hs *Hyperstack) CreateVolume(ctx context.Context, name string, size int, vtype, environment string, tags map[string]string) (*volume.VolumeFields, error) {
-	client, err := volume.NewClientWithResponses(
-		hs.Client.ApiServer,
-		volume.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+	client, err := hs.getVolumeClient()
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("Payload for create volume", name, size, vtype, environment, tags)
+	klog.FromContext(ctx).Info("Creating volume", "name", name, "sizeGiB", size, "type", vtype, "environment", environment, "tags", tags)
+	description, err := encodeVolumeDescription(tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe volume %s: %w", name, err)
+	}
 	mc := metrics.NewMetricContext("volume", "create")
 	result, err := client.CreateVolumeWithResponse(
 		ctx,
@@ -141,9 +181,10 @@ func (hs *Hyperstack) CreateVolume(ctx context.Context, name string, size int, v
 			Size:            size,
 			VolumeType:      vtype,
 			EnvironmentName: environment,
-			Description:     &volumeDescription,
+			Description:     &description,
 		},
 	)
+	mc.ObserveRequest(statusCode(result), err)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create volume %s (size: %d GB, type: %s, env: %s): %w", name, size, vtype, environment, err)
@@ -157,12 +198,9 @@ func (hs *Hyperstack) CreateVolume(ctx context.Context, name string, size int, v
 		return nil, fmt.Errorf("received 404 error for volume %s: %v", name, result.JSON404)
 	}
 
-	if mc.ObserveRequest(err) != nil {
-		return nil, fmt.Errorf("metrics observation failed for volume %s: %w", name, err)
-	}
-
 	if result.JSON200 == nil {
-		return nil, fmt.Errorf("volume creation result is nil for volume %s", name)
+		// The body carries the reason of a rejected request, e.g. an exceeded quota or an unknown volume type
+		return nil, fmt.Errorf("volume creation failed for volume %s with status %d: %s", name, result.StatusCode(), strings.TrimSpace(string(result.Body)))
 	}
 
 	vol := result.JSON200.Volume
@@ -174,15 +212,14 @@ func (hs *Hyperstack) CreateVolume(ctx context.Context, name string, size int, v
 }
 
 func (hs *Hyperstack) DeleteVolume(ctx context.Context, volumeID int) error {
-	client, err := volume.NewClientWithResponses(
-		hs.Client.ApiServer,
-		volume.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+	client, err := hs.getVolumeClient()
 	if err != nil {
 		return err
 	}
 
+	mc := metrics.NewMetricContext("volume", "delete")
 	result, err := client.DeleteVolumeWithResponse(ctx, volumeID)
+	mc.ObserveRequest(statusCode(result), err)
 
 	if err != nil {
 		return err
@@ -205,14 +242,12 @@ func (hs *Hyperstack) DeleteVolume(ctx context.Context, volumeID int) error {
 }
 
 func (hs *Hyperstack) AttachVolumeToNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.AttachVolumeFields, error) {
-	client, err := volume_attachment.NewClientWithResponses(
-		hs.Client.ApiServer,
-		volume_attachment.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+	client, err := hs.getVolumeAttachmentClient()
 	if err != nil {
 		return nil, err
 	}
 	var protected = true
+	mc := metrics.NewMetricContext("volume_attachment", "attach")
 	result, err := client.AttachVolumesToVirtualMachineWithResponse(
 		ctx,
 		virtualMachineId,
@@ -221,6 +256,7 @@ func (hs *Hyperstack) AttachVolumeToNode(ctx context.Context, virtualMachineId i
 			Protected: &protected,
 		},
 	)
+	mc.ObserveRequest(statusCode(result), err)
 
 	if err != nil {
 		return nil, err
@@ -254,10 +290,7 @@ func (hs *Hyperstack) AttachVolumeToNode(ctx context.Context, virtualMachineId i
 }
 
 func (hs *Hyperstack) UpdateVolumeAttachment(ctx context.Context, volumeId int) (*volume_attachment.UpdateAVolumeAttachmentResponse, error) {
-	client, err := volume_attachment.NewClientWithResponses(
-		hs.Client.ApiServer,
-		volume_attachment.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+	client, err := hs.getVolumeAttachmentClient()
 	if err != nil {
 		return nil, err
 	}
@@ -266,10 +299,15 @@ func (hs *Hyperstack) UpdateVolumeAttachment(ctx context.Context, volumeId int)
 		return nil, err
 	}
 
+	if getVolume.Attachments == nil || len(*getVolume.Attachments) == 0 {
+		return nil, fmt.Errorf("volume %d has no attachment to update", volumeId)
+	}
 	var volumeAttachmentID = *(*getVolume.Attachments)[0].Id
 
 	var protected = false
+	mc := metrics.NewMetricContext("volume_attachment", "update")
 	result, err := client.UpdateAVolumeAttachmentWithResponse(ctx, volumeAttachmentID, volume_attachment.UpdateVolumeAttachmentPayload{Protected: &protected})
+	mc.ObserveRequest(statusCode(result), err)
 	if result == nil {
 		return nil, fmt.Errorf("received nil response from volume attachment API")
 	}
@@ -294,11 +332,14 @@ func (hs *Hyperstack) UpdateVolumeAttachment(ctx context.Context, volumeId int)
 	return result, nil
 }
 
+// UnprotectVolumeAttachment clears the protection of the attachment of a volume, so that it can be detached
+func (hs *Hyperstack) UnprotectVolumeAttachment(ctx context.Context, volumeID int) error {
+	_, err := hs.UpdateVolumeAttachment(ctx, volumeID)
+	return err
+}
+
 func (hs *Hyperstack) DetachVolumeFromNode(ctx context.Context, virtualMachineId int, volumeID int) (*volume_attachment.DetachVolumes, error) {
-	client, err := volume_attachment.NewClientWithResponses(
-		hs.Client.ApiServer,
-		volume_attachment.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+	client, err := hs.getVolumeAttachmentClient()
 	if err != nil {
 		return nil, err
 	}
@@ -308,6 +349,7 @@ func (hs *Hyperstack) DetachVolumeFromNode(ctx context.Context, virtualMachineId
 		return nil, err
 	}
 
+	mc := metrics.NewMetricContext("volume_attachment", "detach")
 	result, err := client.DetachVolumesFromVirtualMachineWithResponse(
 		ctx,
 		virtualMachineId,
@@ -315,6 +357,7 @@ func (hs *Hyperstack) DetachVolumeFromNode(ctx context.Context, virtualMachineId
 			VolumeIds: &[]int{volumeID},
 		},
 	)
+	mc.ObserveRequest(statusCode(result), err)
 
 	if err != nil {
 		return nil, err
@@ -348,16 +391,15 @@ func (hs *Hyperstack) DetachVolumeFromNode(ctx context.Context, virtualMachineId
 }
 
 func (hs *Hyperstack) GetClusterDetail(ctx context.Context, clusterID int) (*clusters.ClusterFields, error) {
-	fmt.Printf("Getting cluster detail for cluster ID: %d\n", clusterID)
-	client, err := clusters.NewClientWithResponses(
-		hs.Client.ApiServer,
-		clusters.WithRequestEditorFn(hs.Client.GetAddHeadersFn()),
-	)
+	klog.FromContext(ctx).V(4).Info("Getting cluster detail", "clusterID", clusterID)
+	client, err := hs.getClustersClient()
 	if err != nil {
 		return nil, err
 	}
 
+	mc := metrics.NewMetricContext("cluster", "get")
 	result, err := client.GettingClusterDetailWithResponse(ctx, clusterID)
+	mc.ObserveRequest(statusCode(result), err)
 	if err != nil {
 		return nil, err
 	}
@@ -2,197 +2,306 @@ package hyperstack
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"net/http"
 	"testing"
+	"time"
 
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"k8s.io/csi-hyperstack/pkg/hyperstack/fake"
+	util "k8s.io/csi-hyperstack/pkg/utils"
 )
 
-// TestGetVolume tests the GetVolume method with different volume IDs
-func TestGetVolume(t *testing.T) {
-	// Get API credentials from environment variables
-	apiKey := ""
-	apiServer := ""
+const testAPIKey = "test-api-key"
 
-	if apiKey == "" || apiServer == "" {
-		t.Skip("Skipping test: HYPERSTACK_API_KEY or HYPERSTACK_API_SERVER environment variables not set")
+// newTestHyperstack returns a Hyperstack talking to a fresh fake API server
+func newTestHyperstack(t *testing.T) (*Hyperstack, *fake.Server) {
+	srv := fake.NewServer(testAPIKey)
+	t.Cleanup(srv.Close)
+	client, err := NewHyperstackClientWithOpts(testAPIKey, srv.URL(), ClientOpts{})
+	if err != nil {
+		t.Fatal(err)
 	}
+	return &Hyperstack{Client: client}, srv
+}
 
-	// Create a new Hyperstack client
-	client := NewHyperstackClient(apiKey, apiServer)
-	hs := &Hyperstack{
-		Client: client,
-	}
+// TestGetVolume tests the GetVolume method with different volume IDs
+func TestGetVolume(t *testing.T) {
+	hs, srv := newTestHyperstack(t)
+	available := srv.AddVolume(fake.Volume{Name: "pvc-available", Size: 10, VolumeType: "Cloud-SSD", Environment: "CANADA-1"})
+	inUse := srv.AddVolume(fake.Volume{
+		Name:        "pvc-in-use",
+		Size:        20,
+		VolumeType:  "Cloud-SSD",
+		Environment: "CANADA-1",
+		Status:      fake.StatusInUse,
+		Attachment:  &fake.Attachment{InstanceID: 42, Device: "/dev/vdb"},
+	})
 
-	// Test cases with different volume IDs
 	testCases := []struct {
-		name     string
-		volumeID int
+		name        string
+		volumeID    int
+		status      string
+		attachments int
+		expectErr   error
 	}{
-		{"valid_volume_id", 903},
+		{"available_volume", available, fake.StatusAvailable, 0, nil},
+		{"attached_volume", inUse, fake.StatusInUse, 1, nil},
+		{"non_existent_id", 99999, "", 0, util.ErrNotFound},
 	}
 
 	ctx := context.Background()
-
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			fmt.Printf("Testing GetVolume with volumeID: %d\n", tc.volumeID)
-
 			result, err := hs.GetVolume(ctx, tc.volumeID)
-			if err != nil {
-				t.Errorf("Error for volumeID %v\n", err)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Errorf("expected error %v for volumeID %d, got %v", tc.expectErr, tc.volumeID, err)
+				}
 				return
 			}
-			fmt.Printf("Result: %+v\n", protosanitizer.StripSecrets(*result))
-
-			if result == nil {
-				fmt.Printf("Volume %d not found (expected)\n", tc.volumeID)
-				return
-			} else {
-				// fmt.Printf("Id: %+v\n", *result.Id)
-				// fmt.Printf("Name: %+v\n", *result.Name)
-				// fmt.Printf("Size: %+v\n", *result.Size)
-				// fmt.Printf("Status: %+v\n", *result.Status)
-				// fmt.Printf("Environment: %+v\n", *result.Environment.Name)
-				// fmt.Printf("CreatedAt: %+v\n", *result.CreatedAt)
-				// fmt.Printf("UpdatedAt: %+v\n", *result.UpdatedAt)
-				// fmt.Printf("VolumeType: %+v\n", *result.VolumeType)
-				// fmt.Printf("Available: %+v\n", *result.Status)
-				fmt.Printf("Attachment: %+v\n", protosanitizer.StripSecrets(*result.Attachments))
-				return
+			if err != nil {
+				t.Fatalf("unexpected error for volumeID %d: %v", tc.volumeID, err)
 			}
+			if *result.Id != tc.volumeID || *result.Status != tc.status || len(*result.Attachments) != tc.attachments {
+				t.Errorf("unexpected volume: id %d, status %s, %d attachments", *result.Id, *result.Status, len(*result.Attachments))
+			}
+		})
+	}
+}
 
-			// if result.Name != nil {
-			// 	fmt.Printf("Volume name: %s\n", *result.Name)
-			// }
+func TestCreateVolume(t *testing.T) {
+	hs, srv := newTestHyperstack(t)
+	srv.SetCreateDelay(50 * time.Millisecond)
+	ctx := context.Background()
 
-			// if result.Size != nil {
-			// 	fmt.Printf("Volume size: %d GB\n", *result.Size)
-			// }
-		})
+	tags := map[string]string{"hyperstack.csi.nexgencloud.com/cluster": "1168"}
+	vol, err := hs.CreateVolume(ctx, "pvc-new", 10, "Cloud-SSD", "CANADA-1", tags)
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if *vol.Status != fake.StatusCreating {
+		t.Errorf("new volume has status %s, expected %s", *vol.Status, fake.StatusCreating)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	got, err := hs.GetVolume(ctx, *vol.Id)
+	if err != nil {
+		t.Fatalf("GetVolume() error = %v", err)
+	}
+	if *got.Status != fake.StatusAvailable {
+		t.Errorf("volume has status %s after the create delay, expected %s", *got.Status, fake.StatusAvailable)
+	}
+	if VolumeTags(got)["hyperstack.csi.nexgencloud.com/cluster"] != "1168" {
+		t.Errorf("tags were not stored in the description: %s", *got.Description)
+	}
+
+	byName, err := hs.GetVolumesByName(ctx, "pvc-new")
+	if err != nil || len(byName) != 1 {
+		t.Errorf("GetVolumesByName() = %d volumes, %v, expected 1", len(byName), err)
 	}
 }
 
-func TestAttachVolumeToNode(t *testing.T) {
-	apiKey := "ca172084-30c8-419d-9148-b88822a992d6"
-	apiServer := "https://staging-infrahub-api.internal.ngbackend.cloud/v1"
+func TestDeleteVolume(t *testing.T) {
+	hs, srv := newTestHyperstack(t)
+	available := srv.AddVolume(fake.Volume{Name: "pvc-available", Size: 10})
+	inUse := srv.AddVolume(fake.Volume{Name: "pvc-in-use", Size: 10, Status: fake.StatusInUse, Attachment: &fake.Attachment{InstanceID: 1}})
 
-	client := NewHyperstackClient(apiKey, apiServer)
-	hs := &Hyperstack{
-		Client: client,
+	testCases := []struct {
+		name      string
+		volumeID  int
+		expectErr bool
+	}{
+		{"available_volume", available, false},
+		{"in_use_volume", inUse, true},
+		{"non_existent_id", 99999, true},
 	}
 
+	ctx := context.Background()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := hs.DeleteVolume(ctx, tc.volumeID)
+			if (err != nil) != tc.expectErr {
+				t.Errorf("DeleteVolume() error = %v, expectErr %v", err, tc.expectErr)
+			}
+		})
+	}
+	if _, ok := srv.GetVolume(available); ok {
+		t.Errorf("volume %d should have been deleted", available)
+	}
+}
+
+func TestAttachVolumeToNode(t *testing.T) {
+	hs, srv := newTestHyperstack(t)
+	available := srv.AddVolume(fake.Volume{Name: "pvc-available", Size: 10})
+	srv.SetCreateDelay(time.Hour)
+	creating := srv.AddVolume(fake.Volume{Name: "pvc-creating", Size: 10, Status: fake.StatusCreating})
+
 	ctx := context.Background()
 	testCases := []struct {
 		name      string
 		vmID      int
 		volumeID  int
-		expectNil bool
+		expectErr bool
 	}{
-		{"valid_volume_id", 268040, 886, false},
-		// {"non_existent_id", 1, 99999, true},
-		// {"empty_id", 1, 0, true},
+		{"valid_volume_id", 268040, available, false},
+		{"already_attached", 268040, available, true},
+		{"creating_volume", 268040, creating, true},
+		{"non_existent_id", 268040, 99999, true},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			fmt.Printf("Testing AttachVolumeToNode with volumeID: %d\n", tc.volumeID)
-
 			result, err := hs.AttachVolumeToNode(ctx, tc.vmID, tc.volumeID)
-			fmt.Printf("Result: %+v\n", result)
-			if err != nil {
-				t.Errorf("Error for volumeID %d: %v\n", tc.volumeID, err)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error for volumeID %d", tc.volumeID)
+				}
 				return
 			}
-			fmt.Printf("Result: %+v\n", result)
+			if err != nil {
+				t.Fatalf("unexpected error for volumeID %d: %v", tc.volumeID, err)
+			}
+			if *result.InstanceId != tc.vmID || *result.Device == "" || !*result.Protected {
+				t.Errorf("unexpected attachment: %+v", result)
+			}
+			if v, _ := srv.GetVolume(tc.volumeID); v.Status != fake.StatusInUse {
+				t.Errorf("volume has status %s after attach, expected %s", v.Status, fake.StatusInUse)
+			}
 		})
 	}
 }
 
 func TestDetachVolumeFromNode(t *testing.T) {
-	apiKey := "ca172084-30c8-419d-9148-b88822a992d6"
-	apiServer := "https://staging-infrahub-api.internal.ngbackend.cloud/v1"
-
-	// Create a new Hyperstack client
-	client := NewHyperstackClient(apiKey, apiServer)
-	hs := &Hyperstack{
-		Client: client,
-	}
+	hs, srv := newTestHyperstack(t)
+	protected := srv.AddVolume(fake.Volume{
+		Name:       "pvc-protected",
+		Size:       10,
+		Status:     fake.StatusInUse,
+		Attachment: &fake.Attachment{InstanceID: 268047, Device: "/dev/vdb", Protected: true},
+	})
+	available := srv.AddVolume(fake.Volume{Name: "pvc-available", Size: 10})
 
 	ctx := context.Background()
 	testCases := []struct {
 		name      string
 		vmID      int
 		volumeID  int
-		expectNil bool
+		expectErr bool
 	}{
-		{"valid_volume_id", 268047, 903, false},
-		// {"non_existent_id", 1, 99999, true},
-		// {"empty_id", 1, 0, true},
+		{"protected_attachment", 268047, protected, false},
+		{"not_attached", 268047, available, true},
+		{"non_existent_id", 268047, 99999, true},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			fmt.Printf("Testing DetachVolumeFromNode with volumeID: %d\n", tc.volumeID)
-
 			result, err := hs.DetachVolumeFromNode(ctx, tc.vmID, tc.volumeID)
-			if err != nil {
-				if !tc.expectNil {
-					t.Errorf("Unexpected error for volumeID %d: %v\n", tc.volumeID, err)
-				} else {
-					fmt.Printf("Expected error for volumeID %d: %v\n", tc.volumeID, err)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error for volumeID %d", tc.volumeID)
 				}
 				return
 			}
-			if result == nil {
-				if !tc.expectNil {
-					t.Errorf("Unexpected nil result for volumeID %d\n", tc.volumeID)
-				} else {
-					fmt.Printf("Expected nil result for volumeID %d\n", tc.volumeID)
-				}
-				return
+			if err != nil {
+				t.Fatalf("unexpected error for volumeID %d: %v", tc.volumeID, err)
 			}
-			if tc.expectNil {
-				t.Errorf("Expected nil result for volumeID %d, but got a result\n", tc.volumeID)
-			} else {
-				fmt.Printf("Detach successful for volumeID %d: %+v\n", tc.volumeID, result)
+			if !*result.Status || len(*result.VolumeAttachments) != 1 {
+				t.Errorf("unexpected detach result: %+v", result)
+			}
+			if v, _ := srv.GetVolume(tc.volumeID); v.Status != fake.StatusAvailable || v.Attachment != nil {
+				t.Errorf("volume has status %s after detach, expected %s", v.Status, fake.StatusAvailable)
 			}
 		})
 	}
 }
 
 func TestGetClusterId(t *testing.T) {
-	apiKey := "ca172084-30c8-419d-9148-b88822a992d6"
-	apiServer := "https://staging-infrahub-api.internal.ngbackend.cloud/v1"
-
-	client := NewHyperstackClient(apiKey, apiServer)
-	hs := &Hyperstack{
-		Client: client,
-	}
+	hs, srv := newTestHyperstack(t)
+	srv.AddCluster(fake.Cluster{ID: 1168, Name: "test-cluster", Environment: "CANADA-1"})
 
 	ctx := context.Background()
 	testCases := []struct {
 		name      string
 		clusterID int
-		expectNil bool
+		expectErr bool
 	}{
 		{"valid_cluster_id", 1168, false},
-		// {"valid_cluster_id", 2, true},
-		// {"non_existent_id", 3, true},
+		{"non_existent_id", 3, true},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			fmt.Printf("Testing GetClusterId with clusterID: %d\n", tc.clusterID)
 			result, err := hs.GetClusterDetail(ctx, tc.clusterID)
-			if err != nil {
-				t.Errorf("Error getting cluster ID: %v\n", err)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error for clusterID %d", tc.clusterID)
+				}
 				return
 			}
-			fmt.Printf("Cluster ID: %+v\n", *result.Id)
-			fmt.Printf("Cluster Name: %+v\n", *result.Name)
-			fmt.Printf("Cluster Environment: %+v\n", *result.EnvironmentName)
+			if err != nil {
+				t.Fatalf("Error getting cluster ID: %v", err)
+			}
+			if *result.Id != tc.clusterID || *result.EnvironmentName != "CANADA-1" {
+				t.Errorf("unexpected cluster: id %d, environment %s", *result.Id, *result.EnvironmentName)
+			}
 		})
 	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("invalid_api_key", func(t *testing.T) {
+		srv := fake.NewServer(testAPIKey)
+		defer srv.Close()
+		client, err := NewHyperstackClientWithOpts("wrong-key", srv.URL(), ClientOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hs := &Hyperstack{Client: client}
+		if _, err := hs.ListVolumes(ctx); err == nil {
+			t.Errorf("expected an error with an invalid API key")
+		}
+	})
+
+	t.Run("injected_error", func(t *testing.T) {
+		hs, srv := newTestHyperstack(t)
+		srv.InjectError(fake.OpListVolumes, http.StatusInternalServerError, 1)
+		if _, err := hs.ListVolumes(ctx); err == nil {
+			t.Errorf("expected the injected error")
+		}
+		if _, err := hs.ListVolumes(ctx); err != nil {
+			t.Errorf("injected error should only apply once: %v", err)
+		}
+	})
+
+	t.Run("retried_error", func(t *testing.T) {
+		srv := fake.NewServer(testAPIKey)
+		defer srv.Close()
+		client, err := NewHyperstackClientWithOpts(testAPIKey, srv.URL(), ClientOpts{MaxRetries: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hs := &Hyperstack{Client: client}
+		transport := client.Client.Transport.(*retryTransport)
+		transport.baseDelay = time.Millisecond
+		srv.AddCluster(fake.Cluster{ID: 1, Environment: "CANADA-1"})
+		srv.InjectError(fake.OpGetCluster, http.StatusServiceUnavailable, 2)
+		if _, err := hs.GetClusterDetail(ctx, 1); err != nil {
+			t.Errorf("GetClusterDetail() should succeed after retries: %v", err)
+		}
+		if calls := srv.Calls(fake.OpGetCluster); calls != 3 {
+			t.Errorf("GetClusterDetail() called the API %d times, expected 3", calls)
+		}
+	})
 
+	t.Run("latency_exceeds_deadline", func(t *testing.T) {
+		hs, srv := newTestHyperstack(t)
+		srv.SetLatency(100 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, err := hs.ListVolumes(ctx); err == nil {
+			t.Errorf("expected a deadline error")
+		}
+	})
 }
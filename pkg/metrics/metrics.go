@@ -1,22 +1,23 @@
 package metrics
 
 import (
+	"fmt"
 	"time"
 
 	"k8s.io/component-base/metrics"
 )
 
-type OpenstackMetrics struct {
+type RequestMetrics struct {
 	Duration *metrics.HistogramVec
 	Total    *metrics.CounterVec
 	Errors   *metrics.CounterVec
 }
 
-// MetricContext indicates the context for OpenStack metrics.
+// MetricContext indicates the context for Hyperstack metrics.
 type MetricContext struct {
 	Start      time.Time
 	Attributes []string
-	Metrics    *OpenstackMetrics
+	Metrics    *RequestMetrics
 }
 
 // NewMetricContext creates a new MetricContext.
@@ -27,8 +28,8 @@ func NewMetricContext(resource string, request string) *MetricContext {
 	}
 }
 
-// ObserveRequest records the request latency and counts the errors.
-func (mc *MetricContext) Observe(om *OpenstackMetrics, err error) error {
+// Observe records the request latency and counts the errors.
+func (mc *MetricContext) Observe(om *RequestMetrics, err error) error {
 	if om == nil {
 		// mc.RequestMetrics not set, ignore this request
 		return nil
@@ -43,8 +44,20 @@ func (mc *MetricContext) Observe(om *OpenstackMetrics, err error) error {
 	return err
 }
 
+// StatusClass returns the status class label of an HTTP status code, e.g. "2xx".
+// A zero status code means no response was received and is labelled "error".
+func StatusClass(statusCode int) string {
+	if statusCode <= 0 {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
 func RegisterMetrics(component string) {
 	doRegisterAPIMetrics()
+	doRegisterGRPCMetrics()
+	doRegisterNodeMetrics()
+	doRegisterControllerMetrics()
 	if component == "occm" {
 		doRegisterOccmMetrics()
 	}
@@ -1,46 +1,149 @@
 package metrics
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"k8s.io/component-base/metrics"
 	"k8s.io/component-base/metrics/legacyregistry"
 )
 
+// deprecatedMetricsVersion is the release that replaced the metrics inherited from the OpenStack cloud provider
+const deprecatedMetricsVersion = "0.0.8"
+
 var (
-	APIRequestMetrics = &OpenstackMetrics{
+	apiRequestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name: "hyperstack_csi_api_request_duration_seconds",
+			Help: "Latency of a Hyperstack API call by operation and HTTP status class",
+		}, []string{"operation", "status_class"})
+	apiRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_api_requests_total",
+			Help: "Total number of Hyperstack API calls by operation and HTTP status class",
+		}, []string{"operation", "status_class"})
+
+	// APIRequestMetrics holds the OpenStack named API metrics, kept for one deprecation period
+	APIRequestMetrics = &RequestMetrics{
 		Duration: metrics.NewHistogramVec(
 			&metrics.HistogramOpts{
-				Name: "openstack_api_request_duration_seconds",
-				Help: "Latency of an OpenStack API call",
+				Name:              "openstack_api_request_duration_seconds",
+				Help:              "Latency of a Hyperstack API call, use hyperstack_csi_api_request_duration_seconds instead",
+				DeprecatedVersion: deprecatedMetricsVersion,
 			}, []string{"request"}),
 		Total: metrics.NewCounterVec(
 			&metrics.CounterOpts{
-				Name: "openstack_api_requests_total",
-				Help: "Total number of OpenStack API calls",
+				Name:              "openstack_api_requests_total",
+				Help:              "Total number of Hyperstack API calls, use hyperstack_csi_api_requests_total instead",
+				DeprecatedVersion: deprecatedMetricsVersion,
 			}, []string{"request"}),
 		Errors: metrics.NewCounterVec(
 			&metrics.CounterOpts{
-				Name: "openstack_api_request_errors_total",
-				Help: "Total number of errors for an OpenStack API call",
+				Name:              "openstack_api_request_errors_total",
+				Help:              "Total number of failed Hyperstack API calls, use hyperstack_csi_api_requests_total instead",
+				DeprecatedVersion: deprecatedMetricsVersion,
 			}, []string{"request"}),
 	}
+
+	apiRequestRetries = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_api_request_retries_total",
+			Help: "Total number of retried Hyperstack API requests by reason (error or HTTP status code)",
+		}, []string{"reason"})
+	apiClientThrottled = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_api_client_throttled_total",
+			Help: "Total number of Hyperstack API requests delayed by the client-side rate limit",
+		})
+	apiClientThrottleSeconds = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_api_client_throttle_seconds_total",
+			Help: "Total time Hyperstack API requests waited for the client-side rate limit",
+		})
+
+	// apiCredentialsModified holds the modification time of the loaded API key file in Unix nanoseconds
+	apiCredentialsModified atomic.Int64
+	// apiCredentialsReloaded holds the time of the last successful load in Unix nanoseconds. The client
+	// loads the key before the metrics are registered, and a gauge ignores values set until then.
+	apiCredentialsReloaded   atomic.Int64
+	apiCredentialsLastReload = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name: "hyperstack_csi_api_credentials_last_reload_timestamp_seconds",
+			Help: "Unix time of the last successful reload of the Hyperstack API key file",
+		})
+	apiCredentialsAge = metrics.NewGaugeFunc(
+		&metrics.GaugeOpts{
+			Name: "hyperstack_csi_api_credentials_age_seconds",
+			Help: "Time since the loaded Hyperstack API key file was last modified",
+		}, func() float64 {
+			modified := apiCredentialsModified.Load()
+			if modified == 0 {
+				return 0
+			}
+			return time.Since(time.Unix(0, modified)).Seconds()
+		})
 )
 
-// ObserveRequest records the request latency and counts the errors.
-func (mc *MetricContext) ObserveRequest(err error) error {
-	return mc.Observe(APIRequestMetrics, err)
+// ObserveAPIRetry counts a retried Hyperstack API request
+func ObserveAPIRetry(reason string) {
+	apiRequestRetries.WithLabelValues(reason).Inc()
+}
+
+// ObserveAPIThrottle counts a Hyperstack API request delayed by the client-side rate limit
+func ObserveAPIThrottle(delay time.Duration) {
+	apiClientThrottled.Inc()
+	apiClientThrottleSeconds.Add(delay.Seconds())
+}
+
+// ObserveRequest records the latency of a Hyperstack API call and the status code of its response.
+// statusCode is 0 when no response was received. A non-2xx response counts as an error for the deprecated metrics.
+func (mc *MetricContext) ObserveRequest(statusCode int, err error) error {
+	labels := append(append([]string{}, mc.Attributes...), StatusClass(statusCode))
+	apiRequestDuration.WithLabelValues(labels...).Observe(time.Since(mc.Start).Seconds())
+	apiRequests.WithLabelValues(labels...).Inc()
+
+	failed := err
+	if failed == nil && (statusCode < 200 || statusCode > 299) {
+		failed = fmt.Errorf("unexpected status code %d", statusCode)
+	}
+	mc.Observe(APIRequestMetrics, failed)
+	return err
+}
+
+// ObserveAPICredentialsReload records a successful load of the API key file modified at the given time
+func ObserveAPICredentialsReload(modified time.Time) {
+	apiCredentialsModified.Store(modified.UnixNano())
+	apiCredentialsReloaded.Store(time.Now().UnixNano())
+	setAPICredentialsLastReload()
+}
+
+// setAPICredentialsLastReload sets the last reload gauge from the last observed reload, if any
+func setAPICredentialsLastReload() {
+	if reloaded := apiCredentialsReloaded.Load(); reloaded != 0 {
+		apiCredentialsLastReload.Set(float64(reloaded) / float64(time.Second))
+	}
 }
 
 var registerAPIMetrics sync.Once
 
-// RegisterMetrics registers OpenStack metrics.
+// doRegisterAPIMetrics registers Hyperstack API metrics.
 func doRegisterAPIMetrics() {
 	registerAPIMetrics.Do(func() {
 		legacyregistry.MustRegister(
+			apiRequestDuration,
+			apiRequests,
 			APIRequestMetrics.Duration,
 			APIRequestMetrics.Total,
 			APIRequestMetrics.Errors,
+			apiRequestRetries,
+			apiClientThrottled,
+			apiClientThrottleSeconds,
+			apiCredentialsLastReload,
 		)
+		// A GaugeFunc is a plain Prometheus collector and bypasses the stability framework
+		legacyregistry.RawMustRegister(apiCredentialsAge)
+		setAPICredentialsLastReload()
 	})
 }
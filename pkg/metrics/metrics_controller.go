@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	orphanedVolumes = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name: "hyperstack_csi_orphaned_volumes",
+			Help: "Number of driver-owned volumes of this cluster without a matching PersistentVolume",
+		})
+	orphanedVolumeDeletions = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_orphaned_volume_deletions_total",
+			Help: "Total number of orphaned volume deletions attempted by the garbage collector",
+		}, []string{"result"})
+)
+
+// SetOrphanedVolumes records the number of orphaned volumes found by the last garbage collection pass
+func SetOrphanedVolumes(count int) {
+	orphanedVolumes.Set(float64(count))
+}
+
+// ObserveOrphanedVolumeDeletion counts an orphaned volume deletion and its outcome
+func ObserveOrphanedVolumeDeletion(err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	orphanedVolumeDeletions.WithLabelValues(result).Inc()
+}
+
+var registerControllerMetrics sync.Once
+
+// doRegisterControllerMetrics registers controller service metrics.
+func doRegisterControllerMetrics() {
+	registerControllerMetrics.Do(func() {
+		legacyregistry.MustRegister(
+			orphanedVolumes,
+			orphanedVolumeDeletions,
+		)
+	})
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	grpcRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_grpc_requests_total",
+			Help: "Total number of CSI gRPC calls by method and gRPC status code",
+		}, []string{"method", "code"})
+	grpcRequestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:    "hyperstack_csi_grpc_request_duration_seconds",
+			Help:    "Latency of a CSI gRPC call by method",
+			Buckets: []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"method"})
+	grpcRequestsInFlight = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "hyperstack_csi_grpc_requests_in_flight",
+			Help: "Number of CSI gRPC calls currently being served by method",
+		}, []string{"method"})
+	grpcPanics = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_grpc_panics_total",
+			Help: "Total number of CSI gRPC calls that panicked by method",
+		}, []string{"method"})
+)
+
+// GRPCRequestStarted counts a CSI gRPC call as in flight
+func GRPCRequestStarted(method string) {
+	grpcRequestsInFlight.WithLabelValues(method).Inc()
+}
+
+// ObserveGRPCRequest records a finished CSI gRPC call with its status code and latency
+func ObserveGRPCRequest(method string, code string, duration time.Duration) {
+	grpcRequestsInFlight.WithLabelValues(method).Dec()
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcRequestDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// GRPCPanic counts a CSI gRPC call that panicked
+func GRPCPanic(method string) {
+	grpcPanics.WithLabelValues(method).Inc()
+}
+
+var registerGRPCMetrics sync.Once
+
+// doRegisterGRPCMetrics registers CSI gRPC server metrics.
+func doRegisterGRPCMetrics() {
+	registerGRPCMetrics.Do(func() {
+		legacyregistry.MustRegister(
+			grpcRequests,
+			grpcRequestDuration,
+			grpcRequestsInFlight,
+			grpcPanics,
+		)
+	})
+}
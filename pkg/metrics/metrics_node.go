@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	nodeStaleMounts = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name: "hyperstack_csi_node_stale_mounts_total",
+			Help: "Total number of stale staging and publish mounts found by the node reconciler",
+		}, []string{"reason", "result"})
+)
+
+// ObserveStaleMount counts a stale mount found by the node reconciler and the outcome of its cleanup
+func ObserveStaleMount(reason string, result string) {
+	nodeStaleMounts.WithLabelValues(reason, result).Inc()
+}
+
+var registerNodeMetrics sync.Once
+
+// doRegisterNodeMetrics registers node service metrics.
+func doRegisterNodeMetrics() {
+	registerNodeMetrics.Do(func() {
+		legacyregistry.MustRegister(
+			nodeStaleMounts,
+		)
+	})
+}
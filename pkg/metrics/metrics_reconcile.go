@@ -8,22 +8,26 @@ import (
 )
 
 var (
-	occmReconcileMetrics = &OpenstackMetrics{
+	// occmReconcileMetrics holds the OpenStack cloud controller manager metrics, kept for one deprecation period
+	occmReconcileMetrics = &RequestMetrics{
 		Duration: metrics.NewHistogramVec(
 			&metrics.HistogramOpts{
-				Name:    "cloudprovider_openstack_reconcile_duration_seconds",
-				Help:    "Time taken by various parts of OpenStack cloud controller manager reconciliation loops",
-				Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1.0, 2.5, 5.0, 7.5, 10.0, 12.5, 15.0, 17.5, 20.0, 22.5, 25.0, 27.5, 30.0, 50.0, 75.0, 100.0, 1000.0},
+				Name:              "cloudprovider_openstack_reconcile_duration_seconds",
+				Help:              "Time taken by various parts of OpenStack cloud controller manager reconciliation loops",
+				Buckets:           []float64{0.01, 0.05, 0.1, 0.5, 1.0, 2.5, 5.0, 7.5, 10.0, 12.5, 15.0, 17.5, 20.0, 22.5, 25.0, 27.5, 30.0, 50.0, 75.0, 100.0, 1000.0},
+				DeprecatedVersion: deprecatedMetricsVersion,
 			}, []string{"operation"}),
 		Total: metrics.NewCounterVec(
 			&metrics.CounterOpts{
-				Name: "cloudprovider_openstack_reconcile_total",
-				Help: "Total number of OpenStack cloud controller manager reconciliations",
+				Name:              "cloudprovider_openstack_reconcile_total",
+				Help:              "Total number of OpenStack cloud controller manager reconciliations",
+				DeprecatedVersion: deprecatedMetricsVersion,
 			}, []string{"operation"}),
 		Errors: metrics.NewCounterVec(
 			&metrics.CounterOpts{
-				Name: "cloudprovider_openstack_reconcile_errors_total",
-				Help: "Total number of OpenStack cloud controller manager reconciliation errors",
+				Name:              "cloudprovider_openstack_reconcile_errors_total",
+				Help:              "Total number of OpenStack cloud controller manager reconciliation errors",
+				DeprecatedVersion: deprecatedMetricsVersion,
 			}, []string{"operation"}),
 	}
 )
@@ -35,7 +39,7 @@ func (mc *MetricContext) ObserveReconcile(err error) error {
 
 var registerOccmMetrics sync.Once
 
-// RegisterMetrics registers OpenStack metrics.
+// doRegisterOccmMetrics registers OpenStack cloud controller manager metrics.
 func doRegisterOccmMetrics() {
 	registerOccmMetrics.Do(func() {
 		legacyregistry.MustRegister(
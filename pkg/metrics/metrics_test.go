@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestStatusClass(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statusCode int
+		expected   string
+	}{
+		{"ok", 200, "2xx"},
+		{"not_found", 404, "4xx"},
+		{"too_many_requests", 429, "4xx"},
+		{"unavailable", 503, "5xx"},
+		{"no_response", 0, "error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StatusClass(tc.statusCode); got != tc.expected {
+				t.Errorf("StatusClass(%d) = %s, expected %s", tc.statusCode, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestObserveGRPCRequest(t *testing.T) {
+	RegisterMetrics("hyperstack-csi")
+
+	GRPCRequestStarted("CreateVolume")
+	if inFlight, _ := testutil.GetGaugeMetricValue(grpcRequestsInFlight.WithLabelValues("CreateVolume")); inFlight != 1 {
+		t.Errorf("in-flight CreateVolume calls = %v, expected 1", inFlight)
+	}
+	ObserveGRPCRequest("CreateVolume", "Internal", 2*time.Second)
+
+	if inFlight, _ := testutil.GetGaugeMetricValue(grpcRequestsInFlight.WithLabelValues("CreateVolume")); inFlight != 0 {
+		t.Errorf("in-flight CreateVolume calls = %v, expected 0", inFlight)
+	}
+	if count, _ := testutil.GetCounterMetricValue(grpcRequests.WithLabelValues("CreateVolume", "Internal")); count != 1 {
+		t.Errorf("CreateVolume Internal calls = %v, expected 1", count)
+	}
+	if observed, _ := testutil.GetHistogramMetricCount(grpcRequestDuration.WithLabelValues("CreateVolume")); observed != 1 {
+		t.Errorf("CreateVolume latency observations = %v, expected 1", observed)
+	}
+}
+
+func TestObserveAPICredentialsReload(t *testing.T) {
+	RegisterMetrics("hyperstack-csi")
+
+	ObserveAPICredentialsReload(time.Now().Add(-time.Hour))
+
+	if reloaded, _ := testutil.GetGaugeMetricValue(apiCredentialsLastReload); time.Since(time.Unix(int64(reloaded), 0)) > time.Minute {
+		t.Errorf("last reload timestamp = %v, expected the current time", reloaded)
+	}
+	age := &dto.Metric{}
+	if err := apiCredentialsAge.Write(age); err != nil {
+		t.Fatal(err)
+	}
+	if got := age.GetGauge().GetValue(); got < time.Hour.Seconds() || got > (time.Hour+time.Minute).Seconds() {
+		t.Errorf("credentials age = %vs, expected about an hour", got)
+	}
+}
+
+func TestObserveAPICredentialsReloadBeforeRegistering(t *testing.T) {
+	registered := apiCredentialsLastReload
+	t.Cleanup(func() { apiCredentialsLastReload = registered })
+	apiCredentialsLastReload = metrics.NewGauge(&metrics.GaugeOpts{
+		Name: "test_api_credentials_last_reload_timestamp_seconds",
+		Help: "Unix time of the last successful reload of the Hyperstack API key file",
+	})
+
+	// The client loads the API key file before the driver registers the metrics
+	ObserveAPICredentialsReload(time.Now())
+	metrics.NewKubeRegistry().MustRegister(apiCredentialsLastReload)
+	if reloaded, _ := testutil.GetGaugeMetricValue(apiCredentialsLastReload); reloaded != 0 {
+		t.Fatalf("last reload timestamp = %v before it was set again, expected the gauge to drop it", reloaded)
+	}
+
+	setAPICredentialsLastReload()
+	if reloaded, _ := testutil.GetGaugeMetricValue(apiCredentialsLastReload); time.Since(time.Unix(int64(reloaded), 0)) > time.Minute {
+		t.Errorf("last reload timestamp = %v, expected the time of the reload", reloaded)
+	}
+}
+
+func TestObserveReconcileDeprecated(t *testing.T) {
+	RegisterMetrics("occm")
+
+	if err := NewMetricContext("node", "update").ObserveReconcile(errors.New("failed")); err == nil {
+		t.Error("ObserveReconcile() should return the observed error")
+	}
+	if count, _ := testutil.GetCounterMetricValue(occmReconcileMetrics.Errors.WithLabelValues("node_update")); count != 1 {
+		t.Errorf("node_update reconcile errors = %v, expected 1", count)
+	}
+}
@@ -0,0 +1,170 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/yaml"
+)
+
+// Policy holds platform limits checked by the controller before any request reaches the Hyperstack API.
+// Empty lists and zero limits are not enforced.
+type Policy struct {
+	// AllowedVolumeTypes restricts the `type` StorageClass parameter
+	AllowedVolumeTypes []string `json:"allowedVolumeTypes,omitempty"`
+	// AllowedEnvironments restricts the environments volumes are created in
+	AllowedEnvironments []string `json:"allowedEnvironments,omitempty"`
+	// VolumeTypes holds size limits per volume type
+	VolumeTypes map[string]SizeLimits `json:"volumeTypes,omitempty"`
+	// NamespaceQuota applies to every namespace without an entry in Namespaces
+	NamespaceQuota *NamespaceQuota `json:"namespaceQuota,omitempty"`
+	// Namespaces holds quotas for individual namespaces
+	Namespaces map[string]NamespaceQuota `json:"namespaces,omitempty"`
+}
+
+type SizeLimits struct {
+	MinSizeGiB int `json:"minSizeGiB,omitempty"`
+	MaxSizeGiB int `json:"maxSizeGiB,omitempty"`
+}
+
+type NamespaceQuota struct {
+	MaxVolumes  int `json:"maxVolumes,omitempty"`
+	MaxTotalGiB int `json:"maxTotalGiB,omitempty"`
+}
+
+// Usage is the number and total size of the volumes of a namespace
+type Usage struct {
+	Volumes  int
+	TotalGiB int
+}
+
+// Violation is returned when a request breaks the policy. It carries the gRPC code the request
+// should fail with, so that it can be returned from a CSI call as is.
+type Violation struct {
+	Code   codes.Code
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return "policy violation: " + v.Reason
+}
+
+func (v *Violation) GRPCStatus() *status.Status {
+	return status.New(v.Code, v.Error())
+}
+
+func violation(code codes.Code, format string, args ...interface{}) error {
+	return &Violation{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Load reads a policy from a YAML or JSON file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate checks that the limits of the policy are consistent
+func (p *Policy) Validate() error {
+	for volType, l := range p.VolumeTypes {
+		if l.MinSizeGiB < 0 || l.MaxSizeGiB < 0 {
+			return fmt.Errorf("volume type %q: sizes must not be negative", volType)
+		}
+		if l.MaxSizeGiB > 0 && l.MinSizeGiB > l.MaxSizeGiB {
+			return fmt.Errorf("volume type %q: minSizeGiB %d is greater than maxSizeGiB %d", volType, l.MinSizeGiB, l.MaxSizeGiB)
+		}
+	}
+	quotas := map[string]NamespaceQuota{}
+	for ns, q := range p.Namespaces {
+		quotas["namespace "+ns] = q
+	}
+	if p.NamespaceQuota != nil {
+		quotas["namespaceQuota"] = *p.NamespaceQuota
+	}
+	for name, q := range quotas {
+		if q.MaxVolumes < 0 || q.MaxTotalGiB < 0 {
+			return fmt.Errorf("%s: limits must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// CheckVolume checks that the volume type is allowed and the size is within the limits of the type.
+// A size outside the limits fails with codes.OutOfRange.
+func (p *Policy) CheckVolume(volType string, sizeGiB int) error {
+	if p == nil {
+		return nil
+	}
+	if len(p.AllowedVolumeTypes) > 0 && !contains(p.AllowedVolumeTypes, volType) {
+		if volType == "" {
+			return violation(codes.InvalidArgument, "the volume type must be set, allowed types are %v", p.AllowedVolumeTypes)
+		}
+		return violation(codes.InvalidArgument, "volume type %q is not allowed, allowed types are %v", volType, p.AllowedVolumeTypes)
+	}
+	l, ok := p.VolumeTypes[volType]
+	if !ok {
+		return nil
+	}
+	if l.MinSizeGiB > 0 && sizeGiB < l.MinSizeGiB {
+		return violation(codes.OutOfRange, "size %d GiB is below the minimum of %d GiB for volume type %q", sizeGiB, l.MinSizeGiB, volType)
+	}
+	if l.MaxSizeGiB > 0 && sizeGiB > l.MaxSizeGiB {
+		return violation(codes.OutOfRange, "size %d GiB is above the maximum of %d GiB for volume type %q", sizeGiB, l.MaxSizeGiB, volType)
+	}
+	return nil
+}
+
+// CheckEnvironment checks that volumes may be created in the environment
+func (p *Policy) CheckEnvironment(environment string) error {
+	if p == nil || len(p.AllowedEnvironments) == 0 || contains(p.AllowedEnvironments, environment) {
+		return nil
+	}
+	return violation(codes.InvalidArgument, "environment %q is not allowed, allowed environments are %v", environment, p.AllowedEnvironments)
+}
+
+// NamespaceQuotaFor returns the quota of the namespace, or nil if it has none
+func (p *Policy) NamespaceQuotaFor(namespace string) *NamespaceQuota {
+	if p == nil || namespace == "" {
+		return nil
+	}
+	if q, ok := p.Namespaces[namespace]; ok {
+		return &q
+	}
+	return p.NamespaceQuota
+}
+
+// CheckNamespaceQuota checks that adding volumes and GiB to the current usage of the namespace stays
+// within its quota. A quota that would be exceeded fails with codes.ResourceExhausted.
+func (p *Policy) CheckNamespaceQuota(namespace string, usage Usage, addVolumes int, addGiB int) error {
+	q := p.NamespaceQuotaFor(namespace)
+	if q == nil {
+		return nil
+	}
+	if q.MaxVolumes > 0 && usage.Volumes+addVolumes > q.MaxVolumes {
+		return violation(codes.ResourceExhausted, "namespace %q already has %d of at most %d volumes", namespace, usage.Volumes, q.MaxVolumes)
+	}
+	if q.MaxTotalGiB > 0 && usage.TotalGiB+addGiB > q.MaxTotalGiB {
+		return violation(codes.ResourceExhausted, "namespace %q would use %d GiB of at most %d GiB", namespace, usage.TotalGiB+addGiB, q.MaxTotalGiB)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testPolicy = `
+allowedVolumeTypes: [Cloud-SSD]
+allowedEnvironments: [CANADA-1]
+volumeTypes:
+  Cloud-SSD:
+    minSizeGiB: 10
+    maxSizeGiB: 1000
+namespaceQuota:
+  maxVolumes: 2
+  maxTotalGiB: 100
+namespaces:
+  ml-training:
+    maxTotalGiB: 5000
+`
+
+func loadTestPolicy(t *testing.T, content string) (*Policy, error) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid_policy", testPolicy, false},
+		{"empty_policy", "", false},
+		{"unknown_field", "maxSize: 10", true},
+		{"min_above_max", "volumeTypes: {Cloud-SSD: {minSizeGiB: 100, maxSizeGiB: 10}}", true},
+		{"negative_quota", "namespaces: {default: {maxVolumes: -1}}", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadTestPolicy(t, tc.content)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicyChecks(t *testing.T) {
+	p, err := loadTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		check    func() error
+		expected codes.Code
+	}{
+		{"allowed_volume", func() error { return p.CheckVolume("Cloud-SSD", 50) }, codes.OK},
+		{"disallowed_type", func() error { return p.CheckVolume("Cloud-HDD", 50) }, codes.InvalidArgument},
+		{"missing_type", func() error { return p.CheckVolume("", 50) }, codes.InvalidArgument},
+		{"below_minimum", func() error { return p.CheckVolume("Cloud-SSD", 5) }, codes.OutOfRange},
+		{"above_maximum", func() error { return p.CheckVolume("Cloud-SSD", 1001) }, codes.OutOfRange},
+		{"allowed_environment", func() error { return p.CheckEnvironment("CANADA-1") }, codes.OK},
+		{"disallowed_environment", func() error { return p.CheckEnvironment("NORWAY-1") }, codes.InvalidArgument},
+		{"within_default_quota", func() error { return p.CheckNamespaceQuota("default", Usage{Volumes: 1, TotalGiB: 50}, 1, 50) }, codes.OK},
+		{"too_many_volumes", func() error { return p.CheckNamespaceQuota("default", Usage{Volumes: 2, TotalGiB: 20}, 1, 10) }, codes.ResourceExhausted},
+		{"too_many_gib", func() error { return p.CheckNamespaceQuota("default", Usage{Volumes: 1, TotalGiB: 60}, 1, 50) }, codes.ResourceExhausted},
+		{"namespace_override", func() error { return p.CheckNamespaceQuota("ml-training", Usage{Volumes: 10, TotalGiB: 2000}, 1, 1000) }, codes.OK},
+		{"no_namespace", func() error { return p.CheckNamespaceQuota("", Usage{Volumes: 10}, 1, 10) }, codes.OK},
+		{"nil_policy", func() error { return (*Policy)(nil).CheckVolume("Cloud-HDD", 1) }, codes.OK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := status.Code(tc.check()); got != tc.expected {
+				t.Errorf("got code %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"k8s.io/klog/v2"
+)
+
+// Opts configures the OTLP trace exporter. The standard OTEL_* environment variables,
+// e.g. OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_SERVICE_NAME or OTEL_TRACES_SAMPLER, apply as well.
+type Opts struct {
+	// Enabled turns tracing on, spans are dropped otherwise
+	Enabled bool
+	// Endpoint is the host:port of the OTLP gRPC collector, overriding OTEL_EXPORTER_OTLP_ENDPOINT
+	Endpoint string
+	// Insecure disables TLS to the collector
+	Insecure bool
+	// ServiceName is used when OTEL_SERVICE_NAME is not set
+	ServiceName string
+}
+
+// Setup installs the global tracer provider and propagator and returns a function flushing
+// and stopping the exporter. When tracing is disabled the global no-op provider is kept.
+func Setup(ctx context.Context, opts Opts) (func(context.Context) error, error) {
+	if !opts.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporterOpts := []otlptracegrpc.Option{}
+	if opts.Endpoint != "" {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+	}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", opts.ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	tp := NewProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	klog.Info("Tracing enabled, exporting spans over OTLP gRPC")
+	return tp.Shutdown, nil
+}
+
+// NewProvider installs a tracer provider built from opts as the global provider, e.g. with an
+// in-memory exporter in tests, and the W3C trace context and baggage propagators
+func NewProvider(opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp
+}
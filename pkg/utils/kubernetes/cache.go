@@ -0,0 +1,107 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheResync is how often the informers replay their objects, as a safety net for missed watch events
+const cacheResync = 10 * time.Minute
+
+// Cache serves the node the driver runs on and, optionally, all PersistentVolumes from shared
+// informers, so that lookups in CSI calls and background loops do not reach the API server
+type Cache struct {
+	factories []informers.SharedInformerFactory
+	synced    []cache.InformerSynced
+
+	nodes corelisters.NodeLister
+	pvs   corelisters.PersistentVolumeLister
+}
+
+// NewCache returns a cache of the named node, and of all PersistentVolumes if persistentVolumes is
+// set. Only the named node is watched, so that a node plugin does not cache every node of the cluster.
+func NewCache(clientset kubernetes.Interface, nodeName string, persistentVolumes bool) *Cache {
+	c := &Cache{}
+
+	nodeFactory := informers.NewSharedInformerFactoryWithOptions(clientset, cacheResync,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", nodeName).String()
+		}))
+	nodeInformer := nodeFactory.Core().V1().Nodes()
+	c.nodes = nodeInformer.Lister()
+	c.synced = append(c.synced, nodeInformer.Informer().HasSynced)
+	c.factories = append(c.factories, nodeFactory)
+
+	if persistentVolumes {
+		pvFactory := informers.NewSharedInformerFactory(clientset, cacheResync)
+		pvInformer := pvFactory.Core().V1().PersistentVolumes()
+		c.pvs = pvInformer.Lister()
+		c.synced = append(c.synced, pvInformer.Informer().HasSynced)
+		c.factories = append(c.factories, pvFactory)
+	}
+	return c
+}
+
+// Start runs the informers until ctx is done. It does not wait for them to sync, see HasSynced.
+func (c *Cache) Start(ctx context.Context) {
+	for _, f := range c.factories {
+		f.Start(ctx.Done())
+	}
+}
+
+// WaitForSync waits until the informers have synced or ctx is done
+func (c *Cache) WaitForSync(ctx context.Context) error {
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return fmt.Errorf("kubernetes cache did not sync: %v", ctx.Err())
+	}
+	return nil
+}
+
+// HasSynced reports whether the informers have listed their objects once. Until then the cache
+// may miss objects and callers should read from the API server.
+func (c *Cache) HasSynced() bool {
+	for _, synced := range c.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// GetNodeLabel returns the value of a label of the cached node
+func (c *Cache) GetNodeLabel(nodeName string, labelKey string) (string, error) {
+	if nodeName == "" {
+		return "", fmt.Errorf("node name not available")
+	}
+	node, err := c.nodes.Get(nodeName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("node %s not found in cache", nodeName)
+		}
+		return "", fmt.Errorf("failed to get node %s: %v", nodeName, err)
+	}
+	if value, exists := node.Labels[labelKey]; exists {
+		return value, nil
+	}
+	return "", fmt.Errorf("label %s not found on node %s", labelKey, nodeName)
+}
+
+// ListPersistentVolumes returns the cached PersistentVolumes. The objects are shared with the cache
+// and must not be modified.
+func (c *Cache) ListPersistentVolumes() ([]*corev1.PersistentVolume, error) {
+	if c.pvs == nil {
+		return nil, fmt.Errorf("persistent volumes are not cached")
+	}
+	return c.pvs.List(labels.Everything())
+}
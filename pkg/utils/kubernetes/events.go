@@ -0,0 +1,43 @@
+package kubernetes
+
+import (
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// eventBurst and eventQPS limit the Events recorded per object, so that a CSI call the
+	// sidecars retry in a loop does not flood the API server
+	eventBurst = 10
+	eventQPS   = 1.0 / 60
+)
+
+// NewEventRecorder returns a rate-limited recorder that writes Events through the given clientset
+func NewEventRecorder(clientset kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster(record.WithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: eventBurst,
+		QPS:       eventQPS,
+	}))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// GetCurrentPodReference returns a reference to the pod the driver runs in, taken from the
+// POD_NAME and POD_NAMESPACE environment variables, or nil when they are not set
+func GetCurrentPodReference() *corev1.ObjectReference {
+	name := os.Getenv("POD_NAME")
+	namespace := os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return &corev1.ObjectReference{
+		Kind:      "Pod",
+		Name:      name,
+		Namespace: namespace,
+	}
+}
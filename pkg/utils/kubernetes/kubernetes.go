@@ -3,49 +3,58 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"os"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/csi-hyperstack/pkg/utils/metadata"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-func GetNodeLabel(labelKey string) (string, error) {
-	// kubeconfig := "/home/administrator/Desktop/nexgen/codebase/kubeconfig"
-	// config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return "", fmt.Errorf("failed to get in-cluster config: %v", err)
+// NodeNameEnv is set from spec.nodeName through the downward API
+const NodeNameEnv = "NODE_NAME"
+
+// NewClientset returns a clientset for the cluster of the kubeconfig file, or for the cluster the
+// driver runs in if kubeconfig is empty
+func NewClientset(kubeconfig string) (*kubernetes.Clientset, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %v", kubeconfig, err)
+		}
+	} else {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get in-cluster config: %v", err)
+		}
 	}
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return "", fmt.Errorf("failed to create kubernetes client: %v", err)
-	}
-	instanceHostname, err := GetCurrentInstanHostname()
-	if err != nil {
-		return "", fmt.Errorf("failed to get node name: %v", err)
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
 	}
-	if instanceHostname == "" {
+	return clientset, nil
+}
+
+// NodeName returns the name of the Kubernetes node the driver runs on from NODE_NAME, or an empty
+// string when it is not set
+func NodeName() string {
+	return os.Getenv(NodeNameEnv)
+}
+
+// GetNodeLabelFromClient returns the value of a label of the named node
+func GetNodeLabelFromClient(ctx context.Context, clientset kubernetes.Interface, nodeName string, labelKey string) (string, error) {
+	if nodeName == "" {
 		return "", fmt.Errorf("node name not available")
 	}
 
-	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), instanceHostname, metav1.GetOptions{})
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 	if err != nil {
-		return "", fmt.Errorf("failed to get node %s: %v", instanceHostname, err)
+		return "", fmt.Errorf("failed to get node %s: %v", nodeName, err)
 	}
 	if value, exists := node.Labels[labelKey]; exists {
 		return value, nil
 	}
-	return "", fmt.Errorf("label %s not found on node %s", labelKey, instanceHostname)
-}
-
-func GetCurrentInstanHostname() (string, error) {
-	searchOrder := "metadataService,configDrive"
-
-	metadataProvider := metadata.GetMetadataProvider(searchOrder)
-	instanceHostname, err := metadataProvider.GetInstanceHostname()
-	if err != nil {
-		return "", fmt.Errorf("failed to get node name: %v", err)
-	}
-	return instanceHostname, nil
+	return "", fmt.Errorf("label %s not found on node %s", labelKey, nodeName)
 }
@@ -0,0 +1,154 @@
+package kubernetes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+const testLabelKey = "hyperstack.cloud/cluster-id"
+
+func testNode(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func testPV(name string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func TestNewClientset(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: dev
+  user:
+    token: secret
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+current-context: dev
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewClientset(kubeconfig); err != nil {
+		t.Errorf("NewClientset() error = %v", err)
+	}
+	if _, err := NewClientset(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing kubeconfig")
+	}
+}
+
+func TestNodeName(t *testing.T) {
+	t.Setenv(NodeNameEnv, "worker-1")
+	if name := NodeName(); name != "worker-1" {
+		t.Errorf("NodeName() = %q, expected worker-1", name)
+	}
+	t.Setenv(NodeNameEnv, "")
+	if name := NodeName(); name != "" {
+		t.Errorf("NodeName() = %q, expected an empty name", name)
+	}
+}
+
+func TestGetNodeLabelFromClient(t *testing.T) {
+	clientset := k8sfake.NewSimpleClientset(
+		testNode("worker-1", map[string]string{testLabelKey: "1168"}),
+		testNode("worker-2", nil),
+	)
+
+	testCases := []struct {
+		name     string
+		nodeName string
+		value    string
+		wantErr  bool
+	}{
+		{name: "label", nodeName: "worker-1", value: "1168"},
+		{name: "missing_label", nodeName: "worker-2", wantErr: true},
+		{name: "unknown_node", nodeName: "worker-3", wantErr: true},
+		{name: "no_node_name", nodeName: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := GetNodeLabelFromClient(context.Background(), clientset, tc.nodeName, testLabelKey)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("GetNodeLabelFromClient() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if value != tc.value {
+				t.Errorf("GetNodeLabelFromClient() = %q, expected %q", value, tc.value)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	clientset := k8sfake.NewSimpleClientset(
+		testNode("worker-1", map[string]string{testLabelKey: "1168"}),
+		testPV("pv-1"),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewCache(clientset, "worker-1", true)
+	if c.HasSynced() {
+		t.Error("HasSynced() = true before the cache was started")
+	}
+	c.Start(ctx)
+	syncCtx, syncCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer syncCancel()
+	if err := c.WaitForSync(syncCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := c.GetNodeLabel("worker-1", testLabelKey); err != nil || value != "1168" {
+		t.Errorf("GetNodeLabel() = %q, %v, expected 1168", value, err)
+	}
+	if _, err := c.GetNodeLabel("worker-1", "missing"); err == nil {
+		t.Error("expected an error for a missing label")
+	}
+	pvs, err := c.ListPersistentVolumes()
+	if err != nil || len(pvs) != 1 {
+		t.Fatalf("ListPersistentVolumes() = %d volumes, %v, expected 1", len(pvs), err)
+	}
+
+	// Changes reach the cache through the watch, without another list
+	node := testNode("worker-1", map[string]string{testLabelKey: "2000"})
+	if _, err := clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().PersistentVolumes().Create(ctx, testPV("pv-2"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		value, _ := c.GetNodeLabel("worker-1", testLabelKey)
+		pvs, _ := c.ListPersistentVolumes()
+		if value == "2000" && len(pvs) == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("cache not updated: label %q, %d volumes", value, len(pvs))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCacheWithoutPersistentVolumes(t *testing.T) {
+	c := NewCache(k8sfake.NewSimpleClientset(), "worker-1", false)
+	if _, err := c.ListPersistentVolumes(); err == nil {
+		t.Error("expected an error when persistent volumes are not cached")
+	}
+}
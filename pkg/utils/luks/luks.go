@@ -0,0 +1,219 @@
+package luks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/exec"
+)
+
+const (
+	cryptsetupCmd = "cryptsetup"
+	blkidCmd      = "blkid"
+
+	mapperDir    = "/dev/mapper"
+	mapperPrefix = "hyperstack-"
+
+	// exit status returned by `cryptsetup isLuks` for a device without a LUKS header
+	isLuksNotLuksStatus = 1
+	// exit status returned by blkid when it finds no signature on the device
+	blkidNoSignatureStatus = 2
+)
+
+// ErrDeviceHasData is returned by Format for a device that holds a filesystem or partition table
+var ErrDeviceHasData = errors.New("device holds data")
+
+// ILuks wraps the cryptsetup operations needed to keep a volume encrypted at rest
+type ILuks interface {
+	IsLuks(device string) (bool, error)
+	Format(device string, passphrase string) error
+	Open(device string, name string, passphrase string) error
+	IsOpen(name string) (bool, error)
+	BackingDevice(name string) (string, error)
+	Close(name string) error
+	Resize(name string, passphrase string) error
+}
+
+type Luks struct {
+	Exec exec.Interface
+}
+
+var _ ILuks = &Luks{}
+
+var LInstance ILuks
+
+// GetLuksProvider returns instance of Luks
+func GetLuksProvider() ILuks {
+	if LInstance == nil {
+		LInstance = &Luks{Exec: exec.New()}
+	}
+	return LInstance
+}
+
+// MapperName returns the device-mapper name used for the given volume
+func MapperName(volumeID string) string {
+	return mapperPrefix + volumeID
+}
+
+// MapperPath returns the path of the opened LUKS device for a mapper name
+func MapperPath(name string) string {
+	return filepath.Join(mapperDir, name)
+}
+
+// ParseMapperPath returns the mapper name of a device path returned by MapperPath for a volume
+func ParseMapperPath(device string) (string, bool) {
+	name, ok := strings.CutPrefix(device, mapperDir+"/")
+	if !ok || !strings.HasPrefix(name, mapperPrefix) || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+func (l *Luks) run(passphrase *string, args ...string) ([]byte, error) {
+	cmd := l.Exec.Command(cryptsetupCmd, args...)
+	if passphrase != nil {
+		cmd.SetStdin(strings.NewReader(*passphrase))
+	}
+	return cmd.CombinedOutput()
+}
+
+// IsLuks reports whether the device carries a LUKS header
+func (l *Luks) IsLuks(device string) (bool, error) {
+	out, err := l.run(nil, "isLuks", device)
+	if err == nil {
+		return true, nil
+	}
+	var exitErr exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == isLuksNotLuksStatus {
+		return false, nil
+	}
+	return false, fmt.Errorf("cryptsetup isLuks %s failed: %v, output: %s", device, err, out)
+}
+
+// Format initialises a LUKS2 header on the device. A device that holds a filesystem or partition
+// table is refused with ErrDeviceHasData, as the header would destroy its data.
+func (l *Luks) Format(device string, passphrase string) error {
+	format, err := l.diskFormat(device)
+	if err != nil {
+		return err
+	}
+	if format != "" {
+		return fmt.Errorf("refusing to format %s with LUKS, it holds %s: %w", device, format, ErrDeviceHasData)
+	}
+	klog.Infof("Format: creating LUKS header on %s", device)
+	out, err := l.run(&passphrase, "luksFormat", "--batch-mode", "--type", "luks2", "--key-file", "-", device)
+	if err != nil {
+		return fmt.Errorf("cryptsetup luksFormat %s failed: %v, output: %s", device, err, out)
+	}
+	return nil
+}
+
+// diskFormat returns the filesystem or partition table type blkid finds on the device, or an empty
+// string for a device without any
+func (l *Luks) diskFormat(device string) (string, error) {
+	out, err := l.Exec.Command(blkidCmd, "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", device).Output()
+	if err != nil {
+		var exitErr exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitStatus() == blkidNoSignatureStatus {
+			return "", nil
+		}
+		return "", fmt.Errorf("blkid %s failed: %v, output: %s", device, err, out)
+	}
+	return parseBlkidFormat(out), nil
+}
+
+// parseBlkidFormat returns the TYPE, or else the PTTYPE, of `blkid -o export` output
+func parseBlkidFormat(out []byte) string {
+	var fsType, ptType string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "TYPE":
+			fsType = value
+		case "PTTYPE":
+			ptType = value
+		}
+	}
+	if fsType != "" {
+		return fsType
+	}
+	return ptType
+}
+
+// Open unlocks the device and exposes it as /dev/mapper/<name>
+func (l *Luks) Open(device string, name string, passphrase string) error {
+	klog.Infof("Open: opening LUKS device %s as %s", device, name)
+	out, err := l.run(&passphrase, "luksOpen", "--key-file", "-", device, name)
+	if err != nil {
+		return fmt.Errorf("cryptsetup luksOpen %s failed: %v, output: %s", device, err, out)
+	}
+	return nil
+}
+
+// IsOpen reports whether a mapper device with the given name exists
+func (l *Luks) IsOpen(name string) (bool, error) {
+	_, err := os.Stat(MapperPath(name))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// BackingDevice returns the device an open mapper device was opened from, as reported by
+// `cryptsetup status`. The mapper device stays present after its backing device is detached,
+// so callers check the returned path to tell whether the mapping is still usable.
+func (l *Luks) BackingDevice(name string) (string, error) {
+	out, err := l.run(nil, "status", name)
+	if err != nil {
+		return "", fmt.Errorf("cryptsetup status %s failed: %v, output: %s", name, err, out)
+	}
+	return parseStatusDevice(out), nil
+}
+
+// parseStatusDevice returns the "device:" field of `cryptsetup status` output, or an empty string
+// if there is none
+func parseStatusDevice(out []byte) string {
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && key == "device" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Close removes the mapper device
+func (l *Luks) Close(name string) error {
+	klog.Infof("Close: closing LUKS device %s", name)
+	out, err := l.run(nil, "luksClose", name)
+	if err != nil {
+		return fmt.Errorf("cryptsetup luksClose %s failed: %v, output: %s", name, err, out)
+	}
+	return nil
+}
+
+// Resize grows the opened LUKS device to the size of the underlying block device
+func (l *Luks) Resize(name string, passphrase string) error {
+	klog.Infof("Resize: resizing LUKS device %s", name)
+	args := []string{"resize", name}
+	var key *string
+	if passphrase != "" {
+		args = append(args, "--key-file", "-")
+		key = &passphrase
+	}
+	out, err := l.run(key, args...)
+	if err != nil {
+		return fmt.Errorf("cryptsetup resize %s failed: %v, output: %s", name, err, out)
+	}
+	return nil
+}
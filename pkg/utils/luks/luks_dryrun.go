@@ -0,0 +1,30 @@
+package luks
+
+import "k8s.io/klog/v2"
+
+// DryRun wraps an ILuks so that devices are inspected but never formatted, opened, closed or resized
+type DryRun struct {
+	ILuks
+}
+
+var _ ILuks = &DryRun{}
+
+func (dr *DryRun) Format(device string, passphrase string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "LuksFormat", "device", device)
+	return nil
+}
+
+func (dr *DryRun) Open(device string, name string, passphrase string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "LuksOpen", "device", device, "name", name)
+	return nil
+}
+
+func (dr *DryRun) Close(name string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "LuksClose", "name", name)
+	return nil
+}
+
+func (dr *DryRun) Resize(name string, passphrase string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "LuksResize", "name", name)
+	return nil
+}
@@ -0,0 +1,242 @@
+package luks
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	utilsexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+const (
+	testPassphrase = "hyperstack-test-passphrase"
+	testMapperName = "hyperstack-luks-test"
+)
+
+// setupLoopDevice creates a sparse backing file and attaches it to a free loop device.
+// The test is skipped when it cannot manage loop devices or cryptsetup is missing.
+func setupLoopDevice(t *testing.T, size int64) (string, string) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping test: loop devices require root")
+	}
+	for _, bin := range []string{"losetup", cryptsetupCmd} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("Skipping test: %s not found", bin)
+		}
+	}
+
+	backing := filepath.Join(t.TempDir(), "disk.img")
+	f, err := os.Create(backing)
+	if err != nil {
+		t.Fatalf("failed to create backing file: %v", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("failed to size backing file: %v", err)
+	}
+	_ = f.Close()
+
+	out, err := exec.Command("losetup", "--find", "--show", backing).CombinedOutput()
+	if err != nil {
+		t.Skipf("Skipping test: unable to attach loop device: %v, output: %s", err, out)
+	}
+	device := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		_ = exec.Command("losetup", "--detach", device).Run()
+	})
+	return device, backing
+}
+
+func TestLuksLifecycle(t *testing.T) {
+	device, backing := setupLoopDevice(t, 64*1024*1024)
+	l := &Luks{Exec: utilsexec.New()}
+
+	isLuks, err := l.IsLuks(device)
+	if err != nil {
+		t.Fatalf("IsLuks failed: %v", err)
+	}
+	if isLuks {
+		t.Fatalf("fresh loop device %s should not be LUKS", device)
+	}
+
+	if err := l.Format(device, testPassphrase); err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	isLuks, err = l.IsLuks(device)
+	if err != nil || !isLuks {
+		t.Fatalf("device %s should be LUKS after Format (err: %v)", device, err)
+	}
+
+	if err := l.Open(device, testMapperName, "wrong-passphrase"); err == nil {
+		_ = l.Close(testMapperName)
+		t.Fatalf("Open should fail with a wrong passphrase")
+	}
+
+	if err := l.Open(device, testMapperName, testPassphrase); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close(testMapperName) })
+
+	open, err := l.IsOpen(testMapperName)
+	if err != nil || !open {
+		t.Fatalf("mapper %s should be open (err: %v)", testMapperName, err)
+	}
+	backingDevice, err := l.BackingDevice(testMapperName)
+	if err != nil || backingDevice != device {
+		t.Fatalf("BackingDevice = %q, expected %s (err: %v)", backingDevice, device, err)
+	}
+
+	// Grow the backing file and the loop device, then the LUKS layer on top of it
+	if err := os.Truncate(backing, 128*1024*1024); err != nil {
+		t.Fatalf("failed to grow backing file: %v", err)
+	}
+	if out, err := exec.Command("losetup", "--set-capacity", device).CombinedOutput(); err != nil {
+		t.Fatalf("failed to refresh loop device capacity: %v, output: %s", err, out)
+	}
+	if err := l.Resize(testMapperName, testPassphrase); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+
+	if err := l.Close(testMapperName); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	open, err = l.IsOpen(testMapperName)
+	if err != nil || open {
+		t.Fatalf("mapper %s should be closed (err: %v)", testMapperName, err)
+	}
+}
+
+func TestMapperPath(t *testing.T) {
+	if got := MapperPath(MapperName("903")); got != "/dev/mapper/hyperstack-903" {
+		t.Errorf("unexpected mapper path: %s", got)
+	}
+}
+
+func TestParseMapperPath(t *testing.T) {
+	testCases := []struct {
+		device string
+		name   string
+	}{
+		{device: MapperPath(MapperName("903")), name: "hyperstack-903"},
+		{device: "/dev/mapper/other-903"},
+		{device: "/dev/vdb"},
+		{device: ""},
+	}
+
+	for _, tc := range testCases {
+		name, ok := ParseMapperPath(tc.device)
+		if name != tc.name || ok != (tc.name != "") {
+			t.Errorf("ParseMapperPath(%q) = %q, %v, expected %q", tc.device, name, ok, tc.name)
+		}
+	}
+}
+
+func TestParseStatusDevice(t *testing.T) {
+	testCases := []struct {
+		name   string
+		out    string
+		device string
+	}{
+		{
+			name: "active",
+			out: `/dev/mapper/hyperstack-903 is active and is in use.
+  type:    LUKS2
+  cipher:  aes-xts-plain64
+  keysize: 512 bits
+  key location: keyring
+  device:  /dev/vdb
+  sector size:  512
+  offset:  32768 sectors
+  size:    20938752 sectors
+  mode:    read/write
+`,
+			device: "/dev/vdb",
+		},
+		{
+			name:   "backing_device_gone",
+			out:    "/dev/mapper/hyperstack-903 is active.\n  type:    LUKS2\n  device:  (null)\n",
+			device: "(null)",
+		},
+		{
+			name: "no_device",
+			out:  "/dev/mapper/hyperstack-903 is inactive.\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if device := parseStatusDevice([]byte(tc.out)); device != tc.device {
+				t.Errorf("parseStatusDevice() = %q, expected %q", device, tc.device)
+			}
+		})
+	}
+}
+
+func TestFormatRefusesDeviceWithData(t *testing.T) {
+	testCases := []struct {
+		name string
+		// blkid is the output and error of blkid for the device
+		blkid      string
+		blkidErr   error
+		luksFormat bool
+		hasData    bool
+		err        bool
+	}{
+		{
+			name:       "empty_device",
+			blkidErr:   testingexec.FakeExitError{Status: blkidNoSignatureStatus},
+			luksFormat: true,
+		},
+		{
+			name:    "filesystem",
+			blkid:   "DEVNAME=/dev/vdb\nTYPE=ext4\n",
+			hasData: true,
+			err:     true,
+		},
+		{
+			name:    "partition_table",
+			blkid:   "DEVNAME=/dev/vdb\nPTTYPE=gpt\n",
+			hasData: true,
+			err:     true,
+		},
+		{
+			name:     "blkid_failed",
+			blkidErr: testingexec.FakeExitError{Status: 4},
+			err:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var commands []string
+			fakeCmd := func(out string, err error) testingexec.FakeCommandAction {
+				return func(cmd string, args ...string) utilsexec.Cmd {
+					commands = append(commands, cmd+" "+args[0])
+					return &testingexec.FakeCmd{
+						OutputScript:         []testingexec.FakeAction{func() ([]byte, []byte, error) { return []byte(out), nil, err }},
+						CombinedOutputScript: []testingexec.FakeAction{func() ([]byte, []byte, error) { return []byte(out), nil, err }},
+					}
+				}
+			}
+			fexec := &testingexec.FakeExec{CommandScript: []testingexec.FakeCommandAction{
+				fakeCmd(tc.blkid, tc.blkidErr),
+				fakeCmd("", nil),
+			}}
+			l := &Luks{Exec: fexec}
+
+			err := l.Format("/dev/vdb", testPassphrase)
+			if (err != nil) != tc.err {
+				t.Fatalf("Format() error = %v, expected error %v", err, tc.err)
+			}
+			if hasData := errors.Is(err, ErrDeviceHasData); hasData != tc.hasData {
+				t.Errorf("Format() error = %v, expected ErrDeviceHasData %v", err, tc.hasData)
+			}
+			if ran := len(commands) == 2; ran != tc.luksFormat {
+				t.Errorf("ran %v, expected luksFormat %v", commands, tc.luksFormat)
+			}
+		})
+	}
+}
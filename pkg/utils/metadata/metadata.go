@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -36,6 +37,9 @@ const (
 
 	// ConfigDriveID is used as an identifier on the metadata search order configuration.
 	ConfigDriveID = "configDrive"
+
+	// DefaultSearchOrder is used when no search order is configured
+	DefaultSearchOrder = MetadataID + "," + ConfigDriveID
 )
 
 // ErrBadMetadata is used to indicate a problem parsing data from metadata server
@@ -54,8 +58,10 @@ type MetadataOpts = Opts
 // revive:enable:exported
 // Opts is used for configuring how to talk to metadata service or config drive
 type Opts struct {
-	SearchOrder    string          `gcfg:"search-order"`
-	RequestTimeout util.MyDuration `gcfg:"request-timeout"`
+	// SearchOrder is a comma separated list of metadata sources, by default DefaultSearchOrder
+	SearchOrder string
+	// RequestTimeout of a metadata service request, 0 disables it
+	RequestTimeout util.MyDuration
 }
 
 // DeviceMetadata is a single/simplified data structure for all kinds of device metadata types.
@@ -82,7 +88,7 @@ type Metadata struct {
 }
 
 type metadataService struct {
-	searchOrder string
+	opts Opts
 }
 
 // IMetadata implements GetInstanceID & GetAvailabilityZone
@@ -95,9 +101,15 @@ type IMetadata interface {
 
 // GetMetadataProvider retrieves instance of IMetadata
 func GetMetadataProvider(order string) IMetadata {
-	metadataService := &metadataService{}
-	metadataService.searchOrder = order
-	return metadataService
+	return GetMetadataProviderWithOpts(Opts{SearchOrder: order})
+}
+
+// GetMetadataProviderWithOpts retrieves instance of IMetadata configured by opts
+func GetMetadataProviderWithOpts(opts Opts) IMetadata {
+	if opts.SearchOrder == "" {
+		opts.SearchOrder = DefaultSearchOrder
+	}
+	return &metadataService{opts: opts}
 }
 
 // Set sets the value of metadatacache
@@ -177,17 +189,17 @@ func getFromConfigDrive(metadataVersion string) (*Metadata, error) {
 	return parseMetadata(f)
 }
 
-func noProxyHTTPClient() *http.Client {
+func noProxyHTTPClient(timeout time.Duration) *http.Client {
 	noProxyTransport := http.DefaultTransport.(*http.Transport).Clone()
 	noProxyTransport.Proxy = nil
-	return &http.Client{Transport: noProxyTransport}
+	return &http.Client{Transport: noProxyTransport, Timeout: timeout}
 }
 
-func getFromMetadataService(metadataVersion string) (*Metadata, error) {
+func getFromMetadataService(metadataVersion string, timeout time.Duration) (*Metadata, error) {
 	// Try to get JSON from metadata server.
 	metadataURL := getMetadataURL(metadataVersion)
 	klog.V(4).Infof("Attempting to fetch metadata from %s, ignoring proxy settings", metadataURL)
-	resp, err := noProxyHTTPClient().Get(metadataURL)
+	resp, err := noProxyHTTPClient(timeout).Get(metadataURL)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching %s: %v", metadataURL, err)
 	}
@@ -209,7 +221,7 @@ func GetDevicePath(volumeID string) (string, error) {
 	//
 	// We're avoiding using cached metadata (or the configdrive),
 	// relying on the metadata service.
-	instanceMetadata, err := getFromMetadataService(defaultMetadataVersion)
+	instanceMetadata, err := getFromMetadataService(defaultMetadataVersion, 0)
 	if err != nil {
 		klog.Errorf("Could not retrieve instance metadata: %v", err)
 		return "", fmt.Errorf("could not retrieve instance metadata: %v", err)
@@ -250,6 +262,11 @@ func GetDevicePath(volumeID string) (string, error) {
 // Get retrieves metadata from either config drive or metadata service.
 // Search order depends on the order set in config file.
 func Get(order string) (*Metadata, error) {
+	return get(Opts{SearchOrder: order})
+}
+
+func get(opts Opts) (*Metadata, error) {
+	order := opts.SearchOrder
 	if metadataCache == nil {
 		var md *Metadata
 		var err error
@@ -261,7 +278,7 @@ func Get(order string) (*Metadata, error) {
 			case ConfigDriveID:
 				md, err = getFromConfigDrive(defaultMetadataVersion)
 			case MetadataID:
-				md, err = getFromMetadataService(defaultMetadataVersion)
+				md, err = getFromMetadataService(defaultMetadataVersion, opts.RequestTimeout.Duration)
 			default:
 				err = fmt.Errorf("%s is not a valid metadata search order option. Supported options are %s and %s", id, ConfigDriveID, MetadataID)
 			}
@@ -280,7 +297,7 @@ func Get(order string) (*Metadata, error) {
 }
 
 func (m *metadataService) GetInstanceHostname() (string, error) {
-	md, err := Get(m.searchOrder)
+	md, err := get(m.opts)
 	if err != nil {
 		return "", err
 	}
@@ -289,7 +306,7 @@ func (m *metadataService) GetInstanceHostname() (string, error) {
 
 // GetInstanceID return instance ID of the node
 func (m *metadataService) GetInstanceID() (string, error) {
-	md, err := Get(m.searchOrder)
+	md, err := get(m.opts)
 	if err != nil {
 		return "", err
 	}
@@ -297,7 +314,7 @@ func (m *metadataService) GetInstanceID() (string, error) {
 }
 
 func (m *metadataService) GetHyperstackVMId() (string, error) {
-	md, err := Get(m.searchOrder)
+	md, err := get(m.opts)
 	if err != nil {
 		return "", err
 	}
@@ -307,7 +324,7 @@ func (m *metadataService) GetHyperstackVMId() (string, error) {
 
 // GetAvailabilityZone returns AZ of the node
 func (m *metadataService) GetAvailabilityZone() (string, error) {
-	md, err := Get(m.searchOrder)
+	md, err := get(m.opts)
 	if err != nil {
 		return "", err
 	}
@@ -163,6 +163,6 @@ func TestGetFromMetadataService(t *testing.T) {
 		}(os.LookupEnv("HTTP_PROXY"))
 
 		os.Setenv("HTTP_PROXY", fakeProxy.URL)
-		_, _ = getFromMetadataService("")
+		_, _ = getFromMetadataService("", 0)
 	})
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -323,3 +324,34 @@ func (m *Mount) GetDeviceStats(path string) (*DeviceStats, error) {
 		UsedInodes:      int64(statfs.Files) - int64(statfs.Ffree),
 	}, nil
 }
+
+// SharedMountPoint returns the mount point path is on and whether that mount has shared propagation,
+// read from a mountinfo file such as /proc/self/mountinfo. Volumes mounted by the node service only
+// become visible to the kubelet and pods through a shared (Bidirectional) mount of the kubelet directory.
+func SharedMountPoint(mountInfoPath string, path string) (string, bool, error) {
+	infos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return "", false, err
+	}
+
+	path = filepath.Clean(path)
+	var best *mount.MountInfo
+	for i := range infos {
+		mp := infos[i].MountPoint
+		if mp != path && mp != "/" && !strings.HasPrefix(path, mp+"/") {
+			continue
+		}
+		if best == nil || len(mp) >= len(best.MountPoint) {
+			best = &infos[i]
+		}
+	}
+	if best == nil {
+		return "", false, fmt.Errorf("no mount found for %s", path)
+	}
+	for _, field := range best.OptionalFields {
+		if strings.HasPrefix(field, "shared:") {
+			return best.MountPoint, true, nil
+		}
+	}
+	return best.MountPoint, false, nil
+}
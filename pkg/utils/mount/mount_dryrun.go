@@ -0,0 +1,30 @@
+package mount
+
+import "k8s.io/klog/v2"
+
+// DryRun wraps an IMount so that mount points are inspected but never created, mounted or unmounted
+type DryRun struct {
+	IMount
+}
+
+var _ IMount = &DryRun{}
+
+func (dr *DryRun) Mount(source, target, fstype string, options []string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "Mount", "source", source, "target", target, "fsType", fstype, "options", options)
+	return nil
+}
+
+func (dr *DryRun) UnmountPath(mountPath string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "Unmount", "target", mountPath)
+	return nil
+}
+
+func (dr *DryRun) MakeFile(pathname string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "MakeFile", "path", pathname)
+	return nil
+}
+
+func (dr *DryRun) MakeDir(pathname string) error {
+	klog.InfoS("Dry run: skipping mutating call", "action", "MakeDir", "path", pathname)
+	return nil
+}
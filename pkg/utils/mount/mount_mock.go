@@ -33,10 +33,18 @@ func NewFakeMounter() *mount.FakeMounter {
 func NewFakeSafeFormatAndMounter() *mount.SafeFormatAndMount {
 	return &mount.SafeFormatAndMount{
 		Interface: NewFakeMounter(),
-		Exec:      &exec.FakeExec{DisableScripts: true},
+		Exec: &exec.FakeExec{
+			DisableScripts: true,
+			LookPathFunc:   func(file string) (string, error) { return file, nil },
+		},
 	}
 }
 
+// NewFakeMount returns an IMount that records mounts in memory and never runs a command
+func NewFakeMount() IMount {
+	return &Mount{BaseMounter: NewFakeSafeFormatAndMounter()}
+}
+
 // GetInstanceID provides a mock function with given fields:
 func (_m *MountMock) GetInstanceID() (string, error) {
 	ret := _m.Called()
@@ -0,0 +1,35 @@
+package util
+
+import "encoding/json"
+
+const redacted = "[REDACTED]"
+
+// Secret holds a credential such as the Hyperstack API key. It is redacted when formatted with any
+// fmt verb, marshalled to JSON or logged, so structs holding it can be printed safely.
+// Value returns the credential itself.
+type Secret string
+
+// Value returns the unredacted credential
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// MarshalLog implements logr.Marshaler so that structured loggers never see the credential
+func (s Secret) MarshalLog() interface{} {
+	return s.String()
+}
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSecretRedaction(t *testing.T) {
+	const key = "sk-live-0123456789"
+	opts := struct {
+		Endpoint string
+		ApiKey   Secret
+	}{"unix:///csi/csi.sock", Secret(key)}
+
+	testCases := []struct {
+		name   string
+		format func() string
+	}{
+		{"v", func() string { return fmt.Sprintf("%v", opts) }},
+		{"plus_v", func() string { return fmt.Sprintf("%+v", opts) }},
+		{"sharp_v", func() string { return fmt.Sprintf("%#v", opts) }},
+		{"pointer", func() string { return fmt.Sprintf("%#v", &opts) }},
+		{"s", func() string { return fmt.Sprintf("%s", opts.ApiKey) }},
+		{"q", func() string { return fmt.Sprintf("%q", opts.ApiKey) }},
+		{"json", func() string { b, _ := json.Marshal(opts); return string(b) }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := tc.format()
+			if strings.Contains(out, key) || !strings.Contains(out, redacted) {
+				t.Errorf("secret is not redacted: %s", out)
+			}
+		})
+	}
+
+	if opts.ApiKey.Value() != key {
+		t.Errorf("Value() = %q, expected the credential", opts.ApiKey.Value())
+	}
+	if Secret("").String() != "" {
+		t.Errorf("an empty secret should format as empty")
+	}
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -9,6 +11,9 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// ErrNotFound is wrapped by the Hyperstack clients when the requested resource does not exist
+var ErrNotFound = errors.New("not found")
+
 // CutString255 makes sure the string length doesn't exceed 255, which is usually the maximum string length in OpenStack.
 func CutString255(original string) string {
 	ret := original
@@ -107,3 +112,21 @@ func GetEnvFromTopology(topologyKey string, requirement *csi.TopologyRequirement
 
 	return zone
 }
+
+// ParseTags parses a comma separated list of key=value pairs, e.g. "team=storage,env=prod"
+func ParseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		tags[key] = strings.TrimSpace(kv[1])
+	}
+	return tags, nil
+}
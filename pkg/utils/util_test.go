@@ -0,0 +1,42 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		expected  map[string]string
+		expectErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"single", "team=storage", map[string]string{"team": "storage"}, false},
+		{"multiple_with_spaces", " team=storage , cost-center = 42 ", map[string]string{"team": "storage", "cost-center": "42"}, false},
+		{"empty_value", "team=", map[string]string{"team": ""}, false},
+		{"value_with_equals", "query=a=b", map[string]string{"query": "a=b"}, false},
+		{"trailing_comma", "team=storage,", map[string]string{"team": "storage"}, false},
+		{"missing_value", "team", nil, true},
+		{"missing_key", "=storage", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tags, err := ParseTags(tc.input)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error for %q, got %v", tc.input, tags)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %v", tc.input, err)
+			}
+			if !reflect.DeepEqual(tags, tc.expected) {
+				t.Errorf("unexpected tags for %q: %v", tc.input, tags)
+			}
+		})
+	}
+}